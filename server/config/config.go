@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"io/fs"
 	"log"
 	"os"
 
@@ -12,7 +14,11 @@ type EnvValue interface {
 }
 
 func init() {
+	// The .env file is optional, settings may come from the environment
 	err := godotenv.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
 	if err != nil {
 		log.Fatal("Error loading .env file", err.Error())
 	}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/integems/report-agent/config"
+)
+
+type contextKey string
+
+func init() {
+	// Tokens carry their issue time in milliseconds, the scale session
+	// revocations are recorded in, so a token issued right after a
+	// revocation is not taken for one issued before it
+	jwt.TimePrecision = time.Millisecond
+}
+
+const userContextKey contextKey = "user"
+
+// Claims holds the identity carried by an access token
+type Claims struct {
+	UserId string `json:"userId"`
+	Email  string `json:"email"`
+	Image  string `json:"image"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	// DeviceId ties the access token to the refresh token it was issued with
+	DeviceId string `json:"deviceId,omitempty"`
+	jwt.RegisteredClaims
+}
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// developmentSecret is only accepted outside production
+const developmentSecret = "integems"
+
+// minSecretLength is the minimum JWT_SECRET length accepted in production
+const minSecretLength = 32
+
+func jwtSecret() []byte {
+	return []byte(config.GetEnv("JWT_SECRET", developmentSecret))
+}
+
+// IsProduction reports whether the server runs with APP_ENV=production
+func IsProduction() bool {
+	return config.GetEnv("APP_ENV", "development") == "production"
+}
+
+// ValidateSecret refuses weak or missing signing secrets in production
+func ValidateSecret() error {
+	secret := config.GetEnv("JWT_SECRET", "")
+	if !IsProduction() {
+		if secret == "" {
+			log.Println("JWT_SECRET is not set, using the development secret")
+		}
+		return nil
+	}
+	if secret == "" || secret == developmentSecret {
+		return errors.New("JWT_SECRET must be set in production")
+	}
+	if len(secret) < minSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d characters in production", minSecretLength)
+	}
+	return nil
+}
+
+// AccessTokenTTL is how long an access token stays valid
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// RefreshTokenTTL is how long an unused refresh token stays valid
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(config.GetEnv(name, ""))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// GenerateToken signs the claims with HS256
+func GenerateToken(claims Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret())
+}
+
+// ParseToken verifies the signature and registered claims of a token
+func ParseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !token.Valid || claims.UserId == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// WithUser returns a copy of ctx carrying the authenticated user
+func WithUser(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, userContextKey, claims)
+}
+
+// UserFromContext returns the authenticated user stored in ctx
+func UserFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(userContextKey).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// NewOpaqueToken returns a random URL-safe token and the hash to persist for it
+func NewOpaqueToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the SHA-256 hex digest of an opaque token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
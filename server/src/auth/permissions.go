@@ -0,0 +1,82 @@
+package auth
+
+// Roles stored in the users.role column
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+
+	// Legacy role names that are still present in existing rows
+	RoleSuperAdmin = "superadmin"
+	RoleUser       = "user"
+)
+
+// DefaultRole is assigned to self-registered accounts
+const DefaultRole = RoleUser
+
+type Permission string
+
+const (
+	PermManageUsers     Permission = "users:manage"
+	PermUpdateProfile   Permission = "profile:update"
+	PermReadDocuments   Permission = "documents:read"
+	PermWriteDocuments  Permission = "documents:write"
+	PermManageDocuments Permission = "documents:manage"
+	PermUseChat         Permission = "chat:use"
+	PermReadMessages    Permission = "messages:read"
+	PermDeleteMessages  Permission = "messages:delete"
+	PermManagePrompts   Permission = "prompts:manage"
+)
+
+var viewerPermissions = []Permission{
+	PermUpdateProfile,
+	PermReadDocuments,
+	PermReadMessages,
+}
+
+var editorPermissions = append([]Permission{
+	PermWriteDocuments,
+	PermUseChat,
+	PermDeleteMessages,
+}, viewerPermissions...)
+
+var adminPermissions = append([]Permission{
+	PermManageUsers,
+	PermManageDocuments,
+	PermManagePrompts,
+}, editorPermissions...)
+
+// rolePermissions maps every known role to the permissions it grants
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:      adminPermissions,
+	RoleSuperAdmin: adminPermissions,
+	RoleEditor:     editorPermissions,
+	RoleUser:       editorPermissions,
+	RoleViewer:     viewerPermissions,
+}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants permission
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the user may act on resources owned by others
+func (c *Claims) IsAdmin() bool {
+	return HasPermission(c.Role, PermManageDocuments)
+}
+
+// Can reports whether the user's role grants permission
+func (c *Claims) Can(permission Permission) bool {
+	return HasPermission(c.Role, permission)
+}
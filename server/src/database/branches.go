@@ -0,0 +1,217 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/integems/report-agent/src/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrMessageNotFound is returned for a message that is not part of the
+// conversation
+var ErrMessageNotFound = errors.New("message not found")
+
+// messageTree holds the messages of a conversation by id and by parent.
+// Messages at the start of the conversation have parent 0.
+type messageTree struct {
+	records  map[uint]models.Message
+	children map[uint][]uint // Oldest first
+}
+
+// parentKey returns the key of the parent of a message in children
+func parentKey(record models.Message) uint {
+	if record.ParentId == nil {
+		return 0
+	}
+	return *record.ParentId
+}
+
+// loadTree loads every message of a session. The conversation is nil when
+// the session has none.
+func (s *SessionManager) loadTree(sessionId string) (*messageTree, *models.Conversation, error) {
+	conversation, err := s.Conversation(sessionId)
+	if err != nil || conversation == nil {
+		return &messageTree{}, nil, err
+	}
+
+	var records []models.Message
+	if err := s.db.Where(&models.Message{ConversationId: sessionId}).Order(`"messageId"`).Find(&records).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve messages: %w", err)
+	}
+
+	tree := &messageTree{records: make(map[uint]models.Message, len(records)), children: map[uint][]uint{}}
+	for _, record := range records {
+		tree.records[record.MessageId] = record
+		tree.children[parentKey(record)] = append(tree.children[parentKey(record)], record.MessageId)
+	}
+	return tree, conversation, nil
+}
+
+// message converts a record with its position among its alternatives
+func (t *messageTree) message(record models.Message) Message {
+	message := messageFromRecord(record)
+	if siblings := t.children[parentKey(record)]; len(siblings) > 1 {
+		message.Branches = len(siblings)
+		message.Branch = sort.Search(len(siblings), func(i int) bool { return siblings[i] >= record.MessageId }) + 1
+	}
+	return message
+}
+
+// path returns the messages from the start of the conversation to messageId
+func (t *messageTree) path(messageId uint) []Message {
+	var path []Message
+	for id, seen := messageId, 0; id != 0 && seen <= len(t.records); seen++ {
+		record, ok := t.records[id]
+		if !ok {
+			break
+		}
+		path = append(path, t.message(record))
+		id = parentKey(record)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// latestLeaf follows the newest answers from messageId to the end of its
+// branch
+func (t *messageTree) latestLeaf(messageId uint) uint {
+	for {
+		children := t.children[messageId]
+		if len(children) == 0 {
+			return messageId
+		}
+		messageId = children[len(children)-1]
+	}
+}
+
+// BranchMessage saves a message as a new answer to parentId, next to the
+// messages already answering it, and makes it the end of the active path.
+// A nil parentId starts a branch at the beginning of the conversation.
+func (s *SessionManager) BranchMessage(sessionId string, parentId *uint, message Message) (Message, error) {
+	return s.addMessage(sessionId, message, true, parentId)
+}
+
+// addMessage saves a message after parentId when branching, or after the
+// end of the active path otherwise, and makes it the end of the active path
+func (s *SessionManager) addMessage(sessionId string, message Message, branch bool, parentId *uint) (Message, error) {
+	if _, err := s.EnsureConversation(sessionId, "", ""); err != nil {
+		return Message{}, err
+	}
+
+	record, err := messageRecord(sessionId, message)
+	if err != nil {
+		return Message{}, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the conversation so concurrent messages chain in order
+		var conversation models.Conversation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&models.Conversation{ConversationId: sessionId}).First(&conversation).Error
+		if err != nil {
+			return fmt.Errorf("failed to retrieve conversation: %w", err)
+		}
+
+		record.ParentId = conversation.ActiveMessageId
+		if branch {
+			record.ParentId = parentId
+			if parentId != nil {
+				var count int64
+				if err := tx.Model(&models.Message{}).Where(&models.Message{MessageId: *parentId, ConversationId: sessionId}).Count(&count).Error; err != nil {
+					return fmt.Errorf("failed to retrieve message: %w", err)
+				}
+				if count == 0 {
+					return ErrMessageNotFound
+				}
+			}
+		}
+
+		if err := tx.Create(&record).Error; err != nil {
+			return fmt.Errorf("failed to save message: %w", err)
+		}
+		// Recently active conversations are listed first
+		err = tx.Model(&conversation).Updates(map[string]any{"activeMessageId": record.MessageId, "updatedAt": time.Now()}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update conversation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	s.invalidate(sessionId)
+	return messageFromRecord(record), nil
+}
+
+// Message returns a message of a session
+func (s *SessionManager) Message(sessionId string, messageId uint) (*models.Message, error) {
+	var record models.Message
+	err := s.db.Where(&models.Message{MessageId: messageId, ConversationId: sessionId}).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve message: %w", err)
+	}
+	return &record, nil
+}
+
+// PathTo returns the messages from the start of a session to messageId.
+// A zero messageId returns no messages.
+func (s *SessionManager) PathTo(sessionId string, messageId uint) ([]Message, error) {
+	if messageId == 0 {
+		return []Message{}, nil
+	}
+	tree, _, err := s.loadTree(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tree.records[messageId]; !ok {
+		return nil, ErrMessageNotFound
+	}
+	return tree.path(messageId), nil
+}
+
+// Branches returns messageId and its alternatives, the messages answering
+// the same message, oldest first
+func (s *SessionManager) Branches(sessionId string, messageId uint) ([]Message, error) {
+	tree, _, err := s.loadTree(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := tree.records[messageId]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	var branches []Message
+	for _, id := range tree.children[parentKey(record)] {
+		branches = append(branches, tree.message(tree.records[id]))
+	}
+	return branches, nil
+}
+
+// SwitchBranch makes the branch of messageId active, following its newest
+// answers to the end, and returns the new active path
+func (s *SessionManager) SwitchBranch(sessionId string, messageId uint) ([]Message, error) {
+	tree, conversation, err := s.loadTree(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tree.records[messageId]; !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	leaf := tree.latestLeaf(messageId)
+	if err := s.db.Model(conversation).Update("activeMessageId", leaf).Error; err != nil {
+		return nil, fmt.Errorf("failed to switch branch: %w", err)
+	}
+	s.invalidate(sessionId)
+	return tree.path(leaf), nil
+}
@@ -66,5 +66,29 @@ func ensureDatabaseExists(host, port, user, password, dbname string) {
 
 // AutoMigrateTables automatically migrates database tables
 func AutoMigrateTables(db *gorm.DB) error {
-	return db.AutoMigrate(&models.User{}, &models.Document{})
+	// Accounts created before email verification existed are trusted as verified
+	addsEmailVerified := db.Migrator().HasTable(&models.User{}) && !db.Migrator().HasColumn(&models.User{}, "emailVerified")
+
+	// Documents uploaded before versioning become their first version, whose
+	// original is still in the documents directory
+	addsVersions := db.Migrator().HasTable(&models.Document{}) && !db.Migrator().HasTable(&models.DocumentVersion{})
+
+	if err := db.AutoMigrate(&models.User{}, &models.Document{}, &models.DocumentVersion{}, &models.Conversation{}, &models.Message{}, &models.Blob{}, &models.BlobReference{}); err != nil {
+		return err
+	}
+
+	if addsEmailVerified {
+		if err := db.Model(&models.User{}).Where("1 = 1").Update("emailVerified", true).Error; err != nil {
+			return fmt.Errorf("failed to mark existing users as verified: %w", err)
+		}
+	}
+
+	if addsVersions {
+		err := db.Exec(`INSERT INTO document_versions ("documentId", version, "blobKey", type, "userId", "createdAt")
+			SELECT "documentId", 1, '', type, "userId", "createdAt" FROM documents`).Error
+		if err != nil {
+			return fmt.Errorf("failed to record document versions: %w", err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/integems/report-agent/src/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// ImportResult counts the sessions handled by ImportRedisMessages
+type ImportResult struct {
+	Imported int // Sessions copied to Postgres
+	Messages int // Messages copied to Postgres
+	Skipped  int // Sessions that already had a conversation
+	Failed   int // Sessions that could not be read or saved
+}
+
+// ImportRedisMessages copies the chat sessions kept in the legacy Redis
+// lists (messages:{sessionId}) into Postgres conversations. Sessions named
+// after a document belong to the owner of the document; the others have no
+// known owner and only admins can open them. Sessions that
+// already have a conversation are skipped, so the import can be run again;
+// the Redis keys are left in place and expire on their own.
+func ImportRedisMessages(ctx context.Context, db *gorm.DB, client *redis.Client) (ImportResult, error) {
+	var result ImportResult
+	iter := client.Scan(ctx, 0, "messages:*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		count, err := importSession(ctx, db, client, key)
+		switch {
+		case err != nil:
+			log.Printf("Failed to import %s: %v", key, err)
+			result.Failed++
+		case count < 0:
+			result.Skipped++
+		default:
+			result.Imported++
+			result.Messages += count
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return result, fmt.Errorf("failed to scan message keys: %w", err)
+	}
+	return result, nil
+}
+
+// importSession copies one Redis list and returns the number of messages,
+// or -1 when the session was imported before
+func importSession(ctx context.Context, db *gorm.DB, client *redis.Client, key string) (int, error) {
+	sessionId := strings.TrimPrefix(key, "messages:")
+	if sessionId == "" {
+		return -1, nil
+	}
+
+	var existing int64
+	if err := db.Model(&models.Conversation{}).Where(&models.Conversation{ConversationId: sessionId}).Count(&existing).Error; err != nil {
+		return 0, fmt.Errorf("failed to check conversation: %w", err)
+	}
+	if existing > 0 {
+		return -1, nil
+	}
+
+	data, err := client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve messages: %w", err)
+	}
+
+	records := make([]models.Message, 0, len(data))
+	for _, item := range data {
+		var message Message
+		if err := json.Unmarshal([]byte(item), &message); err != nil {
+			log.Printf("Failed to unmarshal message of %s: %v", key, err)
+			continue
+		}
+		record, err := messageRecord(sessionId, message)
+		if err != nil {
+			return 0, err
+		}
+		records = append(records, record)
+	}
+
+	conversation := models.Conversation{ConversationId: sessionId}
+	if len(records) > 0 && !records[0].CreatedAt.IsZero() {
+		conversation.CreatedAt = records[0].CreatedAt
+		conversation.UpdatedAt = records[len(records)-1].CreatedAt
+	}
+	for i := range records {
+		if records[i].CreatedAt.IsZero() {
+			records[i].CreatedAt = time.Now()
+		}
+	}
+
+	var document models.Document
+	err = db.Where(&models.Document{DocumentId: sessionId}).First(&document).Error
+	if err == nil {
+		conversation.DocumentId = &document.DocumentId
+		conversation.UserId = document.UserId
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("failed to retrieve document: %w", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&conversation).Error; err != nil {
+			return fmt.Errorf("failed to create conversation: %w", err)
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		// The messages form a single path ending with the last one
+		var parentId *uint
+		for i := range records {
+			records[i].ParentId = parentId
+			if err := tx.Create(&records[i]).Error; err != nil {
+				return fmt.Errorf("failed to save messages: %w", err)
+			}
+			parentId = &records[i].MessageId
+		}
+		if err := tx.Model(&conversation).UpdateColumn("activeMessageId", parentId).Error; err != nil {
+			return fmt.Errorf("failed to set active message: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
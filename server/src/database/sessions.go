@@ -0,0 +1,419 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/integems/report-agent/config"
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SessionManager stores chat sessions as conversations in Postgres. Redis
+// only caches the messages of recently used sessions.
+type SessionManager struct {
+	db     *gorm.DB
+	client *redis.Client
+	ctx    context.Context
+}
+
+type Message struct {
+	MessageId   uint      `json:"messageId,omitempty"`
+	ParentId    *uint     `json:"parentId,omitempty"`
+	Role        string    `json:"role"` // "user" or "ai"
+	Content     any       `json:"content"`
+	CreatedAt   time.Time `json:"createdAt"` // Timestamp
+	ContentType string    `json:"contentType"`
+	Tokens      int       `json:"tokens,omitempty"`     // Estimated size of the content
+	BlobKey     string    `json:"blobKey,omitempty"`    // Stored copy of an attached file
+	BlobUserId  string    `json:"blobUserId,omitempty"` // User who attached the file
+
+	// Set on AI messages
+	FinishReason  string             `json:"finishReason,omitempty"`
+	SafetyRatings []llm.SafetyRating `json:"safetyRatings,omitempty"`
+	Usage         *llm.Usage         `json:"usage,omitempty"`
+	Continuations int                `json:"continuations,omitempty"`
+	Repairs       int                `json:"repairs,omitempty"` // Requests made to fix an invalid &&json payload
+	Alternatives  []string           `json:"alternatives,omitempty"`
+	PromptVersion string             `json:"promptVersion,omitempty"`
+
+	// Set when the message has alternatives: its position among them, from
+	// 1, and their number
+	Branch   int `json:"branch,omitempty"`
+	Branches int `json:"branches,omitempty"`
+}
+
+// NewSessionManager creates a session manager on a database and a Redis cache
+func NewSessionManager(db *gorm.DB, client *redis.Client) *SessionManager {
+	return &SessionManager{
+		db:     db,
+		client: client,
+		ctx:    context.Background(),
+	}
+}
+
+// historyCacheTTL is how long the messages of a session stay in Redis
+func historyCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(config.GetEnv("HISTORY_CACHE_TTL", "1h"))
+	if err != nil || ttl <= 0 {
+		return time.Hour
+	}
+	return ttl
+}
+
+// historyKey is the Redis key caching the messages of a session. It differs
+// from the legacy messages:{id} lists so those are never mistaken for cache.
+func historyKey(sessionId string) string {
+	return fmt.Sprintf("history:%s", sessionId)
+}
+
+// Conversation returns the conversation of a session, or nil when the
+// session has no messages yet
+func (s *SessionManager) Conversation(sessionId string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := s.db.Where(&models.Conversation{ConversationId: sessionId}).First(&conversation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve conversation: %w", err)
+	}
+	return &conversation, nil
+}
+
+// EnsureConversation returns the conversation of a session and creates it
+// on first use, linked to documentId. Sessions named after a document are
+// linked to it. The owner of an existing conversation never changes.
+func (s *SessionManager) EnsureConversation(sessionId, userId, documentId string) (*models.Conversation, error) {
+	conversation, err := s.Conversation(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if conversation != nil {
+		if conversation.DocumentId == nil && documentId != "" {
+			if err := s.db.Model(conversation).Update("documentId", documentId).Error; err != nil {
+				return nil, fmt.Errorf("failed to update conversation: %w", err)
+			}
+		}
+		return conversation, nil
+	}
+
+	conversation = &models.Conversation{ConversationId: sessionId, UserId: userId}
+	if documentId != "" {
+		conversation.DocumentId = &documentId
+	} else {
+		var document models.Document
+		err = s.db.Where(&models.Document{DocumentId: sessionId}).First(&document).Error
+		if err == nil {
+			conversation.DocumentId = &document.DocumentId
+			if conversation.UserId == "" {
+				conversation.UserId = document.UserId
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to retrieve document: %w", err)
+		}
+	}
+
+	// Two requests may start the same session at once
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(conversation).Error; err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	return s.Conversation(sessionId)
+}
+
+// Attachment is the stored copy of a file attached to a message, referenced
+// by the user who attached it
+type Attachment struct {
+	BlobKey string `gorm:"column:blobKey"`
+	UserId  string `gorm:"column:blobUserId"`
+}
+
+// DeleteConversation deletes a conversation with its messages. It returns
+// the attached files that were deleted, for the caller to release.
+func (s *SessionManager) DeleteConversation(sessionId string) ([]Attachment, error) {
+	var attachments []Attachment
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if attachments, err = sessionAttachments(tx, sessionId); err != nil {
+			return err
+		}
+		if err := tx.Where(&models.Message{ConversationId: sessionId}).Delete(&models.Message{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Conversation{ConversationId: sessionId}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	s.invalidate(sessionId)
+
+	// Sessions saved before conversations existed may not be imported
+	if err := s.client.Del(s.ctx, "messages:"+sessionId).Err(); err != nil {
+		log.Printf("Failed to delete legacy messages: %v", err)
+	}
+	return attachments, nil
+}
+
+// sessionAttachments returns the files attached to a session, once per
+// message
+func sessionAttachments(tx *gorm.DB, sessionId string) ([]Attachment, error) {
+	var attachments []Attachment
+	err := tx.Model(&models.Message{}).Select(`"blobKey"`, `"blobUserId"`).
+		Where(`"conversationId" = ? AND "blobKey" <> ''`, sessionId).
+		Find(&attachments).Error
+	return attachments, err
+}
+
+// GetSessionHistory returns the active path of a session as model history
+func (s *SessionManager) GetSessionHistory(sessionId string) ([]llm.Message, error) {
+	messages, err := s.GetMessages(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	return ChatHistory(messages), nil
+}
+
+// ChatHistory converts messages to model history. The first message is a
+// placeholder for the instructions of the model.
+func ChatHistory(messages []Message) []llm.Message {
+	var history []llm.Message = []llm.Message{{
+		Parts: []llm.Part{
+			llm.Text("Answer questions, fetch answers from the internet, and answer questions relating to the files if given. Be interactive."),
+		},
+		Role: llm.RoleUser,
+	}}
+
+	for _, message := range messages {
+		text, _ := message.Content.(string)
+		if message.ContentType == "file" {
+			history = append(history, llm.Message{Parts: []llm.Part{llm.FileData(text)}, Role: message.Role})
+		} else {
+			history = append(history, llm.Message{Parts: []llm.Part{llm.Text(text)}, Role: message.Role})
+		}
+	}
+	return history
+}
+
+// SaveMessage saves a chat message
+func (s *SessionManager) SaveMessage(sessionId string, role string, content any, contentType string) error {
+	message := Message{
+		Role:        role,
+		Content:     content,
+		CreatedAt:   time.Now(),
+		ContentType: contentType,
+	}
+	_, err := s.AppendMessage(sessionId, message)
+	return err
+}
+
+// AppendMessage saves a complete chat message, including its metadata, at
+// the end of the active path of a session and returns it with its id
+func (s *SessionManager) AppendMessage(sessionId string, message Message) (Message, error) {
+	return s.addMessage(sessionId, message, false, nil)
+}
+
+// GetMessages retrieves the messages on the active path of a session, from
+// the cache when it has them
+func (s *SessionManager) GetMessages(sessionId string) ([]Message, error) {
+	if messages, ok := s.cachedMessages(sessionId); ok {
+		return messages, nil
+	}
+
+	tree, conversation, err := s.loadTree(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	messages := []Message{}
+	if conversation != nil && conversation.ActiveMessageId != nil {
+		messages = tree.path(*conversation.ActiveMessageId)
+	}
+	s.cache(sessionId, messages)
+	return messages, nil
+}
+
+// ClearMessages deletes all chat messages of a session. It returns the
+// attached files that were deleted, for the caller to release.
+func (s *SessionManager) ClearMessages(sessionId string) ([]Attachment, error) {
+	var attachments []Attachment
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if attachments, err = sessionAttachments(tx, sessionId); err != nil {
+			return err
+		}
+		err = tx.Model(&models.Conversation{ConversationId: sessionId}).Updates(map[string]any{
+			"activeMessageId":  nil,
+			"summary":          "",
+			"summaryMessageId": nil,
+			"summaryTokens":    0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where(&models.Message{ConversationId: sessionId}).Delete(&models.Message{}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear messages: %w", err)
+	}
+	s.invalidate(sessionId)
+	return attachments, nil
+}
+
+// cachedMessages reads the messages of a session from Redis. A session
+// that is not cached, or a cache that cannot be reached, reports false.
+func (s *SessionManager) cachedMessages(sessionId string) ([]Message, bool) {
+	data, err := s.client.LRange(s.ctx, historyKey(sessionId), 0, -1).Result()
+	if err != nil {
+		log.Printf("Failed to read cached messages: %v", err)
+		return nil, false
+	}
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	messages := make([]Message, 0, len(data))
+	for _, item := range data {
+		var message Message
+		if err := json.Unmarshal([]byte(item), &message); err != nil {
+			log.Printf("Failed to unmarshal cached message: %v", err)
+			s.invalidate(sessionId)
+			return nil, false
+		}
+		messages = append(messages, message)
+	}
+	return messages, true
+}
+
+// cache stores the messages of a session in Redis
+func (s *SessionManager) cache(sessionId string, messages []Message) {
+	if len(messages) == 0 {
+		return
+	}
+	values := make([]any, 0, len(messages))
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("Failed to marshal message: %v", err)
+			return
+		}
+		values = append(values, data)
+	}
+
+	key := historyKey(sessionId)
+	_, err := s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(s.ctx, key)
+		pipe.RPush(s.ctx, key, values...)
+		pipe.Expire(s.ctx, key, historyCacheTTL())
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to cache messages: %v", err)
+	}
+}
+
+// invalidate drops the cached messages of a session after a change
+func (s *SessionManager) invalidate(sessionId string) {
+	if err := s.client.Del(s.ctx, historyKey(sessionId)).Err(); err != nil {
+		log.Printf("Failed to invalidate cached messages: %v", err)
+	}
+}
+
+// messageRecord converts a chat message to its database row
+func messageRecord(sessionId string, message Message) (models.Message, error) {
+	content, ok := message.Content.(string)
+	if !ok && message.Content != nil {
+		data, err := json.Marshal(message.Content)
+		if err != nil {
+			return models.Message{}, fmt.Errorf("failed to marshal message content: %w", err)
+		}
+		content = string(data)
+	}
+	contentType := message.ContentType
+	if contentType == "" {
+		contentType = "text"
+	}
+
+	record := models.Message{
+		ConversationId: sessionId,
+		ParentId:       message.ParentId,
+		Role:           message.Role,
+		ContentType:    contentType,
+		Content:        content,
+		Tokens:         contentTokens(content, contentType),
+		BlobKey:        message.BlobKey,
+		BlobUserId:     message.BlobUserId,
+		FinishReason:   message.FinishReason,
+		SafetyRatings:  message.SafetyRatings,
+		Continuations:  message.Continuations,
+		Repairs:        message.Repairs,
+		Alternatives:   message.Alternatives,
+		PromptVersion:  message.PromptVersion,
+		CreatedAt:      message.CreatedAt,
+	}
+	if message.Usage != nil {
+		record.PromptTokens = message.Usage.PromptTokens
+		record.ResponseTokens = message.Usage.ResponseTokens
+		record.TotalTokens = message.Usage.TotalTokens
+	}
+	return record, nil
+}
+
+// messageFromRecord converts a database row to a chat message
+func messageFromRecord(record models.Message) Message {
+	message := Message{
+		MessageId:     record.MessageId,
+		ParentId:      record.ParentId,
+		Role:          record.Role,
+		Content:       record.Content,
+		CreatedAt:     record.CreatedAt,
+		ContentType:   record.ContentType,
+		Tokens:        record.Tokens,
+		FinishReason:  record.FinishReason,
+		SafetyRatings: record.SafetyRatings,
+		Continuations: record.Continuations,
+		Repairs:       record.Repairs,
+		Alternatives:  record.Alternatives,
+		PromptVersion: record.PromptVersion,
+		BlobKey:       record.BlobKey,
+		BlobUserId:    record.BlobUserId,
+	}
+	// Messages saved before tokens were counted
+	if message.Tokens == 0 {
+		message.Tokens = contentTokens(record.Content, record.ContentType)
+	}
+	if record.TotalTokens > 0 || record.PromptTokens > 0 || record.ResponseTokens > 0 {
+		message.Usage = &llm.Usage{
+			PromptTokens:   record.PromptTokens,
+			ResponseTokens: record.ResponseTokens,
+			TotalTokens:    record.TotalTokens,
+		}
+	}
+	return message
+}
+
+// contentTokens estimates the tokens of the content of a message
+func contentTokens(content, contentType string) int {
+	if contentType == "file" {
+		return llm.FileTokens
+	}
+	return llm.EstimateTokens(content)
+}
+
+// SaveSummary stores the summary of the messages up to messageId
+func (s *SessionManager) SaveSummary(sessionId, summary string, messageId uint) error {
+	err := s.db.Model(&models.Conversation{ConversationId: sessionId}).UpdateColumns(map[string]any{
+		"summary":          summary,
+		"summaryMessageId": messageId,
+		"summaryTokens":    llm.EstimateTokens(summary),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to save summary: %w", err)
+	}
+	return nil
+}
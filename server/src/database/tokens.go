@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrTokenNotFound = errors.New("token not found or expired")
+
+// TokenStore keeps short-lived opaque tokens and session revocation markers in Redis
+type TokenStore struct {
+	client *redis.Client
+}
+
+// NewTokenStore initializes a token store on the given Redis client
+func NewTokenStore(client *redis.Client) *TokenStore {
+	return &TokenStore{client: client}
+}
+
+// saveToken stores a hashed single-use token of the given kind for userId until ttl elapses
+func (t *TokenStore) saveToken(ctx context.Context, kind, tokenHash, userId string, ttl time.Duration) error {
+	key := fmt.Sprintf("%s:%s", kind, tokenHash)
+	if err := t.client.Set(ctx, key, userId, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save %s token: %w", kind, err)
+	}
+	return nil
+}
+
+// consumeToken returns the user of a token and deletes it, so that each
+// token can only be used once.
+func (t *TokenStore) consumeToken(ctx context.Context, kind, tokenHash string) (string, error) {
+	key := fmt.Sprintf("%s:%s", kind, tokenHash)
+	userId, err := t.client.GetDel(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrTokenNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s token: %w", kind, err)
+	}
+	return userId, nil
+}
+
+// SavePasswordResetToken stores a hashed reset token for userId until ttl elapses
+func (t *TokenStore) SavePasswordResetToken(ctx context.Context, tokenHash, userId string, ttl time.Duration) error {
+	return t.saveToken(ctx, "password-reset", tokenHash, userId, ttl)
+}
+
+// ConsumePasswordResetToken returns the user of a reset token and deletes it
+func (t *TokenStore) ConsumePasswordResetToken(ctx context.Context, tokenHash string) (string, error) {
+	return t.consumeToken(ctx, "password-reset", tokenHash)
+}
+
+// SaveEmailVerificationToken stores a hashed verification token for userId until ttl elapses
+func (t *TokenStore) SaveEmailVerificationToken(ctx context.Context, tokenHash, userId string, ttl time.Duration) error {
+	return t.saveToken(ctx, "email-verification", tokenHash, userId, ttl)
+}
+
+// ConsumeEmailVerificationToken returns the user of a verification token and deletes it
+func (t *TokenStore) ConsumeEmailVerificationToken(ctx context.Context, tokenHash string) (string, error) {
+	return t.consumeToken(ctx, "email-verification", tokenHash)
+}
+
+// RefreshSession is the device session a refresh token belongs to
+type RefreshSession struct {
+	UserId   string `json:"userId"`
+	DeviceId string `json:"deviceId"`
+}
+
+// SaveRefreshToken stores the refresh token of a device, replacing the
+// previous token of that device.
+func (t *TokenStore) SaveRefreshToken(ctx context.Context, tokenHash string, session RefreshSession, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal refresh session: %w", err)
+	}
+
+	devicesKey := fmt.Sprintf("refresh-devices:%s", session.UserId)
+	previousHash, err := t.client.HGet(ctx, devicesKey, session.DeviceId).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to read refresh token: %w", err)
+	}
+
+	pipe := t.client.TxPipeline()
+	if previousHash != "" {
+		pipe.Del(ctx, fmt.Sprintf("refresh-token:%s", previousHash))
+	}
+	pipe.Set(ctx, fmt.Sprintf("refresh-token:%s", tokenHash), data, ttl)
+	pipe.HSet(ctx, devicesKey, session.DeviceId, tokenHash)
+	pipe.Expire(ctx, devicesKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save refresh token: %w", err)
+	}
+	return nil
+}
+
+// ConsumeRefreshToken returns the session of a refresh token and deletes it.
+// Tokens that are no longer the current token of their device are rejected.
+func (t *TokenStore) ConsumeRefreshToken(ctx context.Context, tokenHash string) (*RefreshSession, error) {
+	data, err := t.client.GetDel(ctx, fmt.Sprintf("refresh-token:%s", tokenHash)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refresh token: %w", err)
+	}
+
+	var session RefreshSession
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal refresh session: %w", err)
+	}
+
+	currentHash, err := t.client.HGet(ctx, fmt.Sprintf("refresh-devices:%s", session.UserId), session.DeviceId).Result()
+	if errors.Is(err, redis.Nil) || currentHash != tokenHash {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refresh token: %w", err)
+	}
+	return &session, nil
+}
+
+// RevokeDevice deletes the refresh token of a single device
+func (t *TokenStore) RevokeDevice(ctx context.Context, userId, deviceId string) error {
+	devicesKey := fmt.Sprintf("refresh-devices:%s", userId)
+	tokenHash, err := t.client.HGet(ctx, devicesKey, deviceId).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read refresh token: %w", err)
+	}
+
+	pipe := t.client.TxPipeline()
+	pipe.Del(ctx, fmt.Sprintf("refresh-token:%s", tokenHash))
+	pipe.HDel(ctx, devicesKey, deviceId)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to revoke device: %w", err)
+	}
+	return nil
+}
+
+// RevokeAccessToken denylists a single access token until it expires
+func (t *TokenStore) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if jti == "" || ttl <= 0 {
+		return nil
+	}
+	if err := t.client.Set(ctx, fmt.Sprintf("revoked-token:%s", jti), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether an access token was denylisted
+func (t *TokenStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	count, err := t.client.Exists(ctx, fmt.Sprintf("revoked-token:%s", jti)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to read revoked token: %w", err)
+	}
+	return count > 0, nil
+}
+
+// RevokeUserSessions invalidates every access token issued to userId before
+// now and deletes the refresh tokens of all their devices.
+func (t *TokenStore) RevokeUserSessions(ctx context.Context, userId string) error {
+	devicesKey := fmt.Sprintf("refresh-devices:%s", userId)
+	tokenHashes, err := t.client.HVals(ctx, devicesKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read refresh tokens: %w", err)
+	}
+
+	pipe := t.client.TxPipeline()
+	pipe.Set(ctx, fmt.Sprintf("sessions-revoked:%s", userId), time.Now().UnixMilli(), 0)
+	for _, tokenHash := range tokenHashes {
+		pipe.Del(ctx, fmt.Sprintf("refresh-token:%s", tokenHash))
+	}
+	pipe.Del(ctx, devicesKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// SessionsRevokedAt returns when the sessions of userId were last revoked,
+// or the zero time if they never were.
+func (t *TokenStore) SessionsRevokedAt(ctx context.Context, userId string) (time.Time, error) {
+	key := fmt.Sprintf("sessions-revoked:%s", userId)
+	value, err := t.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read session revocation: %w", err)
+	}
+	milliseconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid session revocation value: %w", err)
+	}
+	return time.UnixMilli(milliseconds), nil
+}
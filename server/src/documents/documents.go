@@ -0,0 +1,312 @@
+// Package documents creates, versions and deletes reference documents. A
+// new upload of a document adds a version, and conversations can stay on an
+// older one. A deleted document goes to the trash first, where it can be
+// restored; once the retention period is over it is purged with its
+// conversations, the stored originals of its versions and their uploads to
+// the LLM provider.
+package documents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/integems/report-agent/config"
+	"github.com/integems/report-agent/src/database"
+	"github.com/integems/report-agent/src/files"
+	"github.com/integems/report-agent/src/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNotFound is returned for a document that does not exist, or is not in
+// the trash when restoring
+var ErrNotFound = errors.New("document not found")
+
+// ErrVersionNotFound is returned for a version a document does not have
+var ErrVersionNotFound = errors.New("document version not found")
+
+// Manager stores documents and their versions, moves documents to the
+// trash and purges them
+type Manager struct {
+	db        *gorm.DB
+	sessions  *database.SessionManager
+	files     *files.Manager
+	retention time.Duration // How long deleted documents stay in the trash
+	interval  time.Duration // Time between two purges of the trash
+}
+
+// NewManager initializes a manager. TRASH_RETENTION sets how long deleted
+// documents can be restored, 30 days by default.
+func NewManager(db *gorm.DB, sessions *database.SessionManager, files *files.Manager) *Manager {
+	retention, err := time.ParseDuration(config.GetEnv("TRASH_RETENTION", "720h"))
+	if err != nil || retention < 0 {
+		retention = 720 * time.Hour
+	}
+	return &Manager{db: db, sessions: sessions, files: files, retention: retention, interval: time.Hour}
+}
+
+// Retention returns how long deleted documents stay in the trash
+func (m *Manager) Retention() time.Duration {
+	return m.retention
+}
+
+// Create stores the original of a new document and saves the document with
+// it as its first version
+func (m *Manager) Create(ctx context.Context, document *models.Document, r io.Reader) error {
+	key, err := m.files.Save(ctx, document.UserId, r)
+	if err != nil {
+		return err
+	}
+	document.BlobKey, document.Version = key, 1
+
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(document).Error; err != nil {
+			return fmt.Errorf("failed to save document: %w", err)
+		}
+		version := models.DocumentVersion{
+			DocumentId: document.DocumentId,
+			Version:    1,
+			BlobKey:    key,
+			Type:       document.Type,
+			UserId:     document.UserId,
+			CreatedAt:  document.CreatedAt,
+		}
+		if err := tx.Create(&version).Error; err != nil {
+			return fmt.Errorf("failed to save document version: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		m.release(ctx, document.UserId, key)
+		return err
+	}
+	return nil
+}
+
+// AddVersion stores a new original of a document and makes it the latest
+// version. userId is who uploaded it; the stored original is referenced by
+// the owner of the document, like its other versions.
+func (m *Manager) AddVersion(ctx context.Context, document *models.Document, userId, fileType, note string, r io.Reader) (*models.DocumentVersion, error) {
+	key, err := m.files.Save(ctx, document.UserId, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var version models.DocumentVersion
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		// Uploads of the same document get consecutive versions
+		var current models.Document
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.Document{DocumentId: document.DocumentId}).First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch document: %w", err)
+		}
+
+		if fileType == "" {
+			fileType = current.Type
+		}
+		version = models.DocumentVersion{
+			DocumentId: current.DocumentId,
+			Version:    current.Version + 1,
+			BlobKey:    key,
+			Type:       fileType,
+			UserId:     userId,
+			Note:       note,
+		}
+		if err := tx.Create(&version).Error; err != nil {
+			return fmt.Errorf("failed to save document version: %w", err)
+		}
+
+		err = tx.Model(&models.Document{}).Where(&models.Document{DocumentId: current.DocumentId}).
+			Updates(map[string]any{"blobKey": key, "type": fileType, "version": version.Version}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update document: %w", err)
+		}
+		*document = *current.At(version)
+		return nil
+	})
+	if err != nil {
+		m.release(ctx, document.UserId, key)
+		return nil, err
+	}
+	return &version, nil
+}
+
+// Versions lists the versions of a document, latest first
+func (m *Manager) Versions(documentId string) ([]models.DocumentVersion, error) {
+	versions := []models.DocumentVersion{}
+	err := m.db.Where(&models.DocumentVersion{DocumentId: documentId}).Order("version DESC").Find(&versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch document versions: %w", err)
+	}
+	return versions, nil
+}
+
+// Version returns a version of a document, or ErrVersionNotFound
+func (m *Manager) Version(documentId string, version int) (*models.DocumentVersion, error) {
+	var found models.DocumentVersion
+	err := m.db.Where(&models.DocumentVersion{DocumentId: documentId, Version: version}).First(&found).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch document version: %w", err)
+	}
+	return &found, nil
+}
+
+// At returns a document as it was at a version. Nil or the latest version
+// returns the document itself.
+func (m *Manager) At(document *models.Document, version *int) (*models.Document, error) {
+	if version == nil || *version == document.Version {
+		return document, nil
+	}
+	found, err := m.Version(document.DocumentId, *version)
+	if err != nil {
+		return nil, err
+	}
+	return document.At(*found), nil
+}
+
+// Trash moves a document to the trash. Without retention the document is
+// purged right away.
+func (m *Manager) Trash(ctx context.Context, document *models.Document) error {
+	if m.retention == 0 {
+		return m.Purge(ctx, document)
+	}
+	result := m.db.Where(&models.Document{DocumentId: document.DocumentId}).Delete(&models.Document{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete document: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Restore takes a document out of the trash
+func (m *Manager) Restore(document *models.Document) error {
+	result := m.db.Unscoped().Model(&models.Document{}).
+		Where(`"documentId" = ? AND "deletedAt" IS NOT NULL`, document.DocumentId).
+		UpdateColumn("deletedAt", nil)
+	if result.Error != nil {
+		return fmt.Errorf("failed to restore document: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	document.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+// Purge deletes a document, in the trash or not, with its conversations and
+// their attached files, and the stored originals of its versions and their
+// uploads. Stored files shared with other documents or users are kept.
+func (m *Manager) Purge(ctx context.Context, document *models.Document) error {
+	var conversations []models.Conversation
+	err := m.db.Where(&models.Conversation{DocumentId: &document.DocumentId}).
+		Or(&models.Conversation{ConversationId: document.DocumentId}).
+		Find(&conversations).Error
+	if err != nil {
+		return fmt.Errorf("failed to fetch conversations: %w", err)
+	}
+
+	// The session named after the document may only exist in Redis
+	sessionIds := map[string]bool{document.DocumentId: true}
+	for _, conversation := range conversations {
+		sessionIds[conversation.ConversationId] = true
+	}
+	for sessionId := range sessionIds {
+		attachments, err := m.sessions.DeleteConversation(sessionId)
+		if err != nil {
+			return err
+		}
+		for _, attachment := range attachments {
+			if err := m.files.Release(ctx, attachment.UserId, attachment.BlobKey); err != nil {
+				log.Printf("Failed to release attachment %s: %v", attachment.BlobKey, err)
+			}
+		}
+	}
+
+	versions, err := m.Versions(document.DocumentId)
+	if err != nil {
+		return err
+	}
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&models.DocumentVersion{DocumentId: document.DocumentId}).Delete(&models.DocumentVersion{}).Error; err != nil {
+			return fmt.Errorf("failed to delete document versions: %w", err)
+		}
+		result := tx.Unscoped().Where(&models.Document{DocumentId: document.DocumentId}).Delete(&models.Document{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete document: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Documents created before versions have none
+	if len(versions) == 0 {
+		versions = []models.DocumentVersion{{Version: document.Version, BlobKey: document.BlobKey, Type: document.Type}}
+	}
+	for _, version := range versions {
+		if err := m.files.Delete(ctx, document.At(version)); err != nil {
+			log.Printf("Failed to delete the files of document %s version %d: %v", document.DocumentId, version.Version, err)
+		}
+	}
+	return nil
+}
+
+// release drops the reference to an original that could not be saved
+func (m *Manager) release(ctx context.Context, userId, key string) {
+	if err := m.files.Release(ctx, userId, key); err != nil {
+		log.Printf("Failed to release blob %s: %v", key, err)
+	}
+}
+
+// PurgeExpired purges the documents that stayed in the trash longer than
+// the retention period
+func (m *Manager) PurgeExpired(ctx context.Context) {
+	var documents []models.Document
+	err := m.db.Unscoped().Where(`"deletedAt" IS NOT NULL AND "deletedAt" < ?`, time.Now().Add(-m.retention)).
+		Find(&documents).Error
+	if err != nil {
+		log.Printf("Failed to list expired documents: %v", err)
+		return
+	}
+
+	for i := range documents {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := m.Purge(ctx, &documents[i]); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Failed to purge document %s: %v", documents[i].DocumentId, err)
+		}
+	}
+}
+
+// Run empties the trash of expired documents every interval until ctx is
+// done
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.PurgeExpired(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
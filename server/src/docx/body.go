@@ -0,0 +1,289 @@
+package docx
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/integems/report-agent/src/latex"
+	"github.com/integems/report-agent/src/markdown"
+)
+
+// emuPerTwip converts twentieths of a point to English Metric Units
+const emuPerTwip = 635
+
+// emuPerPixel converts pixels at 96 dpi to English Metric Units
+const emuPerPixel = 9525
+
+// blockContext carries the list nesting and the paragraph style of quotes
+type blockContext struct {
+	depth int    // list nesting, 0 outside lists
+	style string // paragraph style id replacing Normal, for quotes
+}
+
+func (d *document) blocks(blocks []markdown.Block, context blockContext) {
+	for _, block := range blocks {
+		d.block(block, context, "")
+	}
+}
+
+// block renders one block. numbering is the numPr of the first paragraph of
+// a list item.
+func (d *document) block(block markdown.Block, context blockContext, numbering string) {
+	switch block.Kind {
+	case markdown.Paragraph:
+		style := context.style
+		if numbering != "" || context.depth > 0 {
+			style = d.style("ListParagraph")
+		}
+		properties := numbering
+		if numbering == "" && context.depth > 0 {
+			properties = fmt.Sprintf(`<w:ind w:left="%d"/>`, 720*context.depth)
+		}
+		d.paragraph(style, properties, block.Inlines)
+
+	case markdown.Heading:
+		d.paragraph(d.style(fmt.Sprintf("Heading%d", block.Level)), numbering, block.Inlines)
+
+	case markdown.List:
+		d.list(block, context)
+
+	case markdown.Table:
+		d.table(block)
+
+	case markdown.Code:
+		lines := strings.Split(block.Text, "\n")
+		for _, line := range lines {
+			d.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="` + d.style("SourceCode") + `"/></w:pPr>`)
+			if line != "" {
+				d.body.WriteString(`<w:r><w:t xml:space="preserve">` + esc(line) + `</w:t></w:r>`)
+			}
+			d.body.WriteString(`</w:p>`)
+		}
+
+	case markdown.Math:
+		d.body.WriteString(`<w:p>`)
+		if numbering != "" {
+			d.body.WriteString(`<w:pPr><w:pStyle w:val="` + d.style("ListParagraph") + `"/>` + numbering + `</w:pPr>`)
+		}
+		d.body.WriteString(`<m:oMathPara><m:oMath>` + omml(latex.Parse(block.Text)) + `</m:oMath></m:oMathPara></w:p>`)
+
+	case markdown.Quote:
+		d.blocks(block.Children, blockContext{depth: context.depth, style: d.style("Quote")})
+
+	case markdown.Rule:
+		d.body.WriteString(`<w:p><w:pPr><w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="auto"/></w:pBdr></w:pPr></w:p>`)
+	}
+}
+
+// paragraph renders a paragraph with a style and extra paragraph properties
+func (d *document) paragraph(style, properties string, inlines []markdown.Inline) {
+	d.body.WriteString(`<w:p>`)
+	if style != "" || properties != "" {
+		d.body.WriteString(`<w:pPr>`)
+		if style != "" {
+			d.body.WriteString(`<w:pStyle w:val="` + style + `"/>`)
+		}
+		d.body.WriteString(properties + `</w:pPr>`)
+	}
+	d.body.WriteString(d.runs(inlines))
+	d.body.WriteString(`</w:p>`)
+}
+
+// list renders the items of a list. The first paragraph of an item carries
+// the bullet or number, the rest of the item is indented to match.
+func (d *document) list(block markdown.Block, context blockContext) {
+	numId := d.numbering(block.Ordered, block.Start, context.depth)
+	inner := blockContext{depth: context.depth + 1, style: context.style}
+	for _, item := range block.Items {
+		numbering := fmt.Sprintf(`<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, min(context.depth, 8), numId)
+		if len(item) == 0 {
+			d.paragraph(d.style("ListParagraph"), numbering, nil)
+			continue
+		}
+		for i, child := range item {
+			if i == 0 && child.Kind != markdown.List && child.Kind != markdown.Table && child.Kind != markdown.Code && child.Kind != markdown.Quote {
+				d.block(child, inner, numbering)
+				continue
+			}
+			if i == 0 {
+				// The marker needs a paragraph of its own
+				d.paragraph(d.style("ListParagraph"), numbering, nil)
+			}
+			d.block(child, inner, "")
+		}
+	}
+}
+
+// runs renders inline content
+func (d *document) runs(inlines []markdown.Inline) string {
+	var builder strings.Builder
+	for _, inline := range inlines {
+		switch inline.Kind {
+		case markdown.Text:
+			run := d.textRun(inline)
+			if link := linkTarget(inline.URL); link != "" {
+				id := d.relate(relHyperlink, link, true)
+				run = `<w:hyperlink r:id="` + id + `" w:history="1">` + run + `</w:hyperlink>`
+			}
+			builder.WriteString(run)
+		case markdown.InlineMath:
+			builder.WriteString(`<m:oMath>` + omml(latex.Parse(inline.Text)) + `</m:oMath>`)
+		case markdown.Image:
+			builder.WriteString(d.image(inline))
+		case markdown.LineBreak:
+			builder.WriteString(`<w:r><w:br/></w:r>`)
+		}
+	}
+	return builder.String()
+}
+
+func (d *document) textRun(inline markdown.Inline) string {
+	var properties strings.Builder
+	switch {
+	case inline.Style&markdown.CodeSpan != 0:
+		properties.WriteString(`<w:rStyle w:val="` + d.style("VerbatimChar") + `"/>`)
+	case linkTarget(inline.URL) != "":
+		properties.WriteString(`<w:rStyle w:val="` + d.style("Hyperlink") + `"/>`)
+	}
+	if inline.Style&markdown.Bold != 0 {
+		properties.WriteString(`<w:b/><w:bCs/>`)
+	}
+	if inline.Style&markdown.Italic != 0 {
+		properties.WriteString(`<w:i/><w:iCs/>`)
+	}
+	if inline.Style&markdown.Strike != 0 {
+		properties.WriteString(`<w:strike/>`)
+	}
+
+	run := `<w:r>`
+	if properties.Len() > 0 {
+		run += `<w:rPr>` + properties.String() + `</w:rPr>`
+	}
+	return run + `<w:t xml:space="preserve">` + esc(inline.Text) + `</w:t></w:r>`
+}
+
+// linkTarget returns the target of links Word can open
+func linkTarget(url string) string {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "mailto:") {
+		return url
+	}
+	return ""
+}
+
+// table renders a GFM table across the text width with a repeated header row
+func (d *document) table(block markdown.Block) {
+	columns := len(block.Header)
+	if columns == 0 {
+		return
+	}
+	width := d.textWidth / columns
+
+	d.body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="` + d.style("TableGrid") + `"/><w:tblW w:w="5000" w:type="pct"/>` +
+		`<w:tblLook w:val="04A0" w:firstRow="1" w:lastRow="0" w:firstColumn="0" w:lastColumn="0" w:noHBand="0" w:noVBand="1"/></w:tblPr><w:tblGrid>`)
+	for i := 0; i < columns; i++ {
+		fmt.Fprintf(&d.body, `<w:gridCol w:w="%d"/>`, width)
+	}
+	d.body.WriteString(`</w:tblGrid>`)
+
+	d.row(block.Header, block.Align, width, true)
+	for _, row := range block.Rows {
+		d.row(row, block.Align, width, false)
+	}
+	d.body.WriteString(`</w:tbl>`)
+}
+
+func (d *document) row(cells [][]markdown.Inline, align []markdown.Align, width int, header bool) {
+	d.body.WriteString(`<w:tr>`)
+	if header {
+		d.body.WriteString(`<w:trPr><w:tblHeader/></w:trPr>`)
+	}
+	for i, cell := range cells {
+		d.body.WriteString(fmt.Sprintf(`<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/>`, width))
+		if header {
+			d.body.WriteString(`<w:shd w:val="clear" w:color="auto" w:fill="D9E2F3"/>`)
+		}
+		d.body.WriteString(`</w:tcPr><w:p><w:pPr><w:spacing w:before="40" w:after="40"/>`)
+		if i < len(align) {
+			switch align[i] {
+			case markdown.AlignCenter:
+				d.body.WriteString(`<w:jc w:val="center"/>`)
+			case markdown.AlignRight:
+				d.body.WriteString(`<w:jc w:val="right"/>`)
+			}
+		}
+		d.body.WriteString(`</w:pPr>`)
+		if header {
+			cell = emphasize(cell, markdown.Bold)
+		}
+		d.body.WriteString(d.runs(cell) + `</w:p></w:tc>`)
+	}
+	d.body.WriteString(`</w:tr>`)
+}
+
+// emphasize adds a style to text inlines
+func emphasize(inlines []markdown.Inline, style markdown.Style) []markdown.Inline {
+	styled := make([]markdown.Inline, len(inlines))
+	for i, inline := range inlines {
+		inline.Style |= style
+		styled[i] = inline
+	}
+	return styled
+}
+
+// image renders an inline picture scaled to fit the text width. Images that
+// cannot be loaded are replaced by their alt text.
+func (d *document) image(inline markdown.Inline) string {
+	fallback := d.textRun(markdown.Inline{Kind: markdown.Text, Text: "[" + strings.TrimSpace("Image "+inline.Text) + "]", Style: markdown.Italic})
+	if d.options.LoadImage == nil {
+		return fallback
+	}
+	data, err := d.options.LoadImage(d.ctx, inline.URL)
+	if err != nil {
+		log.Printf("Failed to load image %s: %v", inline.URL, err)
+		return fallback
+	}
+
+	var extension string
+	switch http.DetectContentType(data) {
+	case "image/png":
+		extension = "png"
+	case "image/jpeg":
+		extension = "jpeg"
+	case "image/gif":
+		extension = "gif"
+	default:
+		log.Printf("Unsupported image %s", inline.URL)
+		return fallback
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width == 0 || config.Height == 0 {
+		log.Printf("Failed to decode image %s: %v", inline.URL, err)
+		return fallback
+	}
+
+	cx, cy := int64(config.Width)*emuPerPixel, int64(config.Height)*emuPerPixel
+	if maxWidth := int64(d.textWidth) * emuPerTwip; cx > maxWidth {
+		cy = cy * maxWidth / cx
+		cx = maxWidth
+	}
+
+	d.drawings++
+	name := fmt.Sprintf("image%d.%s", d.drawings, extension)
+	d.media = append(d.media, part{name: "word/media/" + name, data: data})
+	id := d.relate(relImage, "media/"+name, false)
+
+	return fmt.Sprintf(`<w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent cx="%d" cy="%d"/>`+
+		`<wp:docPr id="%d" name="Picture %d" descr="%s"/><wp:cNvGraphicFramePr><a:graphicFrameLocks noChangeAspect="1"/></wp:cNvGraphicFramePr>`+
+		`<a:graphic><a:graphicData uri="%s"><pic:pic><pic:nvPicPr><pic:cNvPr id="%d" name="%s"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="%s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r>`,
+		cx, cy, d.drawings, d.drawings, esc(inline.Text), nsPic, d.drawings, name, id, cx, cy)
+}
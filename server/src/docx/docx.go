@@ -0,0 +1,215 @@
+// Package docx renders the markdown answers of the model into an OOXML Word
+// document: headings, emphasis, lists, GFM tables, code, quotes, images and
+// LaTeX formulas as native Word equations. Styles, theme and page setup can
+// be taken from a reference document so the output matches its format.
+package docx
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/integems/report-agent/src/markdown"
+)
+
+// ContentType is the MIME type of a .docx file
+const ContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+// OOXML namespaces
+const (
+	nsW   = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	nsR   = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsM   = "http://schemas.openxmlformats.org/officeDocument/2006/math"
+	nsWP  = "http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"
+	nsA   = "http://schemas.openxmlformats.org/drawingml/2006/main"
+	nsPic = "http://schemas.openxmlformats.org/drawingml/2006/picture"
+	nsRel = "http://schemas.openxmlformats.org/package/2006/relationships"
+
+	relStyles    = nsR + "/styles"
+	relNumbering = nsR + "/numbering"
+	relSettings  = nsR + "/settings"
+	relTheme     = nsR + "/theme"
+	relFontTable = nsR + "/fontTable"
+	relImage     = nsR + "/image"
+	relHyperlink = nsR + "/hyperlink"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+// Options configure the rendering of a document
+type Options struct {
+	Title  string
+	Author string
+	// Reference provides the styles, theme and page setup, nil for defaults
+	Reference *Reference
+	// LoadImage fetches the image of an image element. Images that cannot be
+	// loaded are replaced by their alt text.
+	LoadImage func(ctx context.Context, src string) ([]byte, error)
+}
+
+// part is a file of the package
+type part struct {
+	name string
+	data []byte
+}
+
+// relationship is an entry of word/_rels/document.xml.rels
+type relationship struct {
+	id, kind, target string
+	external         bool
+}
+
+// document collects the body, relationships and media while the blocks are
+// rendered
+type document struct {
+	ctx       context.Context
+	options   Options
+	styles    map[string]string // our style ids to the ids in styles.xml
+	body      strings.Builder
+	rels      []relationship
+	media     []part
+	lists     []list
+	drawings  int
+	textWidth int // in twentieths of a point
+}
+
+func (d *document) relate(kind, target string, external bool) string {
+	id := fmt.Sprintf("rId%d", len(d.rels)+1)
+	d.rels = append(d.rels, relationship{id: id, kind: kind, target: target, external: external})
+	return id
+}
+
+// Render writes the markdown blocks as a .docx file
+func Render(ctx context.Context, w io.Writer, blocks []markdown.Block, options Options) error {
+	d := &document{ctx: ctx, options: options}
+	section := defaultSection
+	if options.Reference != nil && options.Reference.section != "" {
+		section = options.Reference.section
+	}
+	d.textWidth = textWidth(section)
+
+	d.relate(relStyles, "styles.xml", false)
+	d.relate(relNumbering, "numbering.xml", false)
+	d.relate(relSettings, "settings.xml", false)
+	styles := d.stylesXML()
+
+	d.blocks(blocks, blockContext{})
+	if len(blocks) == 0 || blocks[len(blocks)-1].Kind == markdown.Table {
+		// Word expects a paragraph before the section properties
+		d.body.WriteString(`<w:p/>`)
+	}
+
+	parts := []part{
+		{"_rels/.rels", []byte(packageRelationships)},
+		{"docProps/core.xml", []byte(coreXML(options))},
+		{"docProps/app.xml", []byte(xmlHeader + `<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties"><Application>INTEGEMS</Application></Properties>`)},
+		{"word/document.xml", []byte(xmlHeader + `<w:document xmlns:w="` + nsW + `" xmlns:r="` + nsR + `" xmlns:m="` + nsM + `" xmlns:wp="` + nsWP + `" xmlns:a="` + nsA + `" xmlns:pic="` + nsPic + `">` +
+			`<w:body>` + d.body.String() + section + `</w:body></w:document>`)},
+		{"word/styles.xml", []byte(styles)},
+		{"word/numbering.xml", []byte(d.numberingXML())},
+		{"word/settings.xml", []byte(settingsXML)},
+	}
+	if reference := options.Reference; reference != nil {
+		if reference.theme != "" {
+			d.relate(relTheme, "theme/theme1.xml", false)
+			parts = append(parts, part{"word/theme/theme1.xml", []byte(reference.theme)})
+		}
+		if reference.fontTable != "" {
+			d.relate(relFontTable, "fontTable.xml", false)
+			parts = append(parts, part{"word/fontTable.xml", []byte(reference.fontTable)})
+		}
+	}
+	parts = append(parts, part{"word/_rels/document.xml.rels", []byte(d.relationshipsXML())})
+	parts = append(parts, d.media...)
+
+	archive := zip.NewWriter(w)
+	for _, part := range append([]part{{name: "[Content_Types].xml", data: []byte(d.contentTypes())}}, parts...) {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", part.name, err)
+		}
+		if _, err := file.Write(part.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+	}
+	return archive.Close()
+}
+
+const packageRelationships = xmlHeader + `<Relationships xmlns="` + nsRel + `">` +
+	`<Relationship Id="rId1" Type="` + nsR + `/officeDocument" Target="word/document.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>` +
+	`<Relationship Id="rId3" Type="` + nsR + `/extended-properties" Target="docProps/app.xml"/>` +
+	`</Relationships>`
+
+const settingsXML = xmlHeader + `<w:settings xmlns:w="` + nsW + `" xmlns:m="` + nsM + `">` +
+	`<w:defaultTabStop w:val="720"/><w:characterSpacingControl w:val="doNotCompress"/>` +
+	`<w:compat><w:compatSetting w:name="compatibilityMode" w:uri="http://schemas.microsoft.com/office/word" w:val="15"/></w:compat>` +
+	`<m:mathPr><m:mathFont m:val="Cambria Math"/><m:dispDef/></m:mathPr>` +
+	`</w:settings>`
+
+func coreXML(options Options) string {
+	title := options.Title
+	if title == "" {
+		title = "Document"
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	return xmlHeader + `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<dc:title>` + esc(title) + `</dc:title><dc:creator>` + esc(options.Author) + `</dc:creator>` +
+		`<dcterms:created xsi:type="dcterms:W3CDTF">` + now + `</dcterms:created><dcterms:modified xsi:type="dcterms:W3CDTF">` + now + `</dcterms:modified></cp:coreProperties>`
+}
+
+func (d *document) relationshipsXML() string {
+	var builder strings.Builder
+	builder.WriteString(xmlHeader + `<Relationships xmlns="` + nsRel + `">`)
+	for _, rel := range d.rels {
+		mode := ""
+		if rel.external {
+			mode = ` TargetMode="External"`
+		}
+		fmt.Fprintf(&builder, `<Relationship Id="%s" Type="%s" Target="%s"%s/>`, rel.id, rel.kind, esc(rel.target), mode)
+	}
+	builder.WriteString(`</Relationships>`)
+	return builder.String()
+}
+
+func (d *document) contentTypes() string {
+	const ml = "application/vnd.openxmlformats-officedocument.wordprocessingml."
+	var builder strings.Builder
+	builder.WriteString(xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	builder.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	builder.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	for _, extension := range []string{"png", "jpeg", "gif"} {
+		fmt.Fprintf(&builder, `<Default Extension="%s" ContentType="image/%s"/>`, extension, extension)
+	}
+
+	override := func(name, contentType string) {
+		fmt.Fprintf(&builder, `<Override PartName="/%s" ContentType="%s"/>`, name, contentType)
+	}
+	override("word/document.xml", ml+"document.main+xml")
+	override("word/styles.xml", ml+"styles+xml")
+	override("word/numbering.xml", ml+"numbering+xml")
+	override("word/settings.xml", ml+"settings+xml")
+	if reference := d.options.Reference; reference != nil {
+		if reference.theme != "" {
+			override("word/theme/theme1.xml", "application/vnd.openxmlformats-officedocument.theme+xml")
+		}
+		if reference.fontTable != "" {
+			override("word/fontTable.xml", ml+"fontTable+xml")
+		}
+	}
+	override("docProps/core.xml", "application/vnd.openxmlformats-package.core-properties+xml")
+	override("docProps/app.xml", "application/vnd.openxmlformats-officedocument.extended-properties+xml")
+	builder.WriteString(`</Types>`)
+	return builder.String()
+}
+
+// esc escapes text for use in XML content and attributes
+func esc(text string) string {
+	var builder strings.Builder
+	xml.EscapeText(&builder, []byte(text))
+	return builder.String()
+}
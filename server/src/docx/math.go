@@ -0,0 +1,165 @@
+package docx
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/integems/report-agent/src/latex"
+)
+
+// omml renders formula nodes as Office Math
+func omml(nodes []latex.Node) string {
+	var builder strings.Builder
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[i]
+		if name, ok := functionName(node); ok {
+			// A function takes the element that follows as its argument
+			var argument []latex.Node
+			if i+1 < len(nodes) {
+				argument = nodes[i+1 : i+2]
+				i++
+			}
+			builder.WriteString(`<m:func><m:fName>` + name + `</m:fName><m:e>` + omml(argument) + `</m:e></m:func>`)
+			continue
+		}
+		builder.WriteString(ommlNode(node))
+	}
+	return builder.String()
+}
+
+func ommlNode(node latex.Node) string {
+	switch node.Kind {
+	case latex.Run:
+		return mathRun(node.Text, false, node.Bold)
+	case latex.Plain:
+		return mathRun(node.Text, true, node.Bold)
+	case latex.Group:
+		return omml(node.Body)
+	case latex.Fraction:
+		properties := ""
+		if node.NoBar {
+			properties = `<m:fPr><m:type m:val="noBar"/></m:fPr>`
+		}
+		return `<m:f>` + properties + `<m:num>` + omml(node.Upper) + `</m:num><m:den>` + omml(node.Lower) + `</m:den></m:f>`
+	case latex.Root:
+		if len(node.Upper) == 0 {
+			return `<m:rad><m:radPr><m:degHide m:val="1"/></m:radPr><m:deg/><m:e>` + omml(node.Body) + `</m:e></m:rad>`
+		}
+		return `<m:rad><m:deg>` + omml(node.Upper) + `</m:deg><m:e>` + omml(node.Body) + `</m:e></m:rad>`
+	case latex.Scripts:
+		return scripts(node)
+	case latex.Delimited:
+		return `<m:d><m:dPr><m:begChr m:val="` + esc(node.Open) + `"/><m:endChr m:val="` + esc(node.Close) + `"/></m:dPr><m:e>` + omml(node.Body) + `</m:e></m:d>`
+	case latex.Accent:
+		if node.Text == "̅" {
+			return `<m:bar><m:barPr><m:pos m:val="top"/></m:barPr><m:e>` + omml(node.Body) + `</m:e></m:bar>`
+		}
+		if node.Text == "̲" {
+			return `<m:bar><m:barPr><m:pos m:val="bot"/></m:barPr><m:e>` + omml(node.Body) + `</m:e></m:bar>`
+		}
+		return `<m:acc><m:accPr><m:chr m:val="` + node.Text + `"/></m:accPr><m:e>` + omml(node.Body) + `</m:e></m:acc>`
+	case latex.Function:
+		return mathRun(node.Text, true, false)
+	case latex.Operator:
+		var properties strings.Builder
+		properties.WriteString(`<m:naryPr><m:chr m:val="` + node.Text + `"/>`)
+		if strings.ContainsAny(node.Text, "∫∬∭∮") {
+			properties.WriteString(`<m:limLoc m:val="subSup"/>`)
+		} else {
+			properties.WriteString(`<m:limLoc m:val="undOvr"/>`)
+		}
+		if len(node.Lower) == 0 {
+			properties.WriteString(`<m:subHide m:val="1"/>`)
+		}
+		if len(node.Upper) == 0 {
+			properties.WriteString(`<m:supHide m:val="1"/>`)
+		}
+		properties.WriteString(`</m:naryPr>`)
+		return `<m:nary>` + properties.String() + `<m:sub>` + omml(node.Lower) + `</m:sub><m:sup>` + omml(node.Upper) + `</m:sup><m:e>` + omml(node.Body) + `</m:e></m:nary>`
+	case latex.Matrix:
+		return matrix(node)
+	}
+	return ""
+}
+
+// functionName renders the name of a function, with the limits of lim, max
+// and the like set below it
+func functionName(node latex.Node) (string, bool) {
+	switch {
+	case node.Kind == latex.Function:
+		return mathRun(node.Text, true, false), true
+	case node.Kind == latex.Scripts && len(node.Body) == 1 && node.Body[0].Kind == latex.Function:
+		name := mathRun(node.Body[0].Text, true, false)
+		if len(node.Upper) == 0 && limitFunctions[node.Body[0].Text] {
+			return `<m:limLow><m:e>` + name + `</m:e><m:lim>` + omml(node.Lower) + `</m:lim></m:limLow>`, true
+		}
+		return scripts(node), true
+	}
+	return "", false
+}
+
+// limitFunctions take their subscript as a limit below the name
+var limitFunctions = map[string]bool{
+	"lim": true, "limsup": true, "liminf": true, "max": true, "min": true, "sup": true, "inf": true, "det": true, "gcd": true, "Pr": true, "arg": true,
+}
+
+// scripts renders subscripts and superscripts
+func scripts(node latex.Node) string {
+	base := omml(node.Body)
+	if len(node.Body) == 1 && node.Body[0].Kind == latex.Function {
+		base = mathRun(node.Body[0].Text, true, false)
+	}
+	switch {
+	case len(node.Lower) > 0 && len(node.Upper) > 0:
+		return `<m:sSubSup><m:e>` + base + `</m:e><m:sub>` + omml(node.Lower) + `</m:sub><m:sup>` + omml(node.Upper) + `</m:sup></m:sSubSup>`
+	case len(node.Lower) > 0:
+		return `<m:sSub><m:e>` + base + `</m:e><m:sub>` + omml(node.Lower) + `</m:sub></m:sSub>`
+	default:
+		return `<m:sSup><m:e>` + base + `</m:e><m:sup>` + omml(node.Upper) + `</m:sup></m:sSup>`
+	}
+}
+
+// matrix renders an environment as a matrix between its delimiters
+func matrix(node latex.Node) string {
+	columns := 1
+	for _, row := range node.Rows {
+		columns = max(columns, len(row))
+	}
+
+	var builder strings.Builder
+	builder.WriteString(`<m:m><m:mPr><m:mcs><m:mc><m:mcPr><m:count m:val="` + strconv.Itoa(columns) + `"/><m:mcJc m:val="left"/></m:mcPr></m:mc></m:mcs></m:mPr>`)
+	for _, row := range node.Rows {
+		builder.WriteString(`<m:mr>`)
+		for column := 0; column < columns; column++ {
+			var cell []latex.Node
+			if column < len(row) {
+				cell = row[column]
+			}
+			builder.WriteString(`<m:e>` + omml(cell) + `</m:e>`)
+		}
+		builder.WriteString(`</m:mr>`)
+	}
+	builder.WriteString(`</m:m>`)
+
+	if node.Open == "" && node.Close == "" {
+		return builder.String()
+	}
+	return `<m:d><m:dPr><m:begChr m:val="` + esc(node.Open) + `"/><m:endChr m:val="` + esc(node.Close) + `"/></m:dPr><m:e>` + builder.String() + `</m:e></m:d>`
+}
+
+// mathRun renders characters, upright for text and function names
+func mathRun(text string, upright, bold bool) string {
+	if text == "" {
+		return ""
+	}
+	properties := ""
+	switch {
+	case upright && bold:
+		properties = `<m:rPr><m:sty m:val="b"/></m:rPr>`
+	case upright:
+		properties = `<m:rPr><m:sty m:val="p"/></m:rPr>`
+	case bold:
+		properties = `<m:rPr><m:sty m:val="bi"/></m:rPr>`
+	}
+	return `<m:r>` + properties + `<m:t xml:space="preserve">` + esc(text) + `</m:t></m:r>`
+}
@@ -0,0 +1,69 @@
+package docx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Abstract numbering definitions shared by every list
+const (
+	bulletAbstract  = 0
+	decimalAbstract = 1
+)
+
+// list is a numbering instance. Every numbered list gets its own so its
+// numbers restart, all bullet lists share one.
+type list struct {
+	ordered bool
+	start   int
+	level   int
+}
+
+// numbering returns the numId of a new list
+func (d *document) numbering(ordered bool, start, level int) int {
+	if !ordered {
+		for i, list := range d.lists {
+			if !list.ordered {
+				return i + 1
+			}
+		}
+	}
+	if start < 1 {
+		start = 1
+	}
+	d.lists = append(d.lists, list{ordered: ordered, start: start, level: min(level, 8)})
+	return len(d.lists)
+}
+
+func (d *document) numberingXML() string {
+	var builder strings.Builder
+	builder.WriteString(xmlHeader + `<w:numbering xmlns:w="` + nsW + `">`)
+
+	bullets := []string{"•", "◦", "▪"}
+	fmt.Fprintf(&builder, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, bulletAbstract)
+	for level := 0; level < 9; level++ {
+		fmt.Fprintf(&builder, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="bullet"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/>`+
+			`<w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, level, bullets[level%3], 720*(level+1))
+	}
+	builder.WriteString(`</w:abstractNum>`)
+
+	formats := []string{"decimal", "lowerLetter", "lowerRoman"}
+	fmt.Fprintf(&builder, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, decimalAbstract)
+	for level := 0; level < 9; level++ {
+		fmt.Fprintf(&builder, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%%%d."/><w:lvlJc w:val="left"/>`+
+			`<w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, level, formats[level%3], level+1, 720*(level+1))
+	}
+	builder.WriteString(`</w:abstractNum>`)
+
+	for i, list := range d.lists {
+		if !list.ordered {
+			fmt.Fprintf(&builder, `<w:num w:numId="%d"><w:abstractNumId w:val="%d"/></w:num>`, i+1, bulletAbstract)
+			continue
+		}
+		fmt.Fprintf(&builder, `<w:num w:numId="%d"><w:abstractNumId w:val="%d"/>`+
+			`<w:lvlOverride w:ilvl="%d"><w:startOverride w:val="%d"/></w:lvlOverride></w:num>`,
+			i+1, decimalAbstract, list.level, list.start)
+	}
+	builder.WriteString(`</w:numbering>`)
+	return builder.String()
+}
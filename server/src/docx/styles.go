@@ -0,0 +1,217 @@
+package docx
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultSection is an A4 page with one inch margins
+const defaultSection = `<w:sectPr><w:pgSz w:w="11906" w:h="16838"/>` +
+	`<w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/>` +
+	`<w:cols w:space="708"/></w:sectPr>`
+
+// style is a style the renderer uses. Its definition is added to styles.xml
+// unless the reference document already has a style of the same name.
+type style struct {
+	id, name, definition string
+}
+
+var defaultStyles = []style{
+	{"Normal", "Normal", `<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:qFormat/></w:style>`},
+	{"Title", "Title", `<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>` +
+		`<w:pPr><w:spacing w:after="240"/></w:pPr><w:rPr><w:color w:val="1F3864"/><w:sz w:val="52"/><w:szCs w:val="52"/></w:rPr></w:style>`},
+	{"Heading1", "heading 1", headingStyle(1, 32, true)},
+	{"Heading2", "heading 2", headingStyle(2, 28, true)},
+	{"Heading3", "heading 3", headingStyle(3, 24, true)},
+	{"Heading4", "heading 4", headingStyle(4, 22, true)},
+	{"Heading5", "heading 5", headingStyle(5, 22, false)},
+	{"Heading6", "heading 6", headingStyle(6, 22, false)},
+	{"ListParagraph", "List Paragraph", `<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:qFormat/>` +
+		`<w:pPr><w:spacing w:after="60"/><w:ind w:left="720"/><w:contextualSpacing/></w:pPr></w:style>`},
+	{"Quote", "Quote", `<w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>` +
+		`<w:pPr><w:ind w:left="720" w:right="720"/></w:pPr><w:rPr><w:i/><w:iCs/><w:color w:val="404040"/></w:rPr></w:style>`},
+	{"SourceCode", "Source Code", `<w:style w:type="paragraph" w:customStyle="1" w:styleId="SourceCode"><w:name w:val="Source Code"/><w:basedOn w:val="Normal"/>` +
+		`<w:pPr><w:shd w:val="clear" w:color="auto" w:fill="F2F2F2"/><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr>` +
+		`<w:rPr><w:rFonts w:ascii="Consolas" w:hAnsi="Consolas" w:cs="Consolas"/><w:sz w:val="20"/><w:szCs w:val="20"/></w:rPr></w:style>`},
+	{"VerbatimChar", "Verbatim Char", `<w:style w:type="character" w:customStyle="1" w:styleId="VerbatimChar"><w:name w:val="Verbatim Char"/>` +
+		`<w:rPr><w:rFonts w:ascii="Consolas" w:hAnsi="Consolas" w:cs="Consolas"/><w:sz w:val="20"/><w:shd w:val="clear" w:color="auto" w:fill="F2F2F2"/></w:rPr></w:style>`},
+	{"Hyperlink", "Hyperlink", `<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0563C1"/><w:u w:val="single"/></w:rPr></w:style>`},
+	{"TableGrid", "Table Grid", `<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr>` +
+		`<w:tblPr><w:tblBorders><w:top w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:left w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+		`<w:bottom w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:right w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+		`<w:insideH w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:insideV w:val="single" w:sz="4" w:space="0" w:color="auto"/></w:tblBorders>` +
+		`<w:tblCellMar><w:left w:w="108" w:type="dxa"/><w:right w:w="108" w:type="dxa"/></w:tblCellMar></w:tblPr></w:style>`},
+}
+
+func headingStyle(level, size int, bold bool) string {
+	boldXML := ""
+	if bold {
+		boldXML = `<w:b/><w:bCs/>`
+	}
+	return fmt.Sprintf(`<w:style w:type="paragraph" w:styleId="Heading%d"><w:name w:val="heading %d"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>`+
+		`<w:pPr><w:keepNext/><w:keepLines/><w:spacing w:before="%d" w:after="80"/><w:outlineLvl w:val="%d"/></w:pPr>`+
+		`<w:rPr>%s<w:color w:val="2F5496"/><w:sz w:val="%d"/><w:szCs w:val="%d"/></w:rPr></w:style>`,
+		level, level, 360-40*min(level, 4), level-1, boldXML, size, size)
+}
+
+const defaultStylesHeader = `<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:eastAsia="Calibri" w:hAnsi="Calibri" w:cs="Calibri"/>` +
+	`<w:sz w:val="22"/><w:szCs w:val="22"/><w:lang w:val="en-US"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="160" w:line="259" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>`
+
+// stylesXML returns styles.xml, the reference styles completed with the
+// default styles they lack, and records the style ids to use
+func (d *document) stylesXML() string {
+	d.styles = map[string]string{}
+	existing := map[string]string{}
+	base := xmlHeader + `<w:styles xmlns:w="` + nsW + `">` + defaultStylesHeader + `</w:styles>`
+	if d.options.Reference != nil && d.options.Reference.styles != "" {
+		base = d.options.Reference.styles
+		existing = styleNames(base)
+	}
+
+	var missing []style
+	for _, style := range defaultStyles {
+		if id, ok := existing[strings.ToLower(style.name)]; ok {
+			d.styles[style.id] = id
+			continue
+		}
+		d.styles[style.id] = style.id
+		missing = append(missing, style)
+	}
+
+	var added strings.Builder
+	for _, style := range missing {
+		definition := style.definition
+		if normal := d.styles["Normal"]; normal != "Normal" {
+			definition = strings.ReplaceAll(definition, `w:val="Normal"/>`, `w:val="`+normal+`"/>`)
+		}
+		added.WriteString(definition)
+	}
+
+	end := strings.LastIndex(base, "</w:styles>")
+	if end < 0 {
+		return base
+	}
+	return base[:end] + added.String() + base[end:]
+}
+
+var (
+	stylePattern     = regexp.MustCompile(`(?s)<w:style\b[^>]*>.*?</w:style>`)
+	styleIdPattern   = regexp.MustCompile(`w:styleId="([^"]*)"`)
+	styleNamePattern = regexp.MustCompile(`<w:name w:val="([^"]*)"`)
+)
+
+// styleNames maps the lower case names of the styles defined in styles.xml
+// to their ids
+func styleNames(styles string) map[string]string {
+	names := map[string]string{}
+	for _, definition := range stylePattern.FindAllString(styles, -1) {
+		id := styleIdPattern.FindStringSubmatch(definition)
+		name := styleNamePattern.FindStringSubmatch(definition)
+		if id != nil && name != nil {
+			names[strings.ToLower(name[1])] = id[1]
+		}
+	}
+	return names
+}
+
+// style returns the id to use for one of the default styles
+func (d *document) style(id string) string {
+	if mapped, ok := d.styles[id]; ok {
+		return mapped
+	}
+	return id
+}
+
+// Reference holds the parts of a reference document that define its look
+type Reference struct {
+	styles    string
+	theme     string
+	fontTable string
+	section   string
+}
+
+var (
+	sectionPattern         = regexp.MustCompile(`(?s)<w:sectPr\b[^>]*>.*?</w:sectPr>`)
+	headerFooterPattern    = regexp.MustCompile(`<w:(?:headerReference|footerReference)\b[^>]*/>`)
+	sectionChangesPattern  = regexp.MustCompile(`(?s)<w:sectPrChange\b.*?</w:sectPrChange>`)
+	pageWidthPattern       = regexp.MustCompile(`<w:pgSz\b[^>]*w:w="(\d+)"`)
+	marginPattern          = regexp.MustCompile(`<w:pgMar\b[^>]*>`)
+	marginAttributePattern = regexp.MustCompile(`w:(left|right)="(\d+)"`)
+)
+
+// maxReferencePart limits the size of a part read from a reference document
+const maxReferencePart = 16 * 1024 * 1024
+
+// LoadReference reads the styles, theme, font table and page setup of a
+// .docx file. Headers and footers are not carried over since they refer to
+// parts of the reference document.
+func LoadReference(data []byte) (*Reference, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("reference is not a .docx file: %w", err)
+	}
+
+	read := func(name string) (string, error) {
+		for _, file := range archive.File {
+			if file.Name != name {
+				continue
+			}
+			reader, err := file.Open()
+			if err != nil {
+				return "", err
+			}
+			defer reader.Close()
+			content, err := io.ReadAll(io.LimitReader(reader, maxReferencePart))
+			return string(content), err
+		}
+		return "", nil
+	}
+
+	reference := &Reference{}
+	if reference.styles, err = read("word/styles.xml"); err != nil {
+		return nil, fmt.Errorf("failed to read reference styles: %w", err)
+	}
+	if reference.theme, err = read("word/theme/theme1.xml"); err != nil {
+		return nil, fmt.Errorf("failed to read reference theme: %w", err)
+	}
+	if reference.fontTable, err = read("word/fontTable.xml"); err != nil {
+		return nil, fmt.Errorf("failed to read reference fonts: %w", err)
+	}
+	body, err := read("word/document.xml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reference document: %w", err)
+	}
+	if sections := sectionPattern.FindAllString(body, -1); len(sections) > 0 {
+		section := headerFooterPattern.ReplaceAllString(sections[len(sections)-1], "")
+		reference.section = sectionChangesPattern.ReplaceAllString(section, "")
+	}
+	if reference.styles == "" && reference.section == "" {
+		return nil, fmt.Errorf("reference has no styles")
+	}
+	return reference, nil
+}
+
+// textWidth is the width between the margins of a section, in twentieths of
+// a point
+func textWidth(section string) int {
+	width := 11906
+	if match := pageWidthPattern.FindStringSubmatch(section); match != nil {
+		width, _ = strconv.Atoi(match[1])
+	}
+	if margins := marginPattern.FindString(section); margins != "" {
+		for _, match := range marginAttributePattern.FindAllStringSubmatch(margins, -1) {
+			margin, _ := strconv.Atoi(match[2])
+			width -= margin
+		}
+	}
+	if width < 1440 {
+		width = 9026
+	}
+	return width
+}
@@ -0,0 +1,253 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/models"
+	"github.com/integems/report-agent/src/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// lockBlob holds the advisory lock of a blob until tx ends. Counting a
+// reference and deleting the stored content take it, so they exclude each
+// other across replicas.
+func lockBlob(tx *gorm.DB, key string) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", key).Error; err != nil {
+		return fmt.Errorf("failed to lock blob: %w", err)
+	}
+	return nil
+}
+
+// put stores content for a user and counts the reference. The content is
+// written first, outside of any transaction: keys are content-addressed, so
+// writing content that is already stored is harmless.
+func (m *Manager) put(ctx context.Context, userId string, r io.Reader) (string, error) {
+	object, err := m.store.Put(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	key := object.Key
+
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlob(tx, key); err != nil {
+			return err
+		}
+		// A Release may have deleted the content since it was written
+		if _, err := m.store.Stat(ctx, key); errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("blob %s was deleted while it was stored, try again", key)
+		} else if err != nil {
+			return err
+		}
+
+		blob := models.Blob{BlobKey: key, Size: object.Size}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&blob).Error; err != nil {
+			return fmt.Errorf("failed to save blob: %w", err)
+		}
+		reference := models.BlobReference{BlobKey: key, UserId: userId, Count: 1}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "blobKey"}, {Name: "userId"}},
+			DoUpdates: clause.Assignments(map[string]any{"count": gorm.Expr("blob_references.count + 1"), "updatedAt": time.Now()}),
+		}).Create(&reference).Error
+		if err != nil {
+			return fmt.Errorf("failed to count blob reference: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Release drops a reference of a user to a blob. The blob and its upload
+// are deleted once no user references it. The stored content is deleted
+// after the references are committed, unless a put counted a new one in
+// between.
+func (m *Manager) Release(ctx context.Context, userId, key string) error {
+	var freed *models.Blob
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlob(tx, key); err != nil {
+			return err
+		}
+		var blob models.Blob
+		err := tx.Where(&models.Blob{BlobKey: key}).First(&blob).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch blob: %w", err)
+		}
+
+		err = tx.Model(&models.BlobReference{}).
+			Where(`"blobKey" = ? AND "userId" = ? AND count > 0`, key, userId).
+			UpdateColumn("count", gorm.Expr("count - 1")).Error
+		if err != nil {
+			return fmt.Errorf("failed to release blob reference: %w", err)
+		}
+		if err := tx.Where(`"blobKey" = ? AND count <= 0`, key).Delete(&models.BlobReference{}).Error; err != nil {
+			return fmt.Errorf("failed to release blob reference: %w", err)
+		}
+
+		var remaining int64
+		if err := tx.Model(&models.BlobReference{}).Where(`"blobKey" = ?`, key).Count(&remaining).Error; err != nil {
+			return fmt.Errorf("failed to count blob references: %w", err)
+		}
+		if remaining > 0 {
+			return nil
+		}
+		if err := tx.Delete(&blob).Error; err != nil {
+			return fmt.Errorf("failed to delete blob: %w", err)
+		}
+		freed = &blob
+		return nil
+	})
+	if err != nil || freed == nil {
+		return err
+	}
+
+	if freed.RemoteName != "" {
+		m.deleteRemote(ctx, freed.RemoteName)
+	}
+	// Nothing is written while the content is deleted, so the transaction
+	// only holds the lock
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlob(tx, key); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.Blob{}).Where(&models.Blob{BlobKey: key}).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to fetch blob: %w", err)
+		}
+		if count > 0 {
+			return nil
+		}
+		return m.store.Delete(ctx, key)
+	})
+}
+
+// remote returns the upload of a blob, uploading it again when the upload
+// is gone or about to expire
+func (m *Manager) remote(ctx context.Context, key string) (*llm.File, error) {
+	defer m.lock(key)()
+
+	var blob models.Blob
+	err := m.db.Where(&models.Blob{BlobKey: key}).First(&blob).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Stored before blobs were tracked
+		object, err := m.store.Stat(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("%w: blob %s", ErrOriginalMissing, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		blob = models.Blob{BlobKey: key, Size: object.Size}
+		if err := m.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&blob).Error; err != nil {
+			return nil, fmt.Errorf("failed to save blob: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch blob: %w", err)
+	}
+
+	if file := m.current(ctx, &blob); file != nil {
+		return file, nil
+	}
+	return m.upload(ctx, &blob)
+}
+
+// current returns the upload of a blob while it can still be used, or nil
+// when it has to be uploaded again
+func (m *Manager) current(ctx context.Context, blob *models.Blob) *llm.File {
+	if blob.RemoteName == "" {
+		return nil
+	}
+
+	if blob.RemoteExpiresAt == nil {
+		// The upload does not expire but may have been removed
+		file, err := m.provider.GetFile(ctx, blob.RemoteName)
+		if err != nil || file.State != llm.FileStateActive {
+			return nil
+		}
+		return file
+	}
+
+	if time.Until(*blob.RemoteExpiresAt) <= m.margin {
+		return nil
+	}
+	return &llm.File{
+		Name:      blob.RemoteName,
+		URI:       blob.RemoteURI,
+		State:     llm.FileStateActive,
+		ExpiresAt: *blob.RemoteExpiresAt,
+	}
+}
+
+// upload sends a blob to the provider and records the new upload. The
+// previous one is deleted.
+func (m *Manager) upload(ctx context.Context, blob *models.Blob) (*llm.File, error) {
+	reader, err := m.store.Get(ctx, blob.BlobKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: blob %s", ErrOriginalMissing, blob.BlobKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// A new name each time, the previous upload may still exist
+	name := strings.ReplaceAll(uuid.New().String(), "-", "")
+	log.Printf("Uploading blob %s as %s", blob.BlobKey, name)
+	file, err := m.provider.UploadFile(ctx, name, reader, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+	if file, err = llm.WaitForFile(ctx, m.provider, file, 3*time.Second); err != nil {
+		return nil, fmt.Errorf("failed to process file: %w", err)
+	}
+
+	previous := blob.RemoteName
+	if err := m.track(blob, file); err != nil {
+		return nil, err
+	}
+	if previous != "" && previous != file.Name {
+		m.deleteRemote(ctx, previous)
+	}
+	return file, nil
+}
+
+// track records the upload of a blob
+func (m *Manager) track(blob *models.Blob, file *llm.File) error {
+	blob.RemoteName, blob.RemoteURI, blob.RemoteExpiresAt = file.Name, file.URI, nil
+	if !file.ExpiresAt.IsZero() {
+		expiresAt := file.ExpiresAt
+		blob.RemoteExpiresAt = &expiresAt
+	}
+
+	err := m.db.Model(&models.Blob{}).Where(&models.Blob{BlobKey: blob.BlobKey}).
+		UpdateColumns(map[string]any{
+			"remoteName":      blob.RemoteName,
+			"remoteUri":       blob.RemoteURI,
+			"remoteExpiresAt": blob.RemoteExpiresAt,
+			"updatedAt":       time.Now(),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to save upload of blob %s: %w", blob.BlobKey, err)
+	}
+	return nil
+}
+
+// deleteRemote removes an upload that is not used anymore
+func (m *Manager) deleteRemote(ctx context.Context, name string) {
+	if err := m.provider.DeleteFile(ctx, name); err != nil && !errors.Is(err, llm.ErrFileNotFound) {
+		log.Printf("Failed to delete upload %s: %v", name, err)
+	}
+}
@@ -0,0 +1,303 @@
+// Package files keeps reference documents available to the model. The
+// original of a document is kept in the blob store and a copy is uploaded
+// to the LLM provider. Providers drop uploads after a while (48 hours for
+// Gemini), so the copy is uploaded again shortly before it expires, or when
+// it is needed and already gone.
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/integems/report-agent/config"
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/models"
+	"github.com/integems/report-agent/src/storage"
+	"gorm.io/gorm"
+)
+
+// ErrOriginalMissing is returned when a document has to be uploaded again
+// but its original is not stored anymore
+var ErrOriginalMissing = errors.New("the original of the document is missing")
+
+// Manager stores the originals of documents and their uploads
+type Manager struct {
+	db       *gorm.DB
+	provider llm.Provider
+	store    storage.Store
+	dir      string        // Directory of the originals stored before the blob store
+	margin   time.Duration // Uploads expiring within margin are refreshed
+	interval time.Duration // Time between two refreshes
+
+	mu    sync.Mutex
+	locks map[string]*keyLock // By blob or document, so each is uploaded once at a time
+}
+
+// keyLock is the lock of a blob or document, counting the callers holding
+// or waiting for it
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewManager initializes a manager. DOCUMENTS_DIR is where the originals
+// were stored before the blob store, FILE_REFRESH_MARGIN how long before
+// their expiry uploads are refreshed and FILE_REFRESH_INTERVAL how often
+// they are checked.
+func NewManager(db *gorm.DB, provider llm.Provider, store storage.Store) *Manager {
+	return &Manager{
+		db:       db,
+		provider: provider,
+		store:    store,
+		dir:      config.GetEnv("DOCUMENTS_DIR", "documents"),
+		margin:   duration("FILE_REFRESH_MARGIN", 6*time.Hour),
+		interval: duration("FILE_REFRESH_INTERVAL", 30*time.Minute),
+		locks:    map[string]*keyLock{},
+	}
+}
+
+// duration reads a positive duration such as "6h" from the environment
+func duration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(config.GetEnv(name, fallback.String()))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// legacyName is the name documents were uploaded under before their upload
+// was tracked, and the base name of their original in the documents
+// directory
+func legacyName(documentId string) string {
+	return strings.ReplaceAll(strings.ToLower(documentId), "-", "a")
+}
+
+// legacyPath returns where the original of a document was stored before the
+// blob store
+func (m *Manager) legacyPath(document *models.Document) string {
+	return filepath.Join(m.dir, fmt.Sprintf("%v.%v", legacyName(document.DocumentId), document.Type))
+}
+
+// Open returns a reader on the original of a document, or
+// ErrOriginalMissing
+func (m *Manager) Open(ctx context.Context, document *models.Document) (io.ReadCloser, error) {
+	if document.BlobKey != "" {
+		reader, err := m.store.Get(ctx, document.BlobKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrOriginalMissing, document.DocumentId)
+		}
+		return reader, err
+	}
+
+	file, err := os.Open(m.legacyPath(document))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrOriginalMissing, document.DocumentId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open document: %w", err)
+	}
+	return file, nil
+}
+
+// Save stores the original of a document for a user and uploads it. It
+// returns the key of the blob, referenced once by the user.
+func (m *Manager) Save(ctx context.Context, userId string, r io.Reader) (string, error) {
+	key, err := m.put(ctx, userId, r)
+	if err != nil {
+		return "", err
+	}
+	if _, err := m.remote(ctx, key); err != nil {
+		if err := m.Release(ctx, userId, key); err != nil {
+			log.Printf("Failed to release blob %s: %v", key, err)
+		}
+		return "", err
+	}
+	return key, nil
+}
+
+// Attach stores a file a user attached to a chat and uploads it, unless the
+// same content was uploaded before. It returns the upload and the key of
+// the stored copy.
+func (m *Manager) Attach(ctx context.Context, userId string, r io.Reader) (*llm.File, string, error) {
+	key, err := m.put(ctx, userId, r)
+	if err != nil {
+		return nil, "", err
+	}
+	file, err := m.remote(ctx, key)
+	if err != nil {
+		if err := m.Release(ctx, userId, key); err != nil {
+			log.Printf("Failed to release blob %s: %v", key, err)
+		}
+		return nil, "", err
+	}
+	return file, key, nil
+}
+
+// Attachment returns the upload of a file attached to a chat, uploading the
+// stored copy again when the upload is gone or about to expire
+func (m *Manager) Attachment(ctx context.Context, key string) (*llm.File, error) {
+	return m.remote(ctx, key)
+}
+
+// Remote returns the upload of a document, uploading the original again
+// when the upload is gone or about to expire
+func (m *Manager) Remote(ctx context.Context, document *models.Document) (*llm.File, error) {
+	if document.BlobKey == "" {
+		err := m.importLegacy(ctx, document)
+		if errors.Is(err, ErrOriginalMissing) {
+			// Documents uploaded before uploads were tracked
+			file, fileErr := m.provider.GetFile(ctx, "files/"+legacyName(document.DocumentId))
+			if fileErr == nil && file.State == llm.FileStateActive {
+				return file, nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m.remote(ctx, document.BlobKey)
+}
+
+// importLegacy moves the original of a document stored before the blob
+// store into it. Only the first version of a document can be stored there.
+func (m *Manager) importLegacy(ctx context.Context, document *models.Document) error {
+	defer m.lock("document:" + document.DocumentId)()
+
+	// Another request may have moved it while this one waited
+	var version models.DocumentVersion
+	err := m.db.Where(&models.DocumentVersion{DocumentId: document.DocumentId, Version: document.Version}).First(&version).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to fetch document version: %w", err)
+	}
+	if version.BlobKey != "" {
+		document.BlobKey = version.BlobKey
+		return nil
+	}
+
+	path := m.legacyPath(document)
+	original, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrOriginalMissing, document.DocumentId)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open document: %w", err)
+	}
+	defer original.Close()
+
+	key, err := m.put(ctx, document.UserId, original)
+	if err != nil {
+		return err
+	}
+	// The lock only covers this process, another replica may have moved it
+	imported := true
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Document{}).
+			Where(`"documentId" = ? AND ("blobKey" IS NULL OR "blobKey" = '')`, document.DocumentId).
+			UpdateColumn("blobKey", key)
+		if result.Error != nil {
+			return result.Error
+		}
+		imported = result.RowsAffected > 0
+		return tx.Model(&models.DocumentVersion{}).
+			Where(`"documentId" = ? AND "blobKey" = ''`, document.DocumentId).
+			UpdateColumn("blobKey", key).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save document blob: %w", err)
+	}
+	document.BlobKey = key
+	if !imported {
+		return m.Release(ctx, document.UserId, key)
+	}
+
+	if err := os.Remove(path); err != nil {
+		log.Printf("Failed to remove imported document file: %v", err)
+	}
+	return nil
+}
+
+// lock locks a blob or document within this process and returns the
+// function that unlocks it. The lock is forgotten once nobody holds or waits
+// for it.
+func (m *Manager) lock(key string) (unlock func()) {
+	m.mu.Lock()
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		m.locks[key] = lock
+	}
+	lock.refs++
+	m.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(m.locks, key)
+		}
+	}
+}
+
+// Delete releases the original of a document. The blob and its upload are
+// removed once no other document or attachment uses them.
+func (m *Manager) Delete(ctx context.Context, document *models.Document) error {
+	if document.BlobKey == "" {
+		if err := os.Remove(m.legacyPath(document)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove document file: %w", err)
+		}
+		return nil
+	}
+	return m.Release(ctx, document.UserId, document.BlobKey)
+}
+
+// Refresh uploads again the originals of documents, and of the versions
+// conversations are pinned to, whose upload expires within the margin.
+// Uploads that already expired are left to Remote, so a missing original is
+// not retried forever.
+func (m *Manager) Refresh(ctx context.Context) {
+	now := time.Now()
+	var blobs []models.Blob
+	err := m.db.Where(`"remoteExpiresAt" > ? AND "remoteExpiresAt" <= ?`, now, now.Add(m.margin)).
+		Where(`"blobKey" IN (SELECT "blobKey" FROM documents WHERE "deletedAt" IS NULL
+			UNION SELECT document_versions."blobKey" FROM document_versions
+			JOIN conversations ON conversations."documentId" = document_versions."documentId"
+				AND conversations."documentVersion" = document_versions.version)`).
+		Find(&blobs).Error
+	if err != nil {
+		log.Printf("Failed to list expiring uploads: %v", err)
+		return
+	}
+
+	for _, blob := range blobs {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := m.remote(ctx, blob.BlobKey); err != nil {
+			log.Printf("Failed to refresh blob %s: %v", blob.BlobKey, err)
+		}
+	}
+}
+
+// Run refreshes the expiring uploads every interval until ctx is done
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
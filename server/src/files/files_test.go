@@ -0,0 +1,33 @@
+package files
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLockForgetsReleasedKeys(t *testing.T) {
+	m := NewManager(nil, nil, nil)
+
+	var wg sync.WaitGroup
+	counts := make([]int, 4)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := i % len(counts)
+			defer m.lock(fmt.Sprint("blob:", key))()
+			counts[key]++
+		}(i)
+	}
+	wg.Wait()
+
+	for key, count := range counts {
+		if count != 25 {
+			t.Errorf("key %d locked %d times, want 25", key, count)
+		}
+	}
+	if len(m.locks) != 0 {
+		t.Errorf("%d locks kept after unlocking", len(m.locks))
+	}
+}
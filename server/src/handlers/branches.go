@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/integems/report-agent/src/auth"
+	"github.com/integems/report-agent/src/database"
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/models"
+)
+
+// Helper function: Parse the {messageId} path value
+func messageIdParam(w http.ResponseWriter, req *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(req.PathValue("messageId"), 10, 64)
+	if err != nil || id == 0 {
+		respondWithError(w, "Invalid message ID.", http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// Helper function: Respond to an error of the message tree
+func respondWithBranchError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrMessageNotFound) {
+		respondWithError(w, "Message not found.", http.StatusNotFound)
+		return
+	}
+	respondWithError(w, "Failed to fetch messages. "+err.Error(), http.StatusInternalServerError)
+}
+
+// Helper function: The first message of the history of a conversation, with
+// its reference document when it has one, and the ID of the prompt version
+func (h *handler) systemMessage(ctx context.Context, conversation *models.Conversation) (llm.Message, string, error) {
+	if conversation.DocumentId == nil {
+		return h.chatSystemMessage()
+	}
+	parentFile, err := h.getOrUploadDocFile(ctx, *conversation.DocumentId, conversation.DocumentVersion)
+	if err != nil {
+		return llm.Message{}, "", err
+	}
+	return h.docsSystemMessage(parentFile.URI)
+}
+
+// Helper function: Build a turn that branches from the conversation after
+// the messages of path
+func (h *handler) branchTurn(w http.ResponseWriter, req *http.Request, claims *auth.Claims, conversation *models.Conversation, path []database.Message) (*chatTurn, bool) {
+	system, prompt, err := h.systemMessage(req.Context(), conversation)
+	if err != nil {
+		respondWithDocFileError(w, err)
+		return nil, false
+	}
+	history := h.chatHistory(req.Context(), path)
+	history[0] = system
+
+	return &chatTurn{sessionId: conversation.ConversationId, userId: claims.UserId, history: history, path: path, prompt: prompt, branch: true}, true
+}
+
+// prepareRegenerate builds a turn that answers the last prompt of a
+// conversation again. The new answer is saved next to the old one.
+func (h *handler) prepareRegenerate(w http.ResponseWriter, req *http.Request) (*chatTurn, bool) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return nil, false
+	}
+
+	conversation, ok := h.ownedConversation(w, claims, req.PathValue("conversationId"))
+	if !ok {
+		return nil, false
+	}
+
+	messages, err := h.sessions.GetMessages(conversation.ConversationId)
+	if err != nil {
+		respondWithBranchError(w, err)
+		return nil, false
+	}
+
+	// Drop the answer to replace, the prompt before it is answered again
+	end := len(messages)
+	if end > 0 && messages[end-1].Role != llm.RoleUser {
+		end--
+	}
+	if end == 0 || messages[end-1].Role != llm.RoleUser || messages[end-1].MessageId == 0 {
+		respondWithError(w, "There is no prompt to answer again.", http.StatusBadRequest)
+		return nil, false
+	}
+	prompt := messages[end-1]
+
+	// A prompt that is only a file is sent as history with an empty text
+	text, path := "", messages[:end]
+	if prompt.ContentType != "file" {
+		text, _ = prompt.Content.(string)
+		path = messages[:end-1]
+	}
+
+	turn, ok := h.branchTurn(w, req, claims, conversation, path)
+	if !ok {
+		return nil, false
+	}
+	turn.text = text
+	turn.parentId = &prompt.MessageId
+	turn.regenerate = true
+	return turn, true
+}
+
+// prepareEdit builds a turn that sends an edited prompt in place of
+// {messageId}. The edited prompt is saved next to the original one.
+func (h *handler) prepareEdit(w http.ResponseWriter, req *http.Request) (*chatTurn, bool) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return nil, false
+	}
+
+	conversation, ok := h.ownedConversation(w, claims, req.PathValue("conversationId"))
+	if !ok {
+		return nil, false
+	}
+
+	messageId, ok := messageIdParam(w, req)
+	if !ok {
+		return nil, false
+	}
+
+	var request struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, "Invalid request payload. "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if strings.TrimSpace(request.Text) == "" {
+		respondWithError(w, "Text is required.", http.StatusBadRequest)
+		return nil, false
+	}
+
+	original, err := h.sessions.Message(conversation.ConversationId, messageId)
+	if err != nil {
+		respondWithBranchError(w, err)
+		return nil, false
+	}
+	if original.Role != llm.RoleUser || original.ContentType != "text" {
+		respondWithError(w, "Only prompts can be edited.", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var parentId uint
+	if original.ParentId != nil {
+		parentId = *original.ParentId
+	}
+	path, err := h.sessions.PathTo(conversation.ConversationId, parentId)
+	if err != nil {
+		respondWithBranchError(w, err)
+		return nil, false
+	}
+
+	turn, ok := h.branchTurn(w, req, claims, conversation, path)
+	if !ok {
+		return nil, false
+	}
+	turn.text = request.Text
+	turn.parentId = original.ParentId
+	return turn, true
+}
+
+// Regenerate handler. Answers the last prompt of a conversation again.
+func (h *handler) regenerateMessage(w http.ResponseWriter, req *http.Request) {
+	if turn, ok := h.prepareRegenerate(w, req); ok {
+		h.completeChat(w, req, turn)
+	}
+}
+
+// Streaming regenerate handler.
+func (h *handler) regenerateMessageStream(w http.ResponseWriter, req *http.Request) {
+	if turn, ok := h.prepareRegenerate(w, req); ok {
+		h.streamChat(w, req, turn)
+	}
+}
+
+// Edit and resend handler. Sends an edited prompt and answers it.
+func (h *handler) editMessage(w http.ResponseWriter, req *http.Request) {
+	if turn, ok := h.prepareEdit(w, req); ok {
+		h.completeChat(w, req, turn)
+	}
+}
+
+// Streaming edit and resend handler.
+func (h *handler) editMessageStream(w http.ResponseWriter, req *http.Request) {
+	if turn, ok := h.prepareEdit(w, req); ok {
+		h.streamChat(w, req, turn)
+	}
+}
+
+// Get branches handler. Lists a message and its alternatives, oldest first.
+func (h *handler) getMessageBranches(w http.ResponseWriter, req *http.Request) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return
+	}
+
+	conversation, ok := h.ownedConversation(w, claims, req.PathValue("conversationId"))
+	if !ok {
+		return
+	}
+
+	messageId, ok := messageIdParam(w, req)
+	if !ok {
+		return
+	}
+
+	branches, err := h.sessions.Branches(conversation.ConversationId, messageId)
+	if err != nil {
+		respondWithBranchError(w, err)
+		return
+	}
+	respondWithJSON(w, branches, http.StatusOK)
+}
+
+// Switch branch handler. Makes the branch of a message the active path and
+// responds with its messages.
+func (h *handler) switchBranch(w http.ResponseWriter, req *http.Request) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return
+	}
+
+	conversation, ok := h.ownedConversation(w, claims, req.PathValue("conversationId"))
+	if !ok {
+		return
+	}
+
+	messageId, ok := messageIdParam(w, req)
+	if !ok {
+		return
+	}
+
+	messages, err := h.sessions.SwitchBranch(conversation.ConversationId, messageId)
+	if err != nil {
+		respondWithBranchError(w, err)
+		return
+	}
+	respondWithJSON(w, messages, http.StatusOK)
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/integems/report-agent/config"
+	"github.com/integems/report-agent/src/database"
+	"github.com/integems/report-agent/src/files"
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/models"
+	"github.com/integems/report-agent/src/payload"
+)
+
+// chatTurn is a user message ready to be sent to the model
+type chatTurn struct {
+	sessionId  string
+	userId     string
+	documentId string // Reference document of a new conversation
+	text       string
+	fileURI    string
+	fileKey    string             // Blob of the attached file
+	history    []llm.Message      // The instructions, path and files attached to the turn
+	path       []database.Message // Conversation messages the history is built from
+	prompt     string             // ID of the system prompt version
+	context    *contextUsage
+
+	// A turn that starts a branch saves its first message after parentId
+	// instead of at the end of the active path. Regenerating saves only the
+	// answer, the prompt is already stored.
+	branch     bool
+	parentId   *uint
+	regenerate bool
+}
+
+// blockedMessage is returned when the safety filters removed the whole answer
+const blockedMessage = "The response was blocked by the safety filters."
+
+// chatReply is a model answer with its &&json payload parsed into
+// {text, slides, excel, warnings}
+type chatReply struct {
+	database.Message
+	*payload.Result
+	Context *contextUsage `json:"context,omitempty"`
+}
+
+// Helper function: Number of follow-up requests allowed for a truncated answer
+func maxContinuations() int {
+	value, err := strconv.Atoi(config.GetEnv("MAX_CONTINUATIONS", "2"))
+	if err != nil || value < 0 {
+		return 2
+	}
+	return value
+}
+
+// Helper function: Number of requests allowed to fix an invalid &&json payload
+func maxRepairAttempts() int {
+	value, err := strconv.Atoi(config.GetEnv("PAYLOAD_REPAIR_ATTEMPTS", "2"))
+	if err != nil || value < 0 {
+		return 2
+	}
+	return value
+}
+
+// Helper function: Repair the payload of an answer, save the exchange and
+// build the reply
+func (h *handler) finishTurn(ctx context.Context, turn *chatTurn, request llm.ChatRequest, resp *llm.ChatResponse) chatReply {
+	repaired := payload.Repair(ctx, h.llm, request, resp.Text, maxRepairAttempts())
+	if repaired.Attempts > 0 {
+		log.Printf("Payload repaired after %d attempts, valid: %v", repaired.Attempts, repaired.Result.Valid())
+	}
+	resp.Text = repaired.Answer
+	resp.Usage.Add(repaired.Usage)
+
+	message := aiMessage(resp)
+	message.Repairs = repaired.Attempts
+	message.PromptVersion = turn.prompt
+	conversation, saved := h.saveTurn(turn, message)
+	if saved != nil {
+		message.MessageId, message.ParentId = saved.MessageId, saved.ParentId
+	}
+	if conversation != nil && conversation.Title == "" {
+		go h.titleConversation(conversation.ConversationId, turn.text, repaired.Result.Text)
+	}
+	return chatReply{Message: message, Result: repaired.Result, Context: turn.context}
+}
+
+// Helper function: Build the stored message for a model answer
+func aiMessage(resp *llm.ChatResponse) database.Message {
+	usage := resp.Usage
+	return database.Message{
+		Role:          resp.Role,
+		Content:       resp.Text,
+		CreatedAt:     time.Now(),
+		ContentType:   "text",
+		FinishReason:  resp.FinishReason,
+		SafetyRatings: resp.SafetyRatings,
+		Usage:         &usage,
+		Continuations: resp.Continuations,
+		Alternatives:  resp.Alternatives,
+	}
+}
+
+// Helper function: Build the model history of a path. Uploads expire and
+// are replaced when refreshed, so the files attached to the path are
+// pointed at their current upload; the URI stored with a message is only
+// used for files without a stored copy. Files whose copy is gone are
+// replaced by a note. The messages of path are updated in place.
+func (h *handler) chatHistory(ctx context.Context, path []database.Message) []llm.Message {
+	for i, message := range path {
+		if message.ContentType != "file" || message.BlobKey == "" {
+			continue
+		}
+		file, err := h.files.Attachment(ctx, message.BlobKey)
+		switch {
+		case err == nil:
+			path[i].Content = file.URI
+		case errors.Is(err, files.ErrOriginalMissing):
+			path[i].Content, path[i].ContentType = "(An attached file is no longer available.)", "text"
+		default:
+			log.Printf("Failed to refresh attachment %s: %v", message.BlobKey, err)
+		}
+	}
+	return database.ChatHistory(path)
+}
+
+// Helper function: Persist a completed exchange to the session history and
+// return the conversation it belongs to with the saved answer
+func (h *handler) saveTurn(turn *chatTurn, message database.Message) (*models.Conversation, *database.Message) {
+	conversation, err := h.sessions.EnsureConversation(turn.sessionId, turn.userId, turn.documentId)
+	if err != nil {
+		log.Printf("Failed to save conversation: %v", err)
+		return nil, nil
+	}
+
+	var messages []database.Message
+	if !turn.regenerate && turn.fileURI != "" {
+		messages = append(messages, database.Message{Role: "user", Content: turn.fileURI, CreatedAt: time.Now(), ContentType: "file", BlobKey: turn.fileKey, BlobUserId: turn.userId})
+	}
+	if !turn.regenerate && turn.text != "" {
+		messages = append(messages, database.Message{Role: "user", Content: turn.text, CreatedAt: time.Now(), ContentType: "text"})
+	}
+	messages = append(messages, message)
+
+	// Each message follows the previous one, so stop at the first failure
+	var saved database.Message
+	for i, message := range messages {
+		if i == 0 && turn.branch {
+			saved, err = h.sessions.BranchMessage(turn.sessionId, turn.parentId, message)
+		} else {
+			saved, err = h.sessions.AppendMessage(turn.sessionId, message)
+		}
+		if err != nil {
+			log.Printf("Failed to save %s message: %v", message.Role, err)
+			return conversation, nil
+		}
+	}
+	return conversation, &saved
+}
+
+// request builds the model request of the turn. The text is only sent when
+// there is one: a prompt that is only a file sends the file instead, as
+// models reject empty parts.
+func (t *chatTurn) request() llm.ChatRequest {
+	request := llm.ChatRequest{History: t.history}
+	if t.text != "" {
+		request.Parts = []llm.Part{llm.Text(t.text)}
+	} else if last := len(t.history) - 1; last > 0 && t.history[last].Role == llm.RoleUser {
+		request.History, request.Parts = t.history[:last], t.history[last].Parts
+	}
+	return request
+}
+
+// completeChat sends the turn to the model and responds with the whole answer
+func (h *handler) completeChat(w http.ResponseWriter, req *http.Request, turn *chatTurn) {
+	h.fitContext(req.Context(), turn)
+	request := turn.request()
+	resp, err := llm.Complete(req.Context(), h.llm, request, maxContinuations(), nil)
+	if err != nil {
+		log.Printf("Error generating content: %v", err)
+		respondWithError(w, "Failed to generate content. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if resp.Text == "" {
+		respondWithError(w, blockedMessage, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Return response to user
+	respondWithJSON(w, h.finishTurn(req.Context(), turn, request, resp), http.StatusOK)
+}
+
+// Helper function: Write one Server-Sent Event and flush it to the client
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// streamChat sends the turn to the model and streams the answer as
+// Server-Sent Events:
+//
+//	delta  {"text"}     a piece of the answer
+//	done   chatReply    the stored message with the &&json payload parsed, and
+//	                    repaired when it was invalid
+//	error  {"message"}  generation failed or was blocked
+//
+// The exchange is only saved once the stream completes.
+func (h *handler) streamChat(w http.ResponseWriter, req *http.Request, turn *chatTurn) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := req.Context()
+	h.fitContext(ctx, turn)
+	request := turn.request()
+	resp, err := llm.Complete(ctx, h.llm, request, maxContinuations(), func(text string) error {
+		return writeEvent(w, flusher, "delta", map[string]string{"text": text})
+	})
+	if err != nil {
+		log.Printf("Error streaming content: %v", err)
+		if ctx.Err() == nil {
+			writeEvent(w, flusher, "error", map[string]string{"message": "Failed to generate content. " + err.Error()})
+		}
+		return
+	}
+
+	if resp.Text == "" {
+		writeEvent(w, flusher, "error", map[string]any{"message": blockedMessage, "finishReason": resp.FinishReason, "safetyRatings": resp.SafetyRatings})
+		return
+	}
+
+	writeEvent(w, flusher, "done", h.finishTurn(ctx, turn, request, resp))
+}
+
+// Chat with a reference document handler.
+func (h *handler) chatWithAIDocs(w http.ResponseWriter, req *http.Request) {
+	if turn, ok := h.prepareDocsChat(w, req); ok {
+		h.completeChat(w, req, turn)
+	}
+}
+
+// Chat handler.
+func (h *handler) chatWithAI(w http.ResponseWriter, req *http.Request) {
+	if turn, ok := h.prepareChat(w, req); ok {
+		h.completeChat(w, req, turn)
+	}
+}
+
+// Streaming chat with a reference document handler.
+func (h *handler) chatWithAIDocsStream(w http.ResponseWriter, req *http.Request) {
+	if turn, ok := h.prepareDocsChat(w, req); ok {
+		h.streamChat(w, req, turn)
+	}
+}
+
+// Streaming chat handler.
+func (h *handler) chatWithAIStream(w http.ResponseWriter, req *http.Request) {
+	if turn, ok := h.prepareChat(w, req); ok {
+		h.streamChat(w, req, turn)
+	}
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/integems/report-agent/src/auth"
+	"github.com/integems/report-agent/src/database"
+	"github.com/integems/report-agent/src/documents"
+	"github.com/integems/report-agent/src/files"
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/llm/fake"
+	"github.com/integems/report-agent/src/prompts"
+	"github.com/integems/report-agent/src/storage"
+)
+
+// reply is the answer of the fake model, with a valid payload
+const reply = "Here is the deck.\n" + "&&json\n" + `{"slides": [{"data": [{"type": "Text", "value": "Revenue", "options": {"x": 1, "y": 1, "w": 4, "h": 1}}]}]}`
+
+// newTestHandler builds a handler on the database named by TEST_DB_NAME,
+// which is created and migrated when needed. The DB_* and REDIS_* variables
+// locate the servers; Redis only caches messages and may be down.
+func newTestHandler(t *testing.T, provider llm.Provider) *handler {
+	t.Helper()
+	name := os.Getenv("TEST_DB_NAME")
+	if name == "" {
+		t.Skip("TEST_DB_NAME is not set")
+	}
+	t.Setenv("DB_NAME", name)
+
+	db := database.NewDatabaseConnection()
+	if err := database.AutoMigrateTables(db); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	rdb := database.NewRedisConnection()
+	t.Cleanup(func() { rdb.Close() })
+
+	registry, err := prompts.Load("../../prompts")
+	if err != nil {
+		t.Fatalf("Failed to load prompts: %v", err)
+	}
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager := files.NewManager(db, provider, store)
+	sessions := database.NewSessionManager(db, rdb)
+	h := &handler{
+		mux:        http.NewServeMux(),
+		db:         db,
+		rdb:        rdb,
+		sessions:   sessions,
+		publicMux:  http.NewServeMux(),
+		tokenStore: database.NewTokenStore(rdb),
+		llm:        provider,
+		prompts:    registry,
+		files:      manager,
+		documents:  documents.NewManager(db, sessions, manager),
+	}
+	h.RegisterHandlers()
+	return h
+}
+
+// chat posts a chat form as claims and returns the recorded response
+func chat(t *testing.T, h *handler, claims *auth.Claims, path string, fields map[string]string, attachment string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	if attachment != "" {
+		part, err := form.CreateFormFile("file", "notes.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(attachment))
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = req.WithContext(auth.WithUser(req.Context(), claims))
+	recorder := httptest.NewRecorder()
+	h.mux.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// waitForTitle waits for the title the handler generates in the background
+// after the first exchange, which also asks the model
+func waitForTitle(t *testing.T, h *handler, sessionId string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		conversation, err := h.sessions.Conversation(sessionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conversation != nil && conversation.Title != "" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("conversation was not titled")
+}
+
+// deleteSession removes the conversation of a test and its attachments
+func deleteSession(h *handler, sessionId string) {
+	attachments, err := h.sessions.DeleteConversation(sessionId)
+	if err == nil {
+		h.releaseAttachments(context.Background(), attachments)
+	}
+}
+
+func TestChatTurn(t *testing.T) {
+	provider := fake.New()
+	provider.Reply = reply
+	h := newTestHandler(t, provider)
+
+	claims := &auth.Claims{UserId: uuid.NewString(), Role: auth.RoleUser}
+	sessionId := uuid.NewString()
+	t.Cleanup(func() { deleteSession(h, sessionId) })
+
+	// Unique content so the blob is not shared with other runs
+	attachment := "Revenue grew in " + uuid.NewString()
+	recorder := chat(t, h, claims, "/ai-chat", map[string]string{"text": "Make a revenue deck", "sessionId": sessionId}, attachment)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var answer struct {
+		MessageId uint              `json:"messageId"`
+		Text      string            `json:"text"`
+		Slides    []json.RawMessage `json:"slides"`
+		Warnings  []string          `json:"warnings"`
+		Context   *contextUsage     `json:"context"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &answer); err != nil {
+		t.Fatal(err)
+	}
+	if answer.MessageId == 0 || answer.Text != "Here is the deck." || len(answer.Slides) != 1 || len(answer.Warnings) != 0 {
+		t.Errorf("answer = %s", recorder.Body)
+	}
+	if answer.Context == nil || answer.Context.Tokens == 0 {
+		t.Errorf("context usage missing: %s", recorder.Body)
+	}
+	waitForTitle(t, h, sessionId)
+
+	// The model sees the system prompt and the attached file before the question
+	request := provider.Requests[0]
+	if len(request.History) != 2 || request.History[0].Parts[0].Text == "" {
+		t.Fatalf("history = %+v", request.History)
+	}
+	fileURI := request.History[1].Parts[0].FileURI
+	if !strings.HasPrefix(fileURI, "fake://") {
+		t.Errorf("attached file was not uploaded: %+v", request.History[1])
+	}
+	if len(request.Parts) != 1 || request.Parts[0].Text != "Make a revenue deck" {
+		t.Errorf("parts = %+v", request.Parts)
+	}
+
+	messages, err := h.sessions.GetMessages(sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("%d messages saved, want the file, the question and the answer", len(messages))
+	}
+	file, question, saved := messages[0], messages[1], messages[2]
+	if file.ContentType != "file" || file.Content != fileURI || file.BlobKey == "" || file.BlobUserId != claims.UserId {
+		t.Errorf("file message = %+v", file)
+	}
+	if question.Content != "Make a revenue deck" || saved.MessageId != answer.MessageId || saved.Content != reply || saved.PromptVersion == "" {
+		t.Errorf("messages = %+v, %+v", question, saved)
+	}
+
+	// The next turn carries the exchange and the file in its history
+	provider.Requests = nil
+	recorder = chat(t, h, claims, "/ai-chat", map[string]string{"text": "Add a chart", "sessionId": sessionId}, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+	history := provider.Requests[0].History
+	if len(history) != 4 {
+		t.Fatalf("history = %+v", history)
+	}
+	if history[1].Parts[0].FileURI != fileURI || history[2].Parts[0].Text != "Make a revenue deck" || history[3].Role != llm.RoleModel {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestChatTurnRequest(t *testing.T) {
+	system := llm.Message{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("instructions")}}
+	file := llm.Message{Role: llm.RoleUser, Parts: []llm.Part{llm.FileData("fake://notes")}}
+
+	turn := &chatTurn{text: "Make a deck", history: []llm.Message{system, file}}
+	request := turn.request()
+	if len(request.History) != 2 || len(request.Parts) != 1 || request.Parts[0].Text != "Make a deck" {
+		t.Errorf("request with text = %+v", request)
+	}
+
+	// A prompt that is only a file sends the file, not an empty text
+	turn = &chatTurn{history: []llm.Message{system, file}}
+	request = turn.request()
+	if len(request.History) != 1 || len(request.Parts) != 1 || request.Parts[0].FileURI != "fake://notes" {
+		t.Errorf("request without text = %+v", request)
+	}
+}
+
+func TestChatTurnForbidden(t *testing.T) {
+	provider := fake.New()
+	provider.Reply = reply
+	h := newTestHandler(t, provider)
+
+	owner := &auth.Claims{UserId: uuid.NewString(), Role: auth.RoleUser}
+	sessionId := uuid.NewString()
+	t.Cleanup(func() { deleteSession(h, sessionId) })
+
+	if recorder := chat(t, h, owner, "/ai-chat", map[string]string{"text": "Hello", "sessionId": sessionId}, ""); recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+	waitForTitle(t, h, sessionId)
+
+	other := &auth.Claims{UserId: uuid.NewString(), Role: auth.RoleUser}
+	if recorder := chat(t, h, other, "/ai-chat", map[string]string{"text": "Hello", "sessionId": sessionId}, ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("status for another user = %d, want 403", recorder.Code)
+	}
+	viewer := &auth.Claims{UserId: owner.UserId, Role: auth.RoleViewer}
+	if recorder := chat(t, h, viewer, "/ai-chat", map[string]string{"text": "Hello", "sessionId": sessionId}, ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("status for a viewer = %d, want 403", recorder.Code)
+	}
+}
+
+func TestChatTurnStream(t *testing.T) {
+	provider := fake.New()
+	provider.Reply = reply
+	h := newTestHandler(t, provider)
+
+	claims := &auth.Claims{UserId: uuid.NewString(), Role: auth.RoleUser}
+	sessionId := uuid.NewString()
+	t.Cleanup(func() { deleteSession(h, sessionId) })
+
+	recorder := chat(t, h, claims, "/ai-chat/stream", map[string]string{"text": "Make a revenue deck", "sessionId": sessionId}, "")
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content type = %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	waitForTitle(t, h, sessionId)
+
+	var streamed strings.Builder
+	var done struct {
+		MessageId uint              `json:"messageId"`
+		Text      string            `json:"text"`
+		Slides    []json.RawMessage `json:"slides"`
+	}
+	var event string
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		switch event {
+		case "delta":
+			var delta struct{ Text string }
+			if err := json.Unmarshal([]byte(data), &delta); err != nil {
+				t.Fatal(err)
+			}
+			streamed.WriteString(delta.Text)
+		case "done":
+			if err := json.Unmarshal([]byte(data), &done); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Errorf("unexpected %s event: %s", event, data)
+		}
+	}
+
+	if streamed.String() != reply {
+		t.Errorf("streamed %q, want %q", streamed.String(), reply)
+	}
+	if done.MessageId == 0 || done.Text != "Here is the deck." || len(done.Slides) != 1 {
+		t.Errorf("done = %+v", done)
+	}
+	messages, err := h.sessions.GetMessages(sessionId)
+	if err != nil || len(messages) != 2 {
+		t.Errorf("saved messages = %+v, %v", messages, err)
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/integems/report-agent/config"
+	"github.com/integems/report-agent/src/database"
+	"github.com/integems/report-agent/src/llm"
+)
+
+// contextUsage reports how the history of a turn fits in the context budget
+type contextUsage struct {
+	Budget        int `json:"budget"`                  // Tokens allowed for a request, 0 when unlimited
+	Tokens        int `json:"tokens"`                  // Estimated tokens of the request
+	Summarized    int `json:"summarized"`              // Messages replaced by the summary
+	SummaryTokens int `json:"summaryTokens,omitempty"` // Estimated tokens of the summary
+}
+
+// Helper function: Tokens allowed for the instructions, history and prompt
+// of a request, 0 for no limit
+func contextBudget() int {
+	value, err := strconv.Atoi(config.GetEnv("CONTEXT_TOKEN_BUDGET", "100000"))
+	if err != nil || value < 0 {
+		return 100000
+	}
+	return value
+}
+
+// Helper function: Longest summary of the older messages, in words
+func summaryWords() int {
+	value, err := strconv.Atoi(config.GetEnv("CONTEXT_SUMMARY_WORDS", "600"))
+	if err != nil || value <= 0 {
+		return 600
+	}
+	return value
+}
+
+// Helper function: Fit the history of a turn in the context budget. The
+// messages covered by the stored summary of the conversation are replaced
+// by it. When the history is still too long, the older messages are
+// condensed into a new summary and the newest ones are kept, using at most
+// half of the budget. Attached files are always kept.
+func (h *handler) fitContext(ctx context.Context, turn *chatTurn) {
+	budget := contextBudget()
+	turn.context = &contextUsage{Budget: budget}
+	path := turn.path
+	system, attached := turn.history[0], turn.history[1+len(path):]
+
+	fixed := llm.EstimateHistoryTokens(append([]llm.Message{system}, attached...)) + llm.EstimateTokens(turn.text)
+	cost := func(summary string, start int) int {
+		tokens := fixed + llm.EstimateTokens(summary)
+		for i, message := range path {
+			if i >= start || message.ContentType == "file" {
+				tokens += message.Tokens
+			}
+		}
+		return tokens
+	}
+
+	// The stored summary applies when its last message is on this branch
+	summary, start := "", 0
+	conversation, err := h.sessions.Conversation(turn.sessionId)
+	if err != nil {
+		log.Printf("Failed to load the summary of %s: %v", turn.sessionId, err)
+	}
+	if conversation != nil && conversation.SummaryMessageId != nil {
+		for i, message := range path {
+			if message.MessageId == *conversation.SummaryMessageId {
+				summary, start = conversation.Summary, i+1
+				break
+			}
+		}
+	}
+
+	if budget > 0 && cost(summary, start) > budget {
+		// Keep the newest messages that fit in half of what is left
+		keep, kept := len(path), 0
+		for keep > start && kept+path[keep-1].Tokens <= (budget-fixed)/2 {
+			kept += path[keep-1].Tokens
+			keep--
+		}
+
+		if keep > start && path[keep-1].MessageId != 0 {
+			condensed, err := h.summarize(ctx, summary, path[start:keep])
+			if err != nil {
+				log.Printf("Failed to summarize %s: %v", turn.sessionId, err)
+			} else {
+				summary, start = condensed, keep
+				if err := h.sessions.SaveSummary(turn.sessionId, summary, path[keep-1].MessageId); err != nil {
+					log.Printf("Failed to save the summary of %s: %v", turn.sessionId, err)
+				}
+			}
+		}
+	}
+
+	if start > 0 {
+		history := []llm.Message{system}
+		for _, message := range path[:start] {
+			if message.ContentType == "file" {
+				uri, _ := message.Content.(string)
+				history = append(history, llm.Message{Parts: []llm.Part{llm.FileData(uri)}, Role: message.Role})
+			}
+		}
+		history = append(history, llm.Message{Parts: []llm.Part{
+			llm.Text("Summary of the earlier conversation:\n\n" + summary),
+		}, Role: llm.RoleUser})
+		history = append(history, database.ChatHistory(path[start:])[1:]...)
+		turn.history = append(history, attached...)
+	}
+
+	turn.context.Tokens = cost(summary, start)
+	turn.context.Summarized = start
+	turn.context.SummaryTokens = llm.EstimateTokens(summary)
+}
+
+// Helper function: Condense messages, after the previous summary, into a
+// new summary
+func (h *handler) summarize(ctx context.Context, previous string, messages []database.Message) (string, error) {
+	var transcript strings.Builder
+	if previous != "" {
+		transcript.WriteString("Summary of the conversation so far:\n" + previous + "\n\n")
+	}
+	for _, message := range messages {
+		role := "Assistant"
+		if message.Role == llm.RoleUser {
+			role = "User"
+		}
+		content, _ := message.Content.(string)
+		if message.ContentType == "file" {
+			content = "[Attached file]"
+		}
+		transcript.WriteString(role + ": " + content + "\n\n")
+	}
+
+	prompt, _, err := h.prompts.Render("context-summary", map[string]any{
+		"Words":      summaryWords(),
+		"Transcript": transcript.String(),
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.llm.Chat(ctx, llm.ChatRequest{Parts: []llm.Part{llm.Text(prompt)}})
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(resp.Text) == "" {
+		return "", errors.New("the summary is empty")
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
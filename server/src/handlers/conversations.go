@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/integems/report-agent/src/auth"
+	"github.com/integems/report-agent/src/database"
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/models"
+	"gorm.io/gorm"
+)
+
+// maxTitleLength is the longest conversation title, in characters
+const maxTitleLength = 200
+
+// Helper function: Load a conversation the caller owns. Conversations
+// without an owner can only be changed by admins.
+func (h *handler) ownedConversation(w http.ResponseWriter, claims *auth.Claims, conversationId string) (*models.Conversation, bool) {
+	var conversation models.Conversation
+	if err := h.db.Where(&models.Conversation{ConversationId: conversationId}).First(&conversation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(w, "Conversation not found.", http.StatusNotFound)
+			return nil, false
+		}
+		respondWithError(w, "Failed to fetch conversation. "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if conversation.UserId != claims.UserId && !claims.IsAdmin() {
+		respondWithError(w, "You do not have access to this conversation.", http.StatusForbidden)
+		return nil, false
+	}
+	return &conversation, true
+}
+
+// Helper function: Find the reference document of a chat. A conversation
+// keeps the document it was started with, at the version it is pinned to; a
+// new conversation takes the documentId of the request, and sessions named
+// after a document keep working.
+func (h *handler) conversationDocument(w http.ResponseWriter, claims *auth.Claims, sessionId, documentId string) (*models.Document, bool) {
+	if sessionId == "" {
+		respondWithError(w, "Session ID is required.", http.StatusBadRequest)
+		return nil, false
+	}
+	conversation, err := h.sessions.Conversation(sessionId)
+	if err != nil {
+		respondWithError(w, "Failed to fetch conversation. "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if conversation != nil && conversation.UserId != claims.UserId && !claims.IsAdmin() {
+		respondWithError(w, "You do not have access to this conversation.", http.StatusForbidden)
+		return nil, false
+	}
+	documentId, version := sessionDocumentId(conversation, sessionId, documentId)
+	document, ok := h.ownedDocument(w, claims, documentId)
+	if !ok {
+		return nil, false
+	}
+	revision, err := h.documents.At(document, version)
+	if err != nil {
+		respondWithVersionError(w, err)
+		return nil, false
+	}
+	return revision, true
+}
+
+// Helper function: The ID of the reference document of a session and the
+// version its conversation is pinned to. conversation is nil for a new
+// session, which takes documentId or else the document named like it.
+func sessionDocumentId(conversation *models.Conversation, sessionId, documentId string) (string, *int) {
+	if conversation != nil && conversation.DocumentId != nil {
+		return *conversation.DocumentId, conversation.DocumentVersion
+	}
+	if documentId == "" {
+		documentId = sessionId
+	}
+	return documentId, nil
+}
+
+// Helper function: Check the version a conversation is pinned to. Version 0
+// means the latest one and unpins the conversation.
+func (h *handler) pinnedVersion(w http.ResponseWriter, documentId string, version int) (*int, bool) {
+	if version == 0 {
+		return nil, true
+	}
+	found, err := h.documents.Version(documentId, version)
+	if err != nil {
+		respondWithVersionError(w, err)
+		return nil, false
+	}
+	return &found.Version, true
+}
+
+// Helper function: Clean a conversation title
+func conversationTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength])
+	}
+	return title
+}
+
+// List conversations handler. Lists the caller's conversations, pinned
+// first and then by latest activity. ?documentId= filters by document and
+// ?archived=true lists the archived conversations instead. Conversations of
+// documents in the trash are hidden.
+func (h *handler) getConversations(w http.ResponseWriter, req *http.Request) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return
+	}
+
+	query := h.db.Where(`"userId" = ?`, claims.UserId).
+		Where("archived = ?", req.URL.Query().Get("archived") == "true").
+		Where(`("documentId" IS NULL OR "documentId" IN (SELECT "documentId" FROM documents WHERE "deletedAt" IS NULL))`)
+	if documentId := req.URL.Query().Get("documentId"); documentId != "" {
+		query = query.Where(`"documentId" = ?`, documentId)
+	}
+
+	conversations := []models.Conversation{}
+	if err := query.Order(`pinned DESC, "updatedAt" DESC`).Find(&conversations).Error; err != nil {
+		respondWithError(w, "Failed to fetch conversations. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, conversations, http.StatusOK)
+}
+
+// Create conversation handler. The conversationId is used as the sessionId
+// of the chat endpoints. A documentVersion pins the conversation to a
+// version of its document instead of following the latest one.
+func (h *handler) createConversation(w http.ResponseWriter, req *http.Request) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Title           string `json:"title"`
+		DocumentId      string `json:"documentId"`
+		DocumentVersion int    `json:"documentVersion"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, "Invalid request payload. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conversation := models.Conversation{
+		ConversationId: uuid.New().String(),
+		UserId:         claims.UserId,
+		Title:          conversationTitle(request.Title),
+	}
+	if request.DocumentId != "" {
+		document, ok := h.ownedDocument(w, claims, request.DocumentId)
+		if !ok {
+			return
+		}
+		conversation.DocumentId = &document.DocumentId
+		if conversation.DocumentVersion, ok = h.pinnedVersion(w, document.DocumentId, request.DocumentVersion); !ok {
+			return
+		}
+	} else if request.DocumentVersion != 0 {
+		respondWithError(w, "A document version requires a documentId.", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.Create(&conversation).Error; err != nil {
+		respondWithError(w, "Failed to create conversation. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, conversation, http.StatusCreated)
+}
+
+// Update conversation handler. Renames, pins or archives a conversation, or
+// pins it to a version of its document; documentVersion 0 follows the
+// latest version again.
+func (h *handler) updateConversation(w http.ResponseWriter, req *http.Request) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return
+	}
+
+	conversation, ok := h.ownedConversation(w, claims, req.PathValue("conversationId"))
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Title           *string `json:"title"`
+		Pinned          *bool   `json:"pinned"`
+		Archived        *bool   `json:"archived"`
+		DocumentVersion *int    `json:"documentVersion"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, "Invalid request payload. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]any{}
+	if request.Title != nil {
+		title := conversationTitle(*request.Title)
+		if title == "" {
+			respondWithError(w, "Title cannot be empty.", http.StatusBadRequest)
+			return
+		}
+		updates["title"] = title
+	}
+	if request.Pinned != nil {
+		updates["pinned"] = *request.Pinned
+	}
+	if request.Archived != nil {
+		updates["archived"] = *request.Archived
+	}
+	if request.DocumentVersion != nil {
+		if conversation.DocumentId == nil {
+			respondWithError(w, "Conversation has no reference document.", http.StatusBadRequest)
+			return
+		}
+		version, ok := h.pinnedVersion(w, *conversation.DocumentId, *request.DocumentVersion)
+		if !ok {
+			return
+		}
+		updates["documentVersion"] = version
+	}
+	if len(updates) == 0 {
+		respondWithError(w, "Nothing to update. Send title, pinned, archived or documentVersion.", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.Model(conversation).Updates(updates).Error; err != nil {
+		respondWithError(w, "Failed to update conversation. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, conversation, http.StatusOK)
+}
+
+// Delete conversation handler. Deletes the conversation with its messages.
+func (h *handler) deleteConversation(w http.ResponseWriter, req *http.Request) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return
+	}
+
+	conversation, ok := h.ownedConversation(w, claims, req.PathValue("conversationId"))
+	if !ok {
+		return
+	}
+
+	attachments, err := h.sessions.DeleteConversation(conversation.ConversationId)
+	if err != nil {
+		respondWithError(w, "Failed to delete conversation. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.releaseAttachments(req.Context(), attachments)
+	respondWithJSON(w, map[string]string{"message": "Conversation deleted successfully"}, http.StatusOK)
+}
+
+// Helper function: Name a conversation after its first exchange. The title
+// is only set while the conversation has none, so a rename by the user in
+// the meantime wins.
+func (h *handler) titleConversation(conversationId, question, answer string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	title := ""
+	prompt, _, err := h.prompts.Render("conversation-title", map[string]string{
+		"Question": truncateRunes(question, 2000),
+		"Answer":   truncateRunes(answer, 2000),
+	})
+	if err == nil {
+		var resp *llm.ChatResponse
+		if resp, err = h.llm.Chat(ctx, llm.ChatRequest{Parts: []llm.Part{llm.Text(prompt)}}); err == nil {
+			title = strings.Trim(strings.SplitN(strings.TrimSpace(resp.Text), "\n", 2)[0], " \"'*#.")
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to generate conversation title: %v", err)
+	}
+
+	// Fall back to the start of the question
+	if title == "" {
+		title = truncateRunes(strings.Join(strings.Fields(question), " "), 60)
+	}
+	title = conversationTitle(title)
+	if title == "" {
+		return
+	}
+
+	err = h.db.Model(&models.Conversation{}).
+		Where(`"conversationId" = ? AND title = ''`, conversationId).
+		Update("title", title).Error
+	if err != nil {
+		log.Printf("Failed to save conversation title: %v", err)
+	}
+}
+
+// Helper function: Release the stored files attached to deleted messages,
+// each for the user who attached it
+func (h *handler) releaseAttachments(ctx context.Context, attachments []database.Attachment) {
+	for _, attachment := range attachments {
+		if err := h.files.Release(ctx, attachment.UserId, attachment.BlobKey); err != nil {
+			log.Printf("Failed to release attachment %s: %v", attachment.BlobKey, err)
+		}
+	}
+}
+
+// Helper function: Shorten text to at most n characters
+func truncateRunes(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n])
+}
@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/integems/report-agent/src/auth"
+	"github.com/integems/report-agent/src/database"
+	"github.com/integems/report-agent/src/docx"
+	"github.com/integems/report-agent/src/markdown"
+	"github.com/integems/report-agent/src/models"
+	"github.com/integems/report-agent/src/payload"
+	"github.com/integems/report-agent/src/pdf"
+	"github.com/integems/report-agent/src/pptx"
+	"github.com/integems/report-agent/src/services"
+	"github.com/integems/report-agent/src/xlsx"
+	"gorm.io/gorm"
+)
+
+// Helper function: Load the message at {messageIndex} of session {sessionId}
+// and parse its &&json payload
+func (h *handler) exportedMessage(w http.ResponseWriter, req *http.Request) (*database.Message, *payload.Result, bool) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return nil, nil, false
+	}
+
+	sessionId := req.PathValue("sessionId")
+	if !h.authorizeSession(w, claims, sessionId) {
+		return nil, nil, false
+	}
+
+	index, err := strconv.Atoi(req.PathValue("messageIndex"))
+	if err != nil || index < 0 {
+		respondWithError(w, "Invalid message index.", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	messages, err := h.sessions.GetMessages(sessionId)
+	if err != nil {
+		respondWithError(w, "Failed to fetch messages. "+err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if index >= len(messages) {
+		respondWithError(w, "Message not found.", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	message := messages[index]
+	content, _ := message.Content.(string)
+	if message.ContentType == "file" {
+		content = ""
+	}
+	return &message, payload.Parse(content), true
+}
+
+var unsafeFileName = regexp.MustCompile(`[^A-Za-z0-9 _-]+`)
+
+// Helper function: File name for an export, based on a title when there is one
+func exportFileName(title, fallback, extension string) string {
+	name := strings.TrimSpace(unsafeFileName.ReplaceAllString(title, ""))
+	if len(name) > 60 {
+		name = strings.TrimSpace(name[:60])
+	}
+	if name == "" {
+		name = fallback
+	}
+	return name + "." + extension
+}
+
+// Helper function: Send an exported file as a download
+func sendExport(w http.ResponseWriter, data []byte, contentType, fileName string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Failed to write export: %v", err)
+	}
+}
+
+// Helper function: Title of a deck, the text of its first element
+func slidesTitle(slides []payload.SlideContent) string {
+	for _, slide := range slides {
+		for _, element := range slide.Data {
+			if element.Type == payload.ContentText && element.Value.Text != "" {
+				return strings.SplitN(element.Value.Text, "\n", 2)[0]
+			}
+		}
+	}
+	return ""
+}
+
+// Export a message as a PowerPoint presentation handler.
+func (h *handler) exportMessagePptx(w http.ResponseWriter, req *http.Request) {
+	_, result, ok := h.exportedMessage(w, req)
+	if !ok {
+		return
+	}
+	if len(result.Slides) == 0 {
+		respondWithError(w, "Message has no slides.", http.StatusNotFound)
+		return
+	}
+
+	title := slidesTitle(result.Slides)
+	author := ""
+	if claims, ok := auth.UserFromContext(req.Context()); ok {
+		author = claims.Name
+	}
+	var buffer bytes.Buffer
+	err := pptx.Render(req.Context(), &buffer, result.Slides, pptx.Options{
+		Title:     title,
+		Author:    author,
+		LoadImage: services.FetchImage,
+	})
+	if err != nil {
+		respondWithError(w, "Failed to generate presentation. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendExport(w, buffer.Bytes(), pptx.ContentType, exportFileName(title, "presentation", "pptx"))
+}
+
+// Export a message as an Excel workbook handler.
+func (h *handler) exportMessageXlsx(w http.ResponseWriter, req *http.Request) {
+	_, result, ok := h.exportedMessage(w, req)
+	if !ok {
+		return
+	}
+	sheets := result.Workbook()
+	if len(sheets) == 0 {
+		respondWithError(w, "Message has no Excel data.", http.StatusNotFound)
+		return
+	}
+
+	title := sheets[0].Name
+	if title == "" {
+		title = slidesTitle(result.Slides)
+	}
+	author := ""
+	if claims, ok := auth.UserFromContext(req.Context()); ok {
+		author = claims.Name
+	}
+	var buffer bytes.Buffer
+	if err := xlsx.Render(&buffer, sheets, xlsx.Options{Title: title, Author: author}); err != nil {
+		respondWithError(w, "Failed to generate spreadsheet. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendExport(w, buffer.Bytes(), xlsx.ContentType, exportFileName(title, "spreadsheet", "xlsx"))
+}
+
+// Helper function: Read the original of a document
+func (h *handler) readOriginal(ctx context.Context, document *models.Document) ([]byte, error) {
+	reader, err := h.files.Open(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Helper function: Reference document whose styles a Word export inherits.
+// The reference query parameter names one of the user's documents and "none"
+// turns inheritance off. By default the document of the conversation, at the
+// version it is pinned to, is used when it is a Word file.
+func (h *handler) exportReference(w http.ResponseWriter, req *http.Request) (*docx.Reference, bool) {
+	documentId := req.URL.Query().Get("reference")
+	if documentId == "none" {
+		return nil, true
+	}
+
+	explicit := documentId != ""
+	var document *models.Document
+	if explicit {
+		claims, ok := currentUser(w, req)
+		if !ok {
+			return nil, false
+		}
+		if document, ok = h.ownedDocument(w, claims, documentId); !ok {
+			return nil, false
+		}
+	} else {
+		sessionId := req.PathValue("sessionId")
+		conversation, err := h.sessions.Conversation(sessionId)
+		if err != nil {
+			log.Printf("Failed to fetch conversation: %v", err)
+			return nil, true
+		}
+		documentId, version := sessionDocumentId(conversation, sessionId, "")
+
+		var found models.Document
+		if err := h.db.Where(&models.Document{DocumentId: documentId}).First(&found).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("Failed to fetch reference document: %v", err)
+			}
+			return nil, true
+		}
+		if document, err = h.documents.At(&found, version); err != nil {
+			log.Printf("Failed to fetch reference document: %v", err)
+			return nil, true
+		}
+	}
+
+	if !isWordDocument(document.Type) {
+		if explicit {
+			respondWithError(w, "Reference document must be a Word document.", http.StatusBadRequest)
+			return nil, false
+		}
+		return nil, true
+	}
+
+	data, err := h.readOriginal(req.Context(), document)
+	if err == nil {
+		var reference *docx.Reference
+		if reference, err = docx.LoadReference(data); err == nil {
+			return reference, true
+		}
+	}
+	log.Printf("Failed to load reference document %s: %v", document.DocumentId, err)
+	if explicit {
+		respondWithError(w, "Reference document is not available.", http.StatusNotFound)
+		return nil, false
+	}
+	return nil, true
+}
+
+// Helper function: Title of a markdown answer, its first heading
+func markdownTitle(blocks []markdown.Block) string {
+	for _, block := range blocks {
+		if block.Kind == markdown.Heading {
+			return markdown.PlainText(block.Inlines)
+		}
+	}
+	return ""
+}
+
+// Export a message as a Word document handler.
+func (h *handler) exportMessageDocx(w http.ResponseWriter, req *http.Request) {
+	_, result, ok := h.exportedMessage(w, req)
+	if !ok {
+		return
+	}
+	if strings.TrimSpace(result.Text) == "" {
+		respondWithError(w, "Message has no text.", http.StatusNotFound)
+		return
+	}
+	reference, ok := h.exportReference(w, req)
+	if !ok {
+		return
+	}
+
+	blocks := markdown.Parse(result.Text)
+	title := markdownTitle(blocks)
+	author := ""
+	if claims, ok := auth.UserFromContext(req.Context()); ok {
+		author = claims.Name
+	}
+	var buffer bytes.Buffer
+	err := docx.Render(req.Context(), &buffer, blocks, docx.Options{
+		Title:     title,
+		Author:    author,
+		Reference: reference,
+		LoadImage: services.FetchImage,
+	})
+	if err != nil {
+		respondWithError(w, "Failed to generate document. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendExport(w, buffer.Bytes(), docx.ContentType, exportFileName(title, "document", "docx"))
+}
+
+// Helper function: Read a boolean query parameter, fallback when missing
+func queryBool(w http.ResponseWriter, req *http.Request, name string, fallback bool) (bool, bool) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return fallback, true
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid %s value.", name), http.StatusBadRequest)
+		return false, false
+	}
+	return parsed, true
+}
+
+// Helper function: Page setup of a PDF export from the query. pageSize is A3,
+// A4, A5, Letter, Legal or WIDTHxHEIGHT in millimeters, orientation is
+// portrait or landscape and margin is in millimeters, given once or as
+// top,right,bottom,left. header replaces the title at the top of the pages
+// and "none" removes it; pageNumbers and date toggle the footer.
+func pdfOptions(w http.ResponseWriter, req *http.Request, title string, slides bool) (pdf.Options, bool) {
+	query := req.URL.Query()
+	options := pdf.Options{Title: title, Header: title, LoadImage: services.FetchImage}
+	if slides {
+		// Slides carry their own titles
+		options.Header = ""
+	}
+	if claims, ok := auth.UserFromContext(req.Context()); ok {
+		options.Author = claims.Name
+	}
+
+	if size := query.Get("pageSize"); size != "" {
+		width, height, ok := pdf.PageSize(size)
+		if !ok {
+			dimensions := strings.Split(strings.ToLower(size), "x")
+			if len(dimensions) == 2 {
+				mmWidth, errW := strconv.ParseFloat(strings.TrimSpace(dimensions[0]), 64)
+				mmHeight, errH := strconv.ParseFloat(strings.TrimSpace(dimensions[1]), 64)
+				if errW == nil && errH == nil && mmWidth >= 50 && mmHeight >= 50 && mmWidth <= 2000 && mmHeight <= 2000 {
+					width, height, ok = pdf.Millimeters(mmWidth), pdf.Millimeters(mmHeight), true
+				}
+			}
+		}
+		if !ok {
+			respondWithError(w, "Invalid pageSize. Use A3, A4, A5, Letter, Legal or WIDTHxHEIGHT in millimeters.", http.StatusBadRequest)
+			return options, false
+		}
+		options.Width, options.Height = width, height
+	}
+	switch query.Get("orientation") {
+	case "", "portrait":
+	case "landscape":
+		if options.Width == 0 {
+			options.Width, options.Height, _ = pdf.PageSize("A4")
+		}
+		if options.Width < options.Height {
+			options.Width, options.Height = options.Height, options.Width
+		}
+	default:
+		respondWithError(w, "Invalid orientation. Use portrait or landscape.", http.StatusBadRequest)
+		return options, false
+	}
+
+	if margin := query.Get("margin"); margin != "" {
+		var values []float64
+		for _, part := range strings.Split(margin, ",") {
+			value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || value < 5 || value > 100 {
+				respondWithError(w, "Invalid margin. Give millimeters between 5 and 100, once or as top,right,bottom,left.", http.StatusBadRequest)
+				return options, false
+			}
+			values = append(values, pdf.Millimeters(value))
+		}
+		switch len(values) {
+		case 1:
+			options.Margins = pdf.Margins{Top: values[0], Right: values[0], Bottom: values[0], Left: values[0]}
+		case 4:
+			options.Margins = pdf.Margins{Top: values[0], Right: values[1], Bottom: values[2], Left: values[3]}
+		default:
+			respondWithError(w, "Invalid margin. Give millimeters between 5 and 100, once or as top,right,bottom,left.", http.StatusBadRequest)
+			return options, false
+		}
+	}
+
+	if header, ok := query["header"]; ok {
+		options.Header = strings.TrimSpace(header[0])
+		if options.Header == "none" {
+			options.Header = ""
+		}
+	}
+	pageNumbers, ok := queryBool(w, req, "pageNumbers", true)
+	if !ok {
+		return options, false
+	}
+	options.PageNumbers = pageNumbers
+	date, ok := queryBool(w, req, "date", true)
+	if !ok {
+		return options, false
+	}
+	if date {
+		options.Date = time.Now()
+	}
+	return options, true
+}
+
+// Export a message as a PDF file handler. mode=document renders the text of
+// the answer and mode=slides its slide deck, one 16:9 page per slide. By
+// default the text is rendered, or the slides when there is no text.
+func (h *handler) exportMessagePdf(w http.ResponseWriter, req *http.Request) {
+	_, result, ok := h.exportedMessage(w, req)
+	if !ok {
+		return
+	}
+
+	mode := req.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "document"
+		if strings.TrimSpace(result.Text) == "" && len(result.Slides) > 0 {
+			mode = "slides"
+		}
+	}
+
+	var buffer bytes.Buffer
+	var title string
+	switch mode {
+	case "document":
+		if strings.TrimSpace(result.Text) == "" {
+			respondWithError(w, "Message has no text.", http.StatusNotFound)
+			return
+		}
+		blocks := markdown.Parse(result.Text)
+		title = markdownTitle(blocks)
+		options, ok := pdfOptions(w, req, title, false)
+		if !ok {
+			return
+		}
+		if err := pdf.Render(req.Context(), &buffer, blocks, options); err != nil {
+			respondWithError(w, "Failed to generate PDF. "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "slides":
+		if len(result.Slides) == 0 {
+			respondWithError(w, "Message has no slides.", http.StatusNotFound)
+			return
+		}
+		title = slidesTitle(result.Slides)
+		options, ok := pdfOptions(w, req, title, true)
+		if !ok {
+			return
+		}
+		if err := pdf.RenderSlides(req.Context(), &buffer, result.Slides, options); err != nil {
+			respondWithError(w, "Failed to generate PDF. "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		respondWithError(w, "Invalid mode. Use document or slides.", http.StatusBadRequest)
+		return
+	}
+	sendExport(w, buffer.Bytes(), pdf.ContentType, exportFileName(title, mode, "pdf"))
+}
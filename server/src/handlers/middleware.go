@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/integems/report-agent/src/auth"
+	"github.com/integems/report-agent/src/models"
+	"gorm.io/gorm"
+)
+
+// Helper function: Read the bearer token from the Authorization header
+func bearerToken(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// isPublicRoute reports whether the request matches a route registered with handlePublic
+func (h *handler) isPublicRoute(req *http.Request) bool {
+	_, pattern := h.publicMux.Handler(req)
+	return pattern != ""
+}
+
+// Authenticate verifies the access token on every non-public route and
+// stores the authenticated user in the request context.
+func (h *handler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tokenString := bearerToken(req)
+		if h.isPublicRoute(req) {
+			// Public routes still see the caller when a valid token is sent
+			if claims, err := auth.ParseToken(tokenString); err == nil {
+				if revoked, err := h.isTokenRevoked(req.Context(), claims); err == nil && !revoked {
+					req = req.WithContext(auth.WithUser(req.Context(), claims))
+				}
+			}
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if tokenString == "" {
+			respondWithError(w, "Authorization token is required.", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := auth.ParseToken(tokenString)
+		if err != nil {
+			respondWithError(w, "Invalid or expired token.", http.StatusUnauthorized)
+			return
+		}
+
+		if revoked, err := h.isTokenRevoked(req.Context(), claims); err != nil {
+			respondWithError(w, "Failed to verify token. "+err.Error(), http.StatusInternalServerError)
+			return
+		} else if revoked {
+			respondWithError(w, "Invalid or expired token.", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(auth.WithUser(req.Context(), claims)))
+	})
+}
+
+// isTokenRevoked reports whether the token was signed out, or issued before
+// the user's sessions were last revoked, e.g. by a password reset.
+func (h *handler) isTokenRevoked(ctx context.Context, claims *auth.Claims) (bool, error) {
+	if revoked, err := h.tokenStore.IsAccessTokenRevoked(ctx, claims.ID); err != nil || revoked {
+		return revoked, err
+	}
+
+	revokedAt, err := h.tokenStore.SessionsRevokedAt(ctx, claims.UserId)
+	if err != nil || revokedAt.IsZero() {
+		return false, err
+	}
+	if claims.IssuedAt == nil {
+		return true, nil
+	}
+	return !claims.IssuedAt.Time.After(revokedAt), nil
+}
+
+// Helper function: Get the authenticated user or respond with 401
+func currentUser(w http.ResponseWriter, req *http.Request) (*auth.Claims, bool) {
+	claims, ok := auth.UserFromContext(req.Context())
+	if !ok {
+		respondWithError(w, "Unauthorized.", http.StatusUnauthorized)
+	}
+	return claims, ok
+}
+
+// authorize wraps a handler so that only roles granting permission can reach it.
+func (h *handler) authorize(permission auth.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		claims, ok := currentUser(w, req)
+		if !ok {
+			return
+		}
+		if !claims.Can(permission) {
+			respondWithError(w, "You do not have permission to perform this action.", http.StatusForbidden)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// Helper function: Load a document the user owns, or any document for admins.
+// It responds with the appropriate error and returns false when access is denied.
+func (h *handler) ownedDocument(w http.ResponseWriter, claims *auth.Claims, documentId string) (*models.Document, bool) {
+	return h.ownedDocumentIn(w, claims, documentId, h.db)
+}
+
+// Helper function: Load a document the caller owns from a scope of the
+// documents table, such as the trash
+func (h *handler) ownedDocumentIn(w http.ResponseWriter, claims *auth.Claims, documentId string, scope *gorm.DB) (*models.Document, bool) {
+	var document models.Document
+	if documentId == "" {
+		respondWithError(w, "File not found.", http.StatusNotFound)
+		return nil, false
+	}
+	if err := scope.Where(&models.Document{DocumentId: documentId}).First(&document).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(w, "File not found.", http.StatusNotFound)
+			return nil, false
+		}
+		respondWithError(w, "Failed to fetch file. "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if document.UserId != claims.UserId && !claims.IsAdmin() {
+		respondWithError(w, "You do not have access to this file.", http.StatusForbidden)
+		return nil, false
+	}
+	return &document, true
+}
+
+// Helper function: Check access to a chat session. Sessions tied to a
+// document inherit the document's ownership; other sessions belong to the
+// user who started them, and new sessions are open to the caller. Sessions
+// imported without an owner are reserved to admins.
+func (h *handler) authorizeSession(w http.ResponseWriter, claims *auth.Claims, sessionId string) bool {
+	if sessionId == "" {
+		respondWithError(w, "Session ID is required.", http.StatusBadRequest)
+		return false
+	}
+	// Documents in the trash keep their owner
+	var document models.Document
+	err := h.db.Unscoped().Where(&models.Document{DocumentId: sessionId}).First(&document).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		conversation, err := h.sessions.Conversation(sessionId)
+		if err != nil {
+			respondWithError(w, "Failed to fetch session. "+err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		if conversation != nil && conversation.UserId != claims.UserId && !claims.IsAdmin() {
+			respondWithError(w, "You do not have access to this session.", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	if err != nil {
+		respondWithError(w, "Failed to fetch session. "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if document.UserId != claims.UserId && !claims.IsAdmin() {
+		respondWithError(w, "You do not have access to this session.", http.StatusForbidden)
+		return false
+	}
+	return true
+}
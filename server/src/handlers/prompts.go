@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/prompts"
+)
+
+// Helper function: The first message of a chat against a reference
+// document: the document itself and the instructions of the model. The ID
+// of the prompt version is returned with it.
+func (h *handler) docsSystemMessage(documentURI string) (llm.Message, string, error) {
+	text, version, err := h.prompts.Render("docs-chat", nil)
+	if err != nil {
+		return llm.Message{}, "", err
+	}
+	return llm.Message{Parts: []llm.Part{llm.FileData(documentURI), llm.Text(text)}, Role: llm.RoleUser}, version, nil
+}
+
+// Helper function: The first message of a chat without reference document:
+// the instructions of the model, with the ID of the prompt version
+func (h *handler) chatSystemMessage() (llm.Message, string, error) {
+	text, version, err := h.prompts.Render("chat", nil)
+	if err != nil {
+		return llm.Message{}, "", err
+	}
+	return llm.Message{Parts: []llm.Part{llm.Text(text)}, Role: llm.RoleUser}, version, nil
+}
+
+type promptsResponse struct {
+	Message  string           `json:"message,omitempty"`
+	Prompts  []prompts.Prompt `json:"prompts"`
+	LoadedAt time.Time        `json:"loadedAt"`
+}
+
+// Get prompts handler. Lists every prompt version and which one is active.
+func (h *handler) getPrompts(w http.ResponseWriter, req *http.Request) {
+	list, loadedAt := h.prompts.List()
+	respondWithJSON(w, promptsResponse{Prompts: list, LoadedAt: loadedAt}, http.StatusOK)
+}
+
+// Reload prompts handler. Reads the prompt templates again; the prompts in
+// use are kept when one is invalid.
+func (h *handler) reloadPrompts(w http.ResponseWriter, req *http.Request) {
+	if err := h.prompts.Reload(); err != nil {
+		respondWithError(w, "Failed to reload prompts. "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	list, loadedAt := h.prompts.List()
+	respondWithJSON(w, promptsResponse{Message: "Prompts reloaded successfully", Prompts: list, LoadedAt: loadedAt}, http.StatusOK)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/integems/report-agent/src/auth"
+	"github.com/integems/report-agent/src/database"
+	"github.com/integems/report-agent/src/models"
+)
+
+type tokenResponse struct {
+	Message      string `json:"message,omitempty"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	DeviceId     string `json:"deviceId"`
+	ExpiresIn    int    `json:"expiresIn"` // Access token lifetime in seconds
+}
+
+// issueTokens signs a new access token and rotates the refresh token of the device
+func (h *handler) issueTokens(ctx context.Context, user *models.User, deviceId string) (*tokenResponse, error) {
+	accessToken, err := generateJWT(user, deviceId)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := database.RefreshSession{UserId: user.UserId, DeviceId: deviceId}
+	if err := h.tokenStore.SaveRefreshToken(ctx, refreshHash, session, auth.RefreshTokenTTL()); err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		DeviceId:     deviceId,
+		ExpiresIn:    int(auth.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// Refresh token handler. Exchanges a refresh token for a new token pair;
+// the old refresh token can not be used again.
+func (h *handler) refreshToken(w http.ResponseWriter, req *http.Request) {
+	var request struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		respondWithError(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := req.Context()
+	session, err := h.tokenStore.ConsumeRefreshToken(ctx, auth.HashToken(request.RefreshToken))
+	if err != nil {
+		if errors.Is(err, database.ErrTokenNotFound) {
+			respondWithError(w, "Invalid or expired refresh token.", http.StatusUnauthorized)
+			return
+		}
+		respondWithError(w, "Failed to verify refresh token. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Reload the user so role changes apply on the next refresh
+	var user models.User
+	if err := h.db.Where(models.User{UserId: session.UserId}).First(&user).Error; err != nil {
+		respondWithError(w, "Invalid or expired refresh token.", http.StatusUnauthorized)
+		return
+	}
+	if !user.EmailVerified {
+		respondWithError(w, "Please verify your email before signing in.", http.StatusForbidden)
+		return
+	}
+
+	tokens, err := h.issueTokens(ctx, &user, session.DeviceId)
+	if err != nil {
+		respondWithError(w, "Failed to generate token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, tokens, http.StatusOK)
+}
+
+// Sign-out handler. Revokes the current access token and the refresh token of its device.
+func (h *handler) signOut(w http.ResponseWriter, req *http.Request) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return
+	}
+
+	ctx := req.Context()
+	if claims.ExpiresAt != nil {
+		if err := h.tokenStore.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			respondWithError(w, "Failed to sign out. "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if claims.DeviceId != "" {
+		if err := h.tokenStore.RevokeDevice(ctx, claims.UserId, claims.DeviceId); err != nil {
+			log.Printf("Failed to revoke device: %v", err)
+		}
+	}
+
+	respondWithJSON(w, map[string]string{"message": "Signed out successfully"}, http.StatusOK)
+}
+
+// Sign-out-all handler. Revokes every session of the user on every device.
+func (h *handler) signOutAll(w http.ResponseWriter, req *http.Request) {
+	claims, ok := currentUser(w, req)
+	if !ok {
+		return
+	}
+
+	if err := h.tokenStore.RevokeUserSessions(req.Context(), claims.UserId); err != nil {
+		respondWithError(w, "Failed to sign out. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, map[string]string{"message": "Signed out of all devices"}, http.StatusOK)
+}
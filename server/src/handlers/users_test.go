@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/integems/report-agent/src/auth"
+	"github.com/integems/report-agent/src/mailer"
+	"github.com/integems/report-agent/src/models"
+)
+
+func TestUpdateUserEmailRevokesSessions(t *testing.T) {
+	h := newTestHandler(t, nil)
+	if err := h.rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Redis is not available: %v", err)
+	}
+	h.mailer = &mailer.LogMailer{}
+
+	user := models.User{UserId: uuid.NewString(), Name: "Ada", Email: uuid.NewString() + "@example.com", EmailVerified: true}
+	if err := h.db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.db.Delete(&user) })
+	claims := &auth.Claims{UserId: user.UserId, Role: auth.RoleUser}
+
+	update := func(body string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/users", strings.NewReader(body))
+		req = req.WithContext(auth.WithUser(req.Context(), claims))
+		recorder := httptest.NewRecorder()
+		h.mux.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+		}
+	}
+
+	// Renaming keeps the sessions
+	update(`{"userId": "` + user.UserId + `", "name": "Ada L."}`)
+	if revokedAt, err := h.tokenStore.SessionsRevokedAt(context.Background(), user.UserId); err != nil || !revokedAt.IsZero() {
+		t.Errorf("sessions revoked at %v, %v after a rename", revokedAt, err)
+	}
+
+	update(`{"userId": "` + user.UserId + `", "email": "` + uuid.NewString() + `@example.com"}`)
+	if revokedAt, err := h.tokenStore.SessionsRevokedAt(context.Background(), user.UserId); err != nil || revokedAt.IsZero() {
+		t.Errorf("sessions were not revoked after an email change: %v", err)
+	}
+}
@@ -0,0 +1,498 @@
+// Package latex parses the math-mode LaTeX the model writes between $...$
+// and $$...$$ into a small tree that document exporters turn into native
+// equations. It understands the constructs KaTeX renders most often in
+// reports: fractions, roots, scripts, big operators, delimiters, accents,
+// functions, text and matrix environments. Unknown commands are kept as
+// their name so nothing is silently lost.
+package latex
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind is the kind of a node
+type Kind int
+
+const (
+	// Run is a sequence of math characters: variables, digits and symbols
+	Run Kind = iota
+	// Plain is upright text from \text, \mathrm or \operatorname
+	Plain
+	// Group is a braced group
+	Group
+	// Fraction has Upper over Lower, without a bar for \binom
+	Fraction
+	// Root is the square root of Body, with an optional Upper index
+	Root
+	// Scripts is Body with a Lower subscript and an Upper superscript
+	Scripts
+	// Delimited is Body between Open and Close
+	Delimited
+	// Accent is Body under the accent character Text
+	Accent
+	// Function is a named function such as sin, applied to what follows
+	Function
+	// Operator is a big operator such as a sum, with Lower and Upper
+	// limits and Body as its operand
+	Operator
+	// Matrix is an environment of Rows, between Open and Close
+	Matrix
+)
+
+// Node is an element of a formula. The fields used depend on Kind.
+type Node struct {
+	Kind        Kind
+	Text        string // characters, function name, accent or operator symbol
+	Open, Close string // delimiters
+	Body        []Node
+	Lower       []Node // subscript, denominator or lower limit
+	Upper       []Node // superscript, numerator, root index or upper limit
+	Rows        [][][]Node
+	Bold        bool
+	NoBar       bool // fraction drawn without a bar, for binomials
+}
+
+// Parse parses a formula
+func Parse(tex string) []Node {
+	p := &parser{tokens: tokenize(tex)}
+	return p.sequence(stopNone)
+}
+
+// token is a command such as \frac, a single character or a group brace
+type token struct {
+	text    string
+	command bool
+	space   bool // whitespace before the token
+}
+
+func tokenize(tex string) []token {
+	var tokens []token
+	space := false
+	for i := 0; i < len(tex); {
+		r, size := utf8.DecodeRuneInString(tex[i:])
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			i += size
+			continue
+		case r == '%':
+			// Comments run to the end of the line
+			if end := strings.IndexByte(tex[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(tex)
+			}
+			continue
+		case r == '\\' && i+1 < len(tex):
+			j := i + 1
+			for j < len(tex) && isLetter(tex[j]) {
+				j++
+			}
+			if j == i+1 {
+				_, size := utf8.DecodeRuneInString(tex[j:])
+				j += size
+			}
+			tokens = append(tokens, token{text: tex[i:j], command: true, space: space})
+			i = j
+		default:
+			tokens = append(tokens, token{text: tex[i : i+size], space: space})
+			i += size
+		}
+		space = false
+	}
+	return tokens
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// stop tells a sequence where to end besides the end of input
+type stop int
+
+const (
+	stopNone    stop = iota
+	stopBrace        // }
+	stopRight        // \right
+	stopCell         // & or \\ or \end
+	stopBracket      // ] of an optional argument
+)
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (token, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+func (p *parser) ends(t token, until stop) bool {
+	switch until {
+	case stopBrace:
+		return t.text == "}"
+	case stopRight:
+		return t.text == `\right`
+	case stopCell:
+		return t.text == "&" || t.text == `\\` || t.text == `\end` || t.text == `\cr`
+	case stopBracket:
+		return t.text == "]"
+	}
+	return false
+}
+
+// sequence parses nodes until the stop token, which is left unread
+func (p *parser) sequence(until stop) []Node {
+	var nodes []Node
+	for {
+		t, ok := p.peek()
+		if !ok || p.ends(t, until) {
+			return nodes
+		}
+		if t.text == "}" {
+			// Unbalanced brace
+			p.pos++
+			continue
+		}
+		if t.text == `\over` || t.text == `\choose` {
+			p.pos++
+			lower := p.sequence(until)
+			nodes = []Node{{Kind: Fraction, Upper: nodes, Lower: lower, NoBar: t.text == `\choose`}}
+			continue
+		}
+		node, ok := p.atom()
+		if !ok {
+			continue
+		}
+		node = p.scripts(node)
+		if node.Kind == Operator {
+			node = p.operand(node)
+		}
+		nodes = appendNode(nodes, node)
+	}
+}
+
+// appendNode adds a node, joining neighbouring runs
+func appendNode(nodes []Node, node Node) []Node {
+	if n := len(nodes); n > 0 && node.Kind == Run && nodes[n-1].Kind == Run && nodes[n-1].Bold == node.Bold {
+		nodes[n-1].Text += node.Text
+		return nodes
+	}
+	return append(nodes, node)
+}
+
+// argument parses a required argument, a group or a single token
+func (p *parser) argument() []Node {
+	t, ok := p.peek()
+	if !ok {
+		return nil
+	}
+	if t.text == "{" {
+		p.pos++
+		nodes := p.sequence(stopBrace)
+		p.next()
+		return nodes
+	}
+	node, ok := p.atom()
+	if !ok {
+		return nil
+	}
+	return []Node{node}
+}
+
+// rawArgument returns the text of a braced argument without parsing it
+func (p *parser) rawArgument() string {
+	t, ok := p.peek()
+	if !ok {
+		return ""
+	}
+	if t.text != "{" {
+		p.pos++
+		return t.text
+	}
+	p.pos++
+	var builder strings.Builder
+	for depth := 1; ; {
+		t, ok := p.next()
+		if !ok {
+			break
+		}
+		if t.text == "{" {
+			depth++
+		} else if t.text == "}" {
+			if depth--; depth == 0 {
+				break
+			}
+		}
+		if t.space && builder.Len() > 0 {
+			builder.WriteByte(' ')
+		}
+		if t.command {
+			if symbol, ok := symbols[t.text[1:]]; ok {
+				builder.WriteString(symbol)
+				continue
+			}
+			if t.text == `\ ` || t.text == `\,` || t.text == `\;` {
+				builder.WriteByte(' ')
+				continue
+			}
+			builder.WriteString(strings.TrimPrefix(t.text, `\`))
+			continue
+		}
+		builder.WriteString(t.text)
+	}
+	return builder.String()
+}
+
+// optional parses an optional [argument]
+func (p *parser) optional() []Node {
+	if t, ok := p.peek(); ok && t.text == "[" {
+		p.pos++
+		nodes := p.sequence(stopBracket)
+		p.next()
+		return nodes
+	}
+	return nil
+}
+
+// scripts attaches following subscripts, superscripts and primes
+func (p *parser) scripts(base Node) Node {
+	var lower, upper []Node
+	found := false
+	for {
+		t, ok := p.peek()
+		if !ok {
+			break
+		}
+		switch t.text {
+		case "_":
+			p.pos++
+			lower = p.argument()
+		case "^":
+			p.pos++
+			upper = p.argument()
+		case "'":
+			p.pos++
+			upper = appendNode(upper, Node{Kind: Run, Text: "′"})
+		default:
+			if found {
+				return scripted(base, lower, upper)
+			}
+			return base
+		}
+		found = true
+	}
+	if found {
+		return scripted(base, lower, upper)
+	}
+	return base
+}
+
+func scripted(base Node, lower, upper []Node) Node {
+	if base.Kind == Operator {
+		base.Lower, base.Upper = lower, upper
+		return base
+	}
+	return Node{Kind: Scripts, Body: []Node{base}, Lower: lower, Upper: upper}
+}
+
+// operand gives a big operator the atom that follows it
+func (p *parser) operand(operator Node) Node {
+	t, ok := p.peek()
+	if !ok || p.ends(t, stopBrace) || p.ends(t, stopRight) || p.ends(t, stopCell) || strings.Contains("=+-<>,", t.text) {
+		return operator
+	}
+	node, ok := p.atom()
+	if !ok {
+		return operator
+	}
+	operator.Body = []Node{p.scripts(node)}
+	return operator
+}
+
+// atom parses one element without its scripts
+func (p *parser) atom() (Node, bool) {
+	t, ok := p.next()
+	if !ok {
+		return Node{}, false
+	}
+	if !t.command {
+		switch t.text {
+		case "{":
+			nodes := p.sequence(stopBrace)
+			p.next()
+			return Node{Kind: Group, Body: nodes}, true
+		case "^", "_":
+			// Scripts without a base, as in {}^{14}C
+			p.pos--
+			return Node{Kind: Group}, true
+		case "&":
+			return Node{}, false
+		case "~":
+			return Node{Kind: Run, Text: " "}, true
+		case "-":
+			return Node{Kind: Run, Text: "−"}, true
+		case "*":
+			return Node{Kind: Run, Text: "∗"}, true
+		}
+		return Node{Kind: Run, Text: t.text}, true
+	}
+
+	name := t.text[1:]
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		upper := p.argument()
+		return Node{Kind: Fraction, Upper: upper, Lower: p.argument()}, true
+	case "binom", "dbinom", "tbinom":
+		upper := p.argument()
+		return Node{Kind: Delimited, Open: "(", Close: ")", Body: []Node{{Kind: Fraction, Upper: upper, Lower: p.argument(), NoBar: true}}}, true
+	case "sqrt":
+		index := p.optional()
+		return Node{Kind: Root, Upper: index, Body: p.argument()}, true
+	case "text", "textrm", "textnormal", "mathrm", "operatorname", "textit", "mbox", "mathit":
+		return Node{Kind: Plain, Text: p.rawArgument()}, true
+	case "textbf", "mathbf", "boldsymbol", "bm":
+		return Node{Kind: Group, Body: bold(p.argument())}, true
+	case "mathbb", "mathcal", "mathfrak", "mathscr":
+		return Node{Kind: Run, Text: styled(name, p.rawArgument())}, true
+	case "left":
+		open := p.delimiter()
+		body := p.sequence(stopRight)
+		p.next()
+		return Node{Kind: Delimited, Open: open, Close: p.delimiter(), Body: body}, true
+	case "right":
+		p.delimiter()
+		return Node{}, false
+	case "begin":
+		return p.environment(p.rawArgument()), true
+	case "end":
+		p.rawArgument()
+		return Node{}, false
+	case "displaystyle", "textstyle", "scriptstyle", "limits", "nolimits", "big", "Big", "bigg", "Bigg",
+		"bigl", "bigr", "Bigl", "Bigr", "biggl", "biggr", "middle", "nonumber", "notag":
+		return Node{}, false
+	case "label", "tag":
+		p.rawArgument()
+		return Node{}, false
+	case "\\", "cr":
+		return Node{}, false
+	case "lim", "limsup", "liminf", "max", "min", "sup", "inf", "det", "gcd", "Pr", "arg":
+		return Node{Kind: Function, Text: name}, true
+	}
+
+	if accent, ok := accents[name]; ok {
+		return Node{Kind: Accent, Text: accent, Body: p.argument()}, true
+	}
+	if operator, ok := operators[name]; ok {
+		return Node{Kind: Operator, Text: operator}, true
+	}
+	if functions[name] {
+		return Node{Kind: Function, Text: name}, true
+	}
+	if symbol, ok := symbols[name]; ok {
+		return Node{Kind: Run, Text: symbol}, true
+	}
+	if space, ok := spaces[name]; ok {
+		return Node{Kind: Run, Text: space}, true
+	}
+	return Node{Kind: Plain, Text: name}, true
+}
+
+// delimiter reads the delimiter after \left or \right
+func (p *parser) delimiter() string {
+	t, ok := p.next()
+	if !ok || t.text == "." {
+		return ""
+	}
+	if t.command {
+		if symbol, ok := delimiters[t.text[1:]]; ok {
+			return symbol
+		}
+		return strings.TrimPrefix(t.text, `\`)
+	}
+	return t.text
+}
+
+// environment parses the rows and cells of \begin{name} up to \end{name}
+func (p *parser) environment(name string) Node {
+	node := Node{Kind: Matrix}
+	switch strings.TrimSuffix(name, "*") {
+	case "pmatrix":
+		node.Open, node.Close = "(", ")"
+	case "bmatrix":
+		node.Open, node.Close = "[", "]"
+	case "Bmatrix":
+		node.Open, node.Close = "{", "}"
+	case "vmatrix":
+		node.Open, node.Close = "|", "|"
+	case "Vmatrix":
+		node.Open, node.Close = "‖", "‖"
+	case "cases":
+		node.Open = "{"
+	case "array", "tabular":
+		// Skip the column specification
+		p.rawArgument()
+	}
+
+	row := [][]Node{}
+	for {
+		cell := p.sequence(stopCell)
+		row = append(row, cell)
+		t, ok := p.next()
+		if !ok {
+			break
+		}
+		if t.text == "&" {
+			continue
+		}
+		if t.text == `\end` {
+			p.rawArgument()
+			break
+		}
+		// A line break ends the row
+		p.optional()
+		node.Rows = append(node.Rows, row)
+		row = [][]Node{}
+	}
+	if len(row) > 1 || len(row) == 1 && len(row[0]) > 0 {
+		node.Rows = append(node.Rows, row)
+	}
+	return node
+}
+
+// bold marks the runs of nodes as bold
+func bold(nodes []Node) []Node {
+	for i := range nodes {
+		nodes[i].Bold = true
+		nodes[i].Body = bold(nodes[i].Body)
+	}
+	return nodes
+}
+
+// styled maps letters to the double-struck, calligraphic or fraktur
+// alphabets where Unicode has them
+func styled(style, text string) string {
+	alphabet := alphabets[style]
+	var builder strings.Builder
+	for _, r := range text {
+		if mapped, ok := alphabet[r]; ok {
+			builder.WriteString(mapped)
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
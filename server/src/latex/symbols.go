@@ -0,0 +1,94 @@
+package latex
+
+// symbols maps commands to the characters they stand for
+var symbols = map[string]string{
+	// Greek letters
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ",
+	"varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ",
+	"varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+
+	// Binary operators and relations
+	"times": "×", "cdot": "⋅", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "otimes": "⊗", "cup": "∪", "cap": "∩",
+	"setminus": "∖", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨", "neg": "¬", "lnot": "¬",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "approx": "≈",
+	"equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫",
+	"lt": "<", "gt": ">", "leqslant": "⩽", "geqslant": "⩾", "doteq": "≐",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "supset": "⊃", "subseteq": "⊆",
+	"supseteq": "⊇", "perp": "⊥", "parallel": "∥", "mid": "∣", "nmid": "∤",
+
+	// Arrows
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺",
+	"mapsto": "↦", "uparrow": "↑", "downarrow": "↓", "longrightarrow": "⟶", "longleftarrow": "⟵",
+	"rightleftharpoons": "⇌",
+
+	// Miscellaneous
+	"infty": "∞", "partial": "∂", "nabla": "∇", "forall": "∀", "exists": "∃", "nexists": "∄",
+	"emptyset": "∅", "varnothing": "∅", "angle": "∠", "triangle": "△", "degree": "°",
+	"prime": "′", "hbar": "ℏ", "ell": "ℓ", "Re": "ℜ", "Im": "ℑ", "aleph": "ℵ",
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱", "therefore": "∴",
+	"because": "∵", "checkmark": "✓", "dagger": "†",
+	"%": "%", "$": "$", "#": "#", "&": "&", "_": "_", "{": "{", "}": "}", "|": "‖",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"vert": "|", "Vert": "‖", "backslash": "∖",
+}
+
+// delimiters maps the commands allowed after \left and \right
+var delimiters = map[string]string{
+	"{": "{", "}": "}", "lbrace": "{", "rbrace": "}", "langle": "⟨", "rangle": "⟩",
+	"lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉", "|": "‖", "vert": "|",
+	"Vert": "‖", "lvert": "|", "rvert": "|", "lVert": "‖", "rVert": "‖", "lbrack": "[", "rbrack": "]",
+}
+
+// operators are the big operators that take limits
+var operators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "int": "∫", "iint": "∬", "iiint": "∭",
+	"oint": "∮", "bigcup": "⋃", "bigcap": "⋂", "bigoplus": "⨁", "bigotimes": "⨂",
+	"bigvee": "⋁", "bigwedge": "⋀",
+}
+
+// functions are set upright and followed by their argument
+var functions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true, "tanh": true,
+	"coth": true, "log": true, "ln": true, "lg": true, "exp": true, "ker": true, "dim": true,
+	"deg": true, "hom": true, "mod": true, "bmod": true,
+}
+
+// accents maps accent commands to combining characters
+var accents = map[string]string{
+	"hat": "̂", "widehat": "̂", "bar": "̅", "overline": "̅", "vec": "⃗", "overrightarrow": "⃗",
+	"dot": "̇", "ddot": "̈", "tilde": "̃", "widetilde": "̃", "check": "̌", "acute": "́",
+	"grave": "̀", "breve": "̆", "underline": "̲",
+}
+
+// spaces maps spacing commands to spaces of roughly the same width
+var spaces = map[string]string{
+	",": " ", ":": " ", ";": " ", " ": " ", "quad": " ", "qquad": "  ",
+	"enspace": " ", "thinspace": " ", "!": "",
+}
+
+// alphabets maps letters to their styled Unicode forms
+var alphabets = map[string]map[rune]string{
+	"mathbb": {
+		'A': "𝔸", 'B': "𝔹", 'C': "ℂ", 'D': "𝔻", 'E': "𝔼", 'F': "𝔽", 'G': "𝔾", 'H': "ℍ",
+		'I': "𝕀", 'J': "𝕁", 'K': "𝕂", 'L': "𝕃", 'M': "𝕄", 'N': "ℕ", 'O': "𝕆", 'P': "ℙ",
+		'Q': "ℚ", 'R': "ℝ", 'S': "𝕊", 'T': "𝕋", 'U': "𝕌", 'V': "𝕍", 'W': "𝕎", 'X': "𝕏",
+		'Y': "𝕐", 'Z': "ℤ", '1': "𝟙",
+	},
+	"mathcal": {
+		'A': "𝒜", 'B': "ℬ", 'C': "𝒞", 'D': "𝒟", 'E': "ℰ", 'F': "ℱ", 'G': "𝒢", 'H': "ℋ",
+		'I': "ℐ", 'J': "𝒥", 'K': "𝒦", 'L': "ℒ", 'M': "ℳ", 'N': "𝒩", 'O': "𝒪", 'P': "𝒫",
+		'Q': "𝒬", 'R': "ℛ", 'S': "𝒮", 'T': "𝒯", 'U': "𝒰", 'V': "𝒱", 'W': "𝒲", 'X': "𝒳",
+		'Y': "𝒴", 'Z': "𝒵",
+	},
+}
+
+func init() {
+	alphabets["mathscr"] = alphabets["mathcal"]
+}
@@ -0,0 +1,121 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/integems/report-agent/src/llm"
+)
+
+// Provider is a deterministic in-memory llm.Provider for tests and local
+// development. It replies with Reply when set, and otherwise echoes the text
+// of the request.
+type Provider struct {
+	Reply string
+
+	mu       sync.Mutex
+	files    map[string]*llm.File
+	Requests []llm.ChatRequest
+}
+
+// New returns an empty fake provider
+func New() *Provider {
+	return &Provider{files: map[string]*llm.File{}}
+}
+
+func (p *Provider) Name() string {
+	return "fake"
+}
+
+func (p *Provider) Chat(ctx context.Context, request llm.ChatRequest) (*llm.ChatResponse, error) {
+	p.mu.Lock()
+	p.Requests = append(p.Requests, request)
+	p.mu.Unlock()
+
+	var texts []string
+	for _, part := range request.Parts {
+		if !part.IsFile() {
+			texts = append(texts, part.Text)
+		}
+	}
+
+	reply := p.Reply
+	if reply == "" {
+		reply = "echo: " + strings.Join(texts, " ")
+	}
+	// Count words as tokens
+	usage := llm.Usage{
+		PromptTokens:   len(strings.Fields(strings.Join(texts, " "))),
+		ResponseTokens: len(strings.Fields(reply)),
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.ResponseTokens
+	return &llm.ChatResponse{Role: llm.RoleModel, Text: reply, FinishReason: llm.FinishReasonStop, Usage: usage}, nil
+}
+
+func (p *Provider) ChatStream(ctx context.Context, request llm.ChatRequest, onDelta func(text string) error) (*llm.ChatResponse, error) {
+	resp, err := p.Chat(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range strings.SplitAfter(resp.Text, " ") {
+		if err := onDelta(word); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (p *Provider) UploadFile(ctx context.Context, name string, r io.Reader, mimeType string) (*llm.File, error) {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(name, "/") {
+		name = "files/" + name
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.files[name]; ok {
+		return nil, fmt.Errorf("file %s already exists", name)
+	}
+	file := &llm.File{
+		Name:      name,
+		URI:       "fake://" + name,
+		MIMEType:  mimeType,
+		State:     llm.FileStateActive,
+		ExpiresAt: time.Now().Add(48 * time.Hour),
+	}
+	p.files[name] = file
+	return file, nil
+}
+
+func (p *Provider) GetFile(ctx context.Context, name string) (*llm.File, error) {
+	if !strings.Contains(name, "/") {
+		name = "files/" + name
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file, ok := p.files[name]
+	if !ok {
+		return nil, llm.ErrFileNotFound
+	}
+	copied := *file
+	return &copied, nil
+}
+
+func (p *Provider) DeleteFile(ctx context.Context, name string) error {
+	if !strings.Contains(name, "/") {
+		name = "files/" + name
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.files[name]; !ok {
+		return llm.ErrFileNotFound
+	}
+	delete(p.files, name)
+	return nil
+}
@@ -0,0 +1,264 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/integems/report-agent/src/llm"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const DefaultModel = "gemini-2.0-flash"
+
+// Provider adapts the Gemini API to llm.Provider
+type Provider struct {
+	client *genai.Client
+	model  string
+}
+
+// New creates a Gemini provider. An empty model selects DefaultModel.
+func New(ctx context.Context, apiKey, model string) (*Provider, error) {
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize GenAI client: %w", err)
+	}
+	if model == "" {
+		model = DefaultModel
+	}
+	return &Provider{client: client, model: model}, nil
+}
+
+func (p *Provider) Name() string {
+	return "gemini"
+}
+
+func (p *Provider) Chat(ctx context.Context, request llm.ChatRequest) (*llm.ChatResponse, error) {
+	modelName := request.Model
+	if modelName == "" {
+		modelName = p.model
+	}
+
+	session := p.client.GenerativeModel(modelName).StartChat()
+	session.History = toContents(request.History)
+
+	resp, err := session.SendMessage(ctx, toParts(request.Parts)...)
+	if err != nil {
+		return blockedResponse(err, "")
+	}
+	return fromResponse(resp)
+}
+
+func (p *Provider) ChatStream(ctx context.Context, request llm.ChatRequest, onDelta func(text string) error) (*llm.ChatResponse, error) {
+	modelName := request.Model
+	if modelName == "" {
+		modelName = p.model
+	}
+
+	session := p.client.GenerativeModel(modelName).StartChat()
+	session.History = toContents(request.History)
+
+	var builder strings.Builder
+	iter := session.SendMessageStream(ctx, toParts(request.Parts)...)
+	for {
+		resp, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return blockedResponse(err, builder.String())
+		}
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			continue
+		}
+		for _, part := range resp.Candidates[0].Content.Parts {
+			text, ok := part.(genai.Text)
+			if !ok || text == "" {
+				continue
+			}
+			builder.WriteString(string(text))
+			if err := onDelta(string(text)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	merged := iter.MergedResponse()
+	if merged == nil {
+		return nil, errors.New("no response from AI")
+	}
+	return fromResponse(merged)
+}
+
+func (p *Provider) UploadFile(ctx context.Context, name string, r io.Reader, mimeType string) (*llm.File, error) {
+	var opts *genai.UploadFileOptions
+	if mimeType != "" {
+		opts = &genai.UploadFileOptions{MIMEType: mimeType}
+	}
+	file, err := p.client.UploadFile(ctx, name, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return fromFile(file), nil
+}
+
+func (p *Provider) GetFile(ctx context.Context, name string) (*llm.File, error) {
+	file, err := p.client.GetFile(ctx, name)
+	if err != nil {
+		// Gemini answers PermissionDenied for names that do not exist
+		if code := status.Code(err); code == codes.NotFound || code == codes.PermissionDenied {
+			return nil, fmt.Errorf("%w: %v", llm.ErrFileNotFound, err)
+		}
+		return nil, err
+	}
+	return fromFile(file), nil
+}
+
+func (p *Provider) DeleteFile(ctx context.Context, name string) error {
+	err := p.client.DeleteFile(ctx, name)
+	if code := status.Code(err); code == codes.NotFound || code == codes.PermissionDenied {
+		return fmt.Errorf("%w: %v", llm.ErrFileNotFound, err)
+	}
+	return err
+}
+
+// fromResponse assembles the text of every part of every candidate
+func fromResponse(resp *genai.GenerateContentResponse) (*llm.ChatResponse, error) {
+	response := &llm.ChatResponse{Role: llm.RoleModel}
+	if resp.UsageMetadata != nil {
+		response.Usage = llm.Usage{
+			PromptTokens:   int(resp.UsageMetadata.PromptTokenCount),
+			ResponseTokens: int(resp.UsageMetadata.CandidatesTokenCount),
+			TotalTokens:    int(resp.UsageMetadata.TotalTokenCount),
+		}
+	}
+
+	for i, candidate := range resp.Candidates {
+		text := candidateText(candidate)
+		if i > 0 {
+			if text != "" {
+				response.Alternatives = append(response.Alternatives, text)
+			}
+			continue
+		}
+		response.Text = text
+		response.FinishReason = finishReason(candidate.FinishReason)
+		response.SafetyRatings = safetyRatings(candidate.SafetyRatings)
+		if candidate.Content != nil && candidate.Content.Role != "" {
+			response.Role = candidate.Content.Role
+		}
+	}
+
+	if response.Text == "" && response.FinishReason != llm.FinishReasonSafety {
+		return nil, errors.New("no response from AI")
+	}
+	return response, nil
+}
+
+// blockedResponse turns a candidate blocked by the safety filters into a
+// response so the finish reason and ratings reach the caller. Other errors,
+// including a blocked prompt, are returned unchanged.
+func blockedResponse(err error, text string) (*llm.ChatResponse, error) {
+	var blocked *genai.BlockedError
+	if !errors.As(err, &blocked) || blocked.Candidate == nil {
+		return nil, err
+	}
+	if candidateText(blocked.Candidate) != "" {
+		text = candidateText(blocked.Candidate)
+	}
+	return &llm.ChatResponse{
+		Role:          llm.RoleModel,
+		Text:          text,
+		FinishReason:  llm.FinishReasonSafety,
+		SafetyRatings: safetyRatings(blocked.Candidate.SafetyRatings),
+	}, nil
+}
+
+// candidateText joins the text parts of a candidate. Other parts such as
+// function calls or inline data are not supported by the chat and are logged.
+func candidateText(candidate *genai.Candidate) string {
+	if candidate.Content == nil {
+		return ""
+	}
+	var builder strings.Builder
+	for _, part := range candidate.Content.Parts {
+		text, ok := part.(genai.Text)
+		if !ok {
+			log.Printf("Skipping unsupported response part %T", part)
+			continue
+		}
+		builder.WriteString(string(text))
+	}
+	return builder.String()
+}
+
+func finishReason(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonUnspecified:
+		return ""
+	case genai.FinishReasonStop:
+		return llm.FinishReasonStop
+	case genai.FinishReasonMaxTokens:
+		return llm.FinishReasonMaxTokens
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation:
+		return llm.FinishReasonSafety
+	default:
+		return llm.FinishReasonOther
+	}
+}
+
+func safetyRatings(ratings []*genai.SafetyRating) []llm.SafetyRating {
+	converted := make([]llm.SafetyRating, 0, len(ratings))
+	for _, rating := range ratings {
+		converted = append(converted, llm.SafetyRating{
+			Category:    rating.Category.String(),
+			Probability: rating.Probability.String(),
+			Blocked:     rating.Blocked,
+		})
+	}
+	return converted
+}
+
+func toParts(parts []llm.Part) []genai.Part {
+	converted := make([]genai.Part, 0, len(parts))
+	for _, part := range parts {
+		if part.IsFile() {
+			converted = append(converted, genai.FileData{URI: part.FileURI, MIMEType: part.MIMEType})
+		} else {
+			converted = append(converted, genai.Text(part.Text))
+		}
+	}
+	return converted
+}
+
+func toContents(messages []llm.Message) []*genai.Content {
+	contents := make([]*genai.Content, 0, len(messages))
+	for _, message := range messages {
+		contents = append(contents, &genai.Content{Role: message.Role, Parts: toParts(message.Parts)})
+	}
+	return contents
+}
+
+func fromFile(file *genai.File) *llm.File {
+	state := llm.FileStateFailed
+	switch file.State {
+	case genai.FileStateProcessing:
+		state = llm.FileStateProcessing
+	case genai.FileStateActive:
+		state = llm.FileStateActive
+	}
+	return &llm.File{
+		Name:      file.Name,
+		URI:       file.URI,
+		MIMEType:  file.MIMEType,
+		State:     state,
+		ExpiresAt: file.ExpirationTime,
+	}
+}
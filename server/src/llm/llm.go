@@ -0,0 +1,201 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// Roles used in chat history
+const (
+	RoleUser  = "user"
+	RoleModel = "model"
+)
+
+// Part is a piece of a chat message: either text or a reference to an uploaded file
+type Part struct {
+	Text     string `json:"text,omitempty"`
+	FileURI  string `json:"fileUri,omitempty"`
+	MIMEType string `json:"mimeType,omitempty"`
+}
+
+// Text returns a text part
+func Text(text string) Part {
+	return Part{Text: text}
+}
+
+// FileData returns a part referencing a file uploaded to the provider
+func FileData(uri string) Part {
+	return Part{FileURI: uri}
+}
+
+// IsFile reports whether the part references an uploaded file
+func (p Part) IsFile() bool {
+	return p.FileURI != ""
+}
+
+// Message is one turn of a conversation
+type Message struct {
+	Role  string `json:"role"`
+	Parts []Part `json:"parts"`
+}
+
+// ChatRequest sends Parts as a new user turn after History
+type ChatRequest struct {
+	Model   string // Optional, the provider default is used when empty
+	History []Message
+	Parts   []Part
+}
+
+// Reasons the model stopped generating
+const (
+	FinishReasonStop      = "STOP"
+	FinishReasonMaxTokens = "MAX_TOKENS"
+	FinishReasonSafety    = "SAFETY"
+	FinishReasonOther     = "OTHER"
+)
+
+// SafetyRating is the probability that a response falls in a harm category
+type SafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+// Usage counts the tokens used by a request
+type Usage struct {
+	PromptTokens   int `json:"promptTokens"`
+	ResponseTokens int `json:"responseTokens"`
+	TotalTokens    int `json:"totalTokens"`
+}
+
+// Add accumulates the tokens of another request
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.ResponseTokens += other.ResponseTokens
+	u.TotalTokens += other.TotalTokens
+}
+
+// ChatResponse is the reply of the model. Text is assembled from every text
+// part of the first candidate; the text of any other candidate is kept in
+// Alternatives.
+type ChatResponse struct {
+	Role          string         `json:"role"`
+	Text          string         `json:"text"`
+	Alternatives  []string       `json:"alternatives,omitempty"`
+	FinishReason  string         `json:"finishReason,omitempty"`
+	SafetyRatings []SafetyRating `json:"safetyRatings,omitempty"`
+	Usage         Usage          `json:"usage"`
+	// Continuations is the number of follow-up requests made because the
+	// reply hit the output token limit
+	Continuations int `json:"continuations,omitempty"`
+}
+
+// Truncated reports whether the reply was cut off by the output token limit
+func (r *ChatResponse) Truncated() bool {
+	return r.FinishReason == FinishReasonMaxTokens
+}
+
+type FileState string
+
+const (
+	FileStateProcessing FileState = "processing"
+	FileStateActive     FileState = "active"
+	FileStateFailed     FileState = "failed"
+)
+
+// File is a file stored by the provider so it can be referenced in chats
+type File struct {
+	Name      string
+	URI       string
+	MIMEType  string
+	State     FileState
+	ExpiresAt time.Time
+}
+
+var ErrFileNotFound = errors.New("file not found")
+
+// Provider is a chat model backend such as Gemini
+type Provider interface {
+	// Name identifies the provider, e.g. "gemini"
+	Name() string
+	// Chat sends a message with the given history and returns the reply
+	Chat(ctx context.Context, request ChatRequest) (*ChatResponse, error)
+	// ChatStream works like Chat but calls onDelta with each piece of text as
+	// it is generated. The complete reply is returned once the stream ends.
+	ChatStream(ctx context.Context, request ChatRequest, onDelta func(text string) error) (*ChatResponse, error)
+	// UploadFile stores a file under name so it can be referenced in chats
+	UploadFile(ctx context.Context, name string, r io.Reader, mimeType string) (*File, error)
+	// GetFile returns a previously uploaded file, or ErrFileNotFound
+	GetFile(ctx context.Context, name string) (*File, error)
+	// DeleteFile removes an uploaded file
+	DeleteFile(ctx context.Context, name string) error
+}
+
+// ContinuePrompt asks the model to resume a reply cut off by the token limit
+const ContinuePrompt = "Continue exactly where your previous answer stopped. Do not repeat anything you already wrote and do not add an introduction."
+
+// Complete sends the request and, while the reply is cut off by the output
+// token limit, asks the model to continue up to maxContinuations times. The
+// pieces are joined into one response. When onDelta is set the replies are
+// streamed through it.
+func Complete(ctx context.Context, provider Provider, request ChatRequest, maxContinuations int, onDelta func(text string) error) (*ChatResponse, error) {
+	send := func(request ChatRequest) (*ChatResponse, error) {
+		if onDelta == nil {
+			return provider.Chat(ctx, request)
+		}
+		return provider.ChatStream(ctx, request, onDelta)
+	}
+
+	resp, err := send(request)
+	if err != nil {
+		return nil, err
+	}
+
+	for resp.Truncated() && resp.Continuations < maxContinuations {
+		history := make([]Message, 0, len(request.History)+2)
+		history = append(history, request.History...)
+		history = append(history,
+			Message{Role: RoleUser, Parts: request.Parts},
+			Message{Role: RoleModel, Parts: []Part{Text(resp.Text)}},
+		)
+
+		next, err := send(ChatRequest{Model: request.Model, History: history, Parts: []Part{Text(ContinuePrompt)}})
+		if err != nil {
+			// Keep the truncated reply rather than failing the whole request
+			log.Printf("Failed to continue truncated response: %v", err)
+			break
+		}
+		resp.Text += next.Text
+		resp.FinishReason = next.FinishReason
+		resp.SafetyRatings = next.SafetyRatings
+		resp.Usage.Add(next.Usage)
+		resp.Continuations++
+	}
+	return resp, nil
+}
+
+// WaitForFile polls the provider until the file has finished processing
+func WaitForFile(ctx context.Context, provider Provider, file *File, interval time.Duration) (*File, error) {
+	var err error
+	for file.State == FileStateProcessing {
+		log.Printf("Processing file: %s", file.Name)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		file, err = provider.GetFile(ctx, file.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error checking file state: %w", err)
+		}
+	}
+
+	if file.State != FileStateActive {
+		return nil, fmt.Errorf("file %s not active after processing (state: %s)", file.Name, file.State)
+	}
+	return file, nil
+}
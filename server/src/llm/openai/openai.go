@@ -0,0 +1,372 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/storage"
+	"github.com/integems/report-agent/src/textextract"
+)
+
+// fileScheme prefixes the URI of files, followed by their key in the store
+const fileScheme = "text://"
+
+// Provider talks to any OpenAI-compatible /v1/chat/completions endpoint such
+// as Ollama, vLLM or the llama.cpp server. These backends have no file API, so
+// uploaded files are kept in the blob store, where they share the key of the
+// blob they were uploaded from, and converted to text in memory whenever a
+// chat references them.
+type Provider struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	store      storage.Store
+	httpClient *http.Client
+}
+
+// New creates an OpenAI-compatible provider. baseURL is the server root,
+// e.g. http://127.0.0.1:11434 for Ollama.
+func New(baseURL, apiKey, model string, store storage.Store) (*Provider, error) {
+	if model == "" {
+		return nil, errors.New("a model name is required")
+	}
+	return &Provider{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		model:      model,
+		store:      store,
+		httpClient: &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return "openai"
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatCompletionRequest struct {
+	Model         string         `json:"model"`
+	Messages      []chatMessage  `json:"messages"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+type completionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message      chatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *completionUsage `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type chatCompletionChunk struct {
+	Choices []struct {
+		Index        int         `json:"index"`
+		Delta        chatMessage `json:"delta"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *completionUsage `json:"usage,omitempty"`
+}
+
+// buildRequest converts a chat request to the OpenAI message format
+func (p *Provider) buildRequest(ctx context.Context, request llm.ChatRequest, stream bool) (*chatCompletionRequest, error) {
+	modelName := request.Model
+	if modelName == "" {
+		modelName = p.model
+	}
+
+	messages := make([]chatMessage, 0, len(request.History)+1)
+	for _, message := range request.History {
+		content, err := p.messageText(ctx, message.Parts)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, chatMessage{Role: toRole(message.Role), Content: content})
+	}
+	content, err := p.messageText(ctx, request.Parts)
+	if err != nil {
+		return nil, err
+	}
+	messages = append(messages, chatMessage{Role: "user", Content: content})
+
+	completionRequest := &chatCompletionRequest{Model: modelName, Messages: messages, Stream: stream}
+	if stream {
+		// Ask for a final chunk with the token usage
+		completionRequest.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	return completionRequest, nil
+}
+
+func (p *Provider) Chat(ctx context.Context, request llm.ChatRequest) (*llm.ChatResponse, error) {
+	body, err := p.buildRequest(ctx, request, false)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.post(ctx, "/v1/chat/completions", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response chatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return nil, errors.New("no response from AI")
+	}
+
+	chatResponse := &llm.ChatResponse{
+		Role:         llm.RoleModel,
+		Text:         response.Choices[0].Message.Content,
+		FinishReason: finishReason(response.Choices[0].FinishReason),
+		Usage:        toUsage(response.Usage),
+	}
+	for _, choice := range response.Choices[1:] {
+		if choice.Message.Content != "" {
+			chatResponse.Alternatives = append(chatResponse.Alternatives, choice.Message.Content)
+		}
+	}
+	return chatResponse, nil
+}
+
+func (p *Provider) ChatStream(ctx context.Context, request llm.ChatRequest, onDelta func(text string) error) (*llm.ChatResponse, error) {
+	body, err := p.buildRequest(ctx, request, true)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.post(ctx, "/v1/chat/completions", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// The stream is a sequence of "data: {chunk}" lines ending with "data: [DONE]"
+	var builder strings.Builder
+	chatResponse := &llm.ChatResponse{Role: llm.RoleModel}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if chunk.Usage != nil {
+			chatResponse.Usage = toUsage(chunk.Usage)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Index != 0 {
+			continue
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			chatResponse.FinishReason = finishReason(chunk.Choices[0].FinishReason)
+		}
+		text := chunk.Choices[0].Delta.Content
+		if text == "" {
+			continue
+		}
+		builder.WriteString(text)
+		if err := onDelta(text); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	if builder.Len() == 0 && chatResponse.FinishReason != llm.FinishReasonSafety {
+		return nil, errors.New("no response from AI")
+	}
+	chatResponse.Text = builder.String()
+	return chatResponse, nil
+}
+
+// post sends a JSON request and returns the response when the status is 200.
+// The caller must close the response body.
+func (p *Provider) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", p.baseURL, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var failure chatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&failure); err == nil && failure.Error != nil {
+		return nil, fmt.Errorf("chat completion failed: %s", failure.Error.Message)
+	}
+	return nil, fmt.Errorf("chat completion failed with status %d", resp.StatusCode)
+}
+
+// messageText flattens parts into one message, inlining the text of referenced files
+func (p *Provider) messageText(ctx context.Context, parts []llm.Part) (string, error) {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if !part.IsFile() {
+			texts = append(texts, part.Text)
+			continue
+		}
+		text, err := p.fileText(ctx, strings.TrimPrefix(part.FileURI, fileScheme))
+		if err != nil {
+			return "", err
+		}
+		texts = append(texts, "Content of an attached file:\n"+text)
+	}
+	return strings.Join(texts, "\n\n"), nil
+}
+
+// fileText reads a stored file and extracts its text
+func (p *Provider) fileText(ctx context.Context, key string) (string, error) {
+	reader, err := p.store.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", key, err)
+	}
+	text, err := textextract.Extract(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract text from %s: %w", key, err)
+	}
+	return text, nil
+}
+
+// finishReason maps OpenAI finish reasons to the llm constants
+func finishReason(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "stop", "tool_calls", "function_call":
+		return llm.FinishReasonStop
+	case "length":
+		return llm.FinishReasonMaxTokens
+	case "content_filter":
+		return llm.FinishReasonSafety
+	default:
+		return llm.FinishReasonOther
+	}
+}
+
+func toUsage(usage *completionUsage) llm.Usage {
+	if usage == nil {
+		return llm.Usage{}
+	}
+	return llm.Usage{
+		PromptTokens:   usage.PromptTokens,
+		ResponseTokens: usage.CompletionTokens,
+		TotalTokens:    usage.TotalTokens,
+	}
+}
+
+func toRole(role string) string {
+	if role == llm.RoleModel {
+		return "assistant"
+	}
+	return role
+}
+
+// fileKey returns the store key of a file name
+func fileKey(name string) string {
+	return strings.TrimPrefix(name, "files/")
+}
+
+// UploadFile stores the file under its content key, so the name is ignored:
+// a file uploaded from a blob is that blob. The text is extracted once to
+// reject files it cannot be extracted from.
+func (p *Provider) UploadFile(ctx context.Context, name string, r io.Reader, mimeType string) (*llm.File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if _, err := textextract.Extract(data); err != nil {
+		return nil, fmt.Errorf("failed to extract text from %s: %w", name, err)
+	}
+
+	object, err := p.store.Put(ctx, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store file: %w", err)
+	}
+	return fileInfo(object.Key), nil
+}
+
+func (p *Provider) GetFile(ctx context.Context, name string) (*llm.File, error) {
+	object, err := p.store.Stat(ctx, fileKey(name))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, llm.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo(object.Key), nil
+}
+
+// DeleteFile leaves the content in place: it belongs to the blob it was
+// uploaded from, which is deleted once nothing references it
+func (p *Provider) DeleteFile(ctx context.Context, name string) error {
+	_, err := p.GetFile(ctx, name)
+	return err
+}
+
+// fileInfo describes a stored file; it never expires
+func fileInfo(key string) *llm.File {
+	return &llm.File{
+		Name:     "files/" + key,
+		URI:      fileScheme + key,
+		MIMEType: "text/plain",
+		State:    llm.FileStateActive,
+	}
+}
@@ -0,0 +1,268 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/storage"
+)
+
+// server is a stand-in for an OpenAI-compatible endpoint. Each request is
+// answered by the next reply.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []chatCompletionRequest
+	headers  []http.Header
+	replies  []func(w http.ResponseWriter)
+}
+
+func newServer(t *testing.T, replies ...func(w http.ResponseWriter)) *server {
+	t.Helper()
+	s := &server{replies: replies}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, req)
+			return
+		}
+		var request chatCompletionRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, request)
+		s.headers = append(s.headers, req.Header.Clone())
+		if len(s.replies) == 0 {
+			s.mu.Unlock()
+			http.Error(w, "unexpected request", http.StatusInternalServerError)
+			return
+		}
+		reply := s.replies[0]
+		s.replies = s.replies[1:]
+		s.mu.Unlock()
+		reply(w)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// completion replies with one choice per text
+func completion(finishReason string, texts ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		type choice struct {
+			Message      chatMessage `json:"message"`
+			FinishReason string      `json:"finish_reason"`
+		}
+		response := struct {
+			Choices []choice         `json:"choices"`
+			Usage   *completionUsage `json:"usage"`
+		}{Usage: &completionUsage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}}
+		for _, text := range texts {
+			response.Choices = append(response.Choices, choice{Message: chatMessage{Role: "assistant", Content: text}, FinishReason: finishReason})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func newProvider(t *testing.T, baseURL string) *Provider {
+	t.Helper()
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := New(baseURL+"/", "secret", "llama3", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestChat(t *testing.T) {
+	s := newServer(t, completion("stop", "Hello there", "Hi"))
+	provider := newProvider(t, s.URL)
+
+	resp, err := provider.Chat(context.Background(), llm.ChatRequest{
+		History: []llm.Message{
+			{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("You are helpful.")}},
+			{Role: llm.RoleModel, Parts: []llm.Part{llm.Text("Understood.")}},
+		},
+		Parts: []llm.Part{llm.Text("Say hello")},
+	})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+
+	if resp.Text != "Hello there" || resp.FinishReason != llm.FinishReasonStop {
+		t.Errorf("response = %q (%s)", resp.Text, resp.FinishReason)
+	}
+	if len(resp.Alternatives) != 1 || resp.Alternatives[0] != "Hi" {
+		t.Errorf("alternatives = %v", resp.Alternatives)
+	}
+	if resp.Usage != (llm.Usage{PromptTokens: 10, ResponseTokens: 5, TotalTokens: 15}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+
+	request := s.requests[0]
+	if request.Model != "llama3" || request.Stream {
+		t.Errorf("request model = %q, stream = %v", request.Model, request.Stream)
+	}
+	want := []chatMessage{
+		{Role: "user", Content: "You are helpful."},
+		{Role: "assistant", Content: "Understood."},
+		{Role: "user", Content: "Say hello"},
+	}
+	if fmt.Sprint(request.Messages) != fmt.Sprint(want) {
+		t.Errorf("messages = %v, want %v", request.Messages, want)
+	}
+	if got := s.headers[0].Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q", got)
+	}
+}
+
+func TestChatError(t *testing.T) {
+	s := newServer(t, func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"message": "model not found"}}`))
+	})
+	provider := newProvider(t, s.URL)
+
+	_, err := provider.Chat(context.Background(), llm.ChatRequest{Parts: []llm.Part{llm.Text("Hi")}})
+	if err == nil || !strings.Contains(err.Error(), "model not found") {
+		t.Fatalf("error = %v, want the message of the server", err)
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	s := newServer(t, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"choices": [{"index": 0, "delta": {"role": "assistant", "content": "Hel"}}]}`,
+			`{"choices": [{"index": 1, "delta": {"content": "ignored"}}]}`,
+			`{"choices": [{"index": 0, "delta": {"content": "lo"}, "finish_reason": "stop"}]}`,
+			`{"choices": [], "usage": {"prompt_tokens": 3, "completion_tokens": 2, "total_tokens": 5}}`,
+			`[DONE]`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	})
+	provider := newProvider(t, s.URL)
+
+	var deltas []string
+	resp, err := provider.ChatStream(context.Background(), llm.ChatRequest{Parts: []llm.Part{llm.Text("Hi")}}, func(text string) error {
+		deltas = append(deltas, text)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+
+	if resp.Text != "Hello" || strings.Join(deltas, "|") != "Hel|lo" {
+		t.Errorf("text = %q, deltas = %v", resp.Text, deltas)
+	}
+	if resp.FinishReason != llm.FinishReasonStop || resp.Usage.TotalTokens != 5 {
+		t.Errorf("finish reason = %s, usage = %+v", resp.FinishReason, resp.Usage)
+	}
+	request := s.requests[0]
+	if !request.Stream || request.StreamOptions == nil || !request.StreamOptions.IncludeUsage {
+		t.Errorf("request does not stream with usage: %+v", request)
+	}
+}
+
+func TestCompleteContinuesTruncatedReplies(t *testing.T) {
+	s := newServer(t, completion("length", "The first half"), completion("stop", " and the rest."))
+	provider := newProvider(t, s.URL)
+
+	resp, err := llm.Complete(context.Background(), provider, llm.ChatRequest{Parts: []llm.Part{llm.Text("Write a report")}}, 2, nil)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	if resp.Text != "The first half and the rest." || resp.Continuations != 1 {
+		t.Errorf("text = %q after %d continuations", resp.Text, resp.Continuations)
+	}
+	if resp.FinishReason != llm.FinishReasonStop || resp.Usage.TotalTokens != 30 {
+		t.Errorf("finish reason = %s, usage = %+v", resp.FinishReason, resp.Usage)
+	}
+
+	if len(s.requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(s.requests))
+	}
+	want := []chatMessage{
+		{Role: "user", Content: "Write a report"},
+		{Role: "assistant", Content: "The first half"},
+		{Role: "user", Content: llm.ContinuePrompt},
+	}
+	if fmt.Sprint(s.requests[1].Messages) != fmt.Sprint(want) {
+		t.Errorf("continuation messages = %v, want %v", s.requests[1].Messages, want)
+	}
+}
+
+func TestUploadedFilesAreInlined(t *testing.T) {
+	s := newServer(t, completion("stop", "It is about budgets."))
+	provider := newProvider(t, s.URL)
+	ctx := context.Background()
+
+	// The file is the stored content, under the key of its blob
+	blob, err := provider.store.Put(ctx, strings.NewReader("Quarterly budget figures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := provider.UploadFile(ctx, "report", strings.NewReader("Quarterly budget figures"), "text/plain")
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if file.Name != "files/"+blob.Key || file.State != llm.FileStateActive || !file.ExpiresAt.IsZero() {
+		t.Errorf("file = %+v", file)
+	}
+
+	got, err := provider.GetFile(ctx, file.Name)
+	if err != nil || got.URI != file.URI {
+		t.Fatalf("GetFile = %+v, %v", got, err)
+	}
+	if _, err := provider.GetFile(ctx, "files/report"); !errors.Is(err, llm.ErrFileNotFound) {
+		t.Errorf("GetFile of an unknown name = %v, want ErrFileNotFound", err)
+	}
+
+	_, err = provider.Chat(ctx, llm.ChatRequest{Parts: []llm.Part{llm.FileData(file.URI), llm.Text("What is it about?")}})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	content := s.requests[0].Messages[0].Content
+	if !strings.Contains(content, "Quarterly budget figures") || !strings.HasSuffix(content, "What is it about?") {
+		t.Errorf("file was not inlined: %q", content)
+	}
+
+	// Deleting the upload keeps the blob
+	if err := provider.DeleteFile(ctx, file.Name); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if _, err := provider.store.Stat(ctx, blob.Key); err != nil {
+		t.Errorf("blob removed with its upload: %v", err)
+	}
+
+	// Once the blob is deleted, the file is gone
+	if err := provider.store.Delete(ctx, blob.Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.GetFile(ctx, file.Name); !errors.Is(err, llm.ErrFileNotFound) {
+		t.Errorf("GetFile after the blob was deleted = %v, want ErrFileNotFound", err)
+	}
+	if err := provider.DeleteFile(ctx, file.Name); !errors.Is(err, llm.ErrFileNotFound) {
+		t.Errorf("DeleteFile after the blob was deleted = %v, want ErrFileNotFound", err)
+	}
+	if _, err := provider.Chat(ctx, llm.ChatRequest{Parts: []llm.Part{llm.FileData(file.URI)}}); err == nil {
+		t.Error("Chat with a deleted file succeeded")
+	}
+}
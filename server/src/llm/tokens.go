@@ -0,0 +1,35 @@
+package llm
+
+import "unicode/utf8"
+
+// Token counts are estimated locally to budget the context of a request
+// without asking the provider: about four characters per token of text, and
+// a fixed amount for an attached file.
+const (
+	charsPerToken = 4
+	FileTokens    = 1000
+)
+
+// EstimateTokens estimates the tokens of a text
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// EstimatePartTokens estimates the tokens of a part of a message
+func EstimatePartTokens(part Part) int {
+	if part.IsFile() {
+		return FileTokens
+	}
+	return EstimateTokens(part.Text)
+}
+
+// EstimateHistoryTokens estimates the tokens of chat history
+func EstimateHistoryTokens(history []Message) int {
+	total := 0
+	for _, message := range history {
+		for _, part := range message.Parts {
+			total += EstimatePartTokens(part)
+		}
+	}
+	return total
+}
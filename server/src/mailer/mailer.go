@@ -0,0 +1,99 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/integems/report-agent/config"
+	"github.com/integems/report-agent/src/auth"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails such as password resets
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewMailer returns an SMTP mailer when SMTP_HOST is set. Outside production
+// it falls back to a mailer that only logs messages; in production SMTP_HOST
+// is required, since the log would then hold reset and verification tokens.
+func NewMailer() (Mailer, error) {
+	host := config.GetEnv("SMTP_HOST", "")
+	if host == "" {
+		if auth.IsProduction() {
+			return nil, errors.New("SMTP_HOST must be set in production")
+		}
+		log.Println("SMTP_HOST is not set, emails will be logged instead of sent")
+		return &LogMailer{}, nil
+	}
+	return &SMTPMailer{
+		Host:     host,
+		Port:     config.GetEnv("SMTP_PORT", "1025"),
+		Username: config.GetEnv("SMTP_USERNAME", ""),
+		Password: config.GetEnv("SMTP_PASSWORD", ""),
+		From:     config.GetEnv("SMTP_FROM", "no-reply@integems.com"),
+	}, nil
+}
+
+// SMTPMailer sends emails through an SMTP server. Authentication is skipped
+// when no username is configured, which suits local catch-all servers.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	headers := []string{
+		"From: " + m.From,
+		"To: " + message.To,
+		"Subject: " + message.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	body := strings.Join(headers, "\r\n") + "\r\n\r\n" + message.Body
+
+	addr := net.JoinHostPort(m.Host, m.Port)
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, m.From, []string{message.To}, []byte(body))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to send email: %w", ctx.Err())
+	}
+}
+
+// LogMailer writes emails to the server log, for development only
+type LogMailer struct{}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	log.Printf("Email to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
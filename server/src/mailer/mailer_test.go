@@ -0,0 +1,149 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// catchAll is a minimal SMTP server that records the messages it receives
+type catchAll struct {
+	listener net.Listener
+	messages chan string
+	rcpts    chan string
+}
+
+func newCatchAll(t *testing.T) *catchAll {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &catchAll{listener: listener, messages: make(chan string, 1), rcpts: make(chan string, 1)}
+	go server.serve()
+	return server
+}
+
+func (s *catchAll) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost catch-all")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.rcpts <- strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.messages <- data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := newCatchAll(t)
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	mailer := &SMTPMailer{Host: host, Port: port, From: "no-reply@integems.com"}
+
+	err := mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Reset your password", Body: "Open the link."})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if rcpt := <-server.rcpts; rcpt != "user@example.com" {
+		t.Errorf("recipient = %q, want user@example.com", rcpt)
+	}
+	data := <-server.messages
+	for _, want := range []string{"From: no-reply@integems.com", "To: user@example.com", "Subject: Reset your password", "Open the link."} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPMailerSendCancelled(t *testing.T) {
+	// A server that never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mailer := &SMTPMailer{Host: host, Port: port, From: "no-reply@integems.com"}
+	if err := mailer.Send(ctx, Message{To: "user@example.com"}); err == nil {
+		t.Fatal("Send succeeded with a cancelled context")
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("SMTP_HOST", "")
+	if mailer, err := NewMailer(); err != nil {
+		t.Fatalf("NewMailer: %v", err)
+	} else if _, ok := mailer.(*LogMailer); !ok {
+		t.Error("NewMailer without SMTP_HOST should log emails")
+	}
+
+	t.Setenv("SMTP_HOST", "mail.example.com")
+	t.Setenv("SMTP_PORT", "2525")
+	mailer, err := NewMailer()
+	if err != nil {
+		t.Fatalf("NewMailer: %v", err)
+	}
+	smtpMailer, ok := mailer.(*SMTPMailer)
+	if !ok {
+		t.Fatal("NewMailer with SMTP_HOST should send through SMTP")
+	}
+	if smtpMailer.Host != "mail.example.com" || smtpMailer.Port != "2525" {
+		t.Errorf("mailer = %+v", smtpMailer)
+	}
+}
+
+func TestNewMailerProduction(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("SMTP_HOST", "")
+	if mailer, err := NewMailer(); err == nil {
+		t.Errorf("NewMailer in production without SMTP_HOST = %T, want an error", mailer)
+	}
+
+	t.Setenv("SMTP_HOST", "mail.example.com")
+	if _, err := NewMailer(); err != nil {
+		t.Errorf("NewMailer in production with SMTP_HOST: %v", err)
+	}
+}
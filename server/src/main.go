@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/integems/report-agent/config"
+	"github.com/integems/report-agent/src/auth"
 	"github.com/integems/report-agent/src/database"
 	"github.com/integems/report-agent/src/handlers"
 )
@@ -17,7 +20,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow all origins
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		// Handle preflight requests
@@ -31,6 +34,14 @@ func corsMiddleware(next http.Handler) http.Handler {
 }
 
 func main() {
+	importMessages := flag.Bool("import-messages", false, "Copy the chat sessions kept in Redis lists into Postgres, then exit")
+	flag.Parse()
+
+	// Refuse to sign tokens with a weak secret
+	if err := auth.ValidateSecret(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// Connect to a database
 	db := database.NewDatabaseConnection()
 
@@ -39,6 +50,16 @@ func main() {
 		log.Fatalf("Failed to migrate table: %v", err)
 	}
 
+	// One-off migration of the sessions stored before conversations existed
+	if *importMessages {
+		result, err := database.ImportRedisMessages(context.Background(), db, database.NewRedisConnection())
+		if err != nil {
+			log.Fatalf("Failed to import messages: %v", err)
+		}
+		log.Printf("Imported %d sessions (%d messages), skipped %d, failed %d", result.Imported, result.Messages, result.Skipped, result.Failed)
+		return
+	}
+
 	// Mux server
 	mux := http.NewServeMux()
 
@@ -46,8 +67,11 @@ func main() {
 	handler := handlers.NewHandler(mux, db)
 	handler.RegisterHandlers()
 
+	// Verify access tokens on protected routes
+	authenticatedHandler := handler.Authenticate(mux)
+
 	// Wrap mux with CORS middleware
-	corsEnabledHandler := corsMiddleware(mux)
+	corsEnabledHandler := corsMiddleware(authenticatedHandler)
 
 	// Server
 	server := http.Server{
@@ -0,0 +1,346 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// inlineParser splits text into inlines while tracking the enclosing style
+// and link
+type inlineParser struct {
+	inlines []Inline
+	text    strings.Builder
+	style   Style
+	url     string
+}
+
+// ParseInlines parses the inline content of a paragraph, heading or cell
+func ParseInlines(text string) []Inline {
+	p := &inlineParser{}
+	p.parse(strings.TrimSpace(text))
+	p.flush()
+	return merge(p.inlines)
+}
+
+func (p *inlineParser) flush() {
+	if p.text.Len() == 0 {
+		return
+	}
+	p.inlines = append(p.inlines, Inline{Kind: Text, Text: html.UnescapeString(p.text.String()), Style: p.style, URL: p.url})
+	p.text.Reset()
+}
+
+func (p *inlineParser) add(inline Inline) {
+	p.flush()
+	p.inlines = append(p.inlines, inline)
+}
+
+// nested parses text with an extra style or a link target
+func (p *inlineParser) nested(text string, style Style, url string) {
+	p.flush()
+	saved, savedURL := p.style, p.url
+	p.style |= style
+	if url != "" {
+		p.url = url
+	}
+	p.parse(text)
+	p.flush()
+	p.style, p.url = saved, savedURL
+}
+
+func (p *inlineParser) parse(text string) {
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			next := text[i+1]
+			switch {
+			case next == '\n':
+				p.add(Inline{Kind: LineBreak})
+				i += 2
+			case next == '(':
+				if end := strings.Index(text[i+2:], `\)`); end >= 0 {
+					p.add(Inline{Kind: InlineMath, Text: strings.TrimSpace(text[i+2 : i+2+end])})
+					i += end + 4
+					continue
+				}
+				p.text.WriteByte(next)
+				i += 2
+			case isPunct(next):
+				p.text.WriteByte(next)
+				i += 2
+			default:
+				p.text.WriteByte(c)
+				i++
+			}
+
+		case c == '`':
+			run := runLength(text, i, '`')
+			fence := strings.Repeat("`", run)
+			end := closingRun(text, i+run, fence)
+			if end < 0 {
+				p.text.WriteString(fence)
+				i += run
+				continue
+			}
+			code := strings.ReplaceAll(text[i+run:end], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+				code = code[1 : len(code)-1]
+			}
+			p.flush()
+			p.inlines = append(p.inlines, Inline{Kind: Text, Text: code, Style: p.style | CodeSpan, URL: p.url})
+			i = end + run
+
+		case c == '$':
+			if tex, next, ok := mathSpan(text, i); ok {
+				p.add(Inline{Kind: InlineMath, Text: tex})
+				i = next
+				continue
+			}
+			p.text.WriteByte(c)
+			i++
+
+		case c == '!' && i+1 < len(text) && text[i+1] == '[':
+			if label, url, next, ok := linkAt(text, i+1); ok {
+				p.add(Inline{Kind: Image, Text: label, URL: url})
+				i = next
+				continue
+			}
+			p.text.WriteByte(c)
+			i++
+
+		case c == '[':
+			if label, url, next, ok := linkAt(text, i); ok {
+				p.nested(label, 0, url)
+				i = next
+				continue
+			}
+			p.text.WriteByte(c)
+			i++
+
+		case c == '<':
+			if end := strings.IndexByte(text[i:], '>'); end > 0 {
+				tag := text[i+1 : i+end]
+				lower := strings.ToLower(strings.ReplaceAll(tag, " ", ""))
+				if lower == "br" || lower == "br/" {
+					p.add(Inline{Kind: LineBreak})
+					i += end + 1
+					continue
+				}
+				if strings.HasPrefix(tag, "http://") || strings.HasPrefix(tag, "https://") || strings.HasPrefix(tag, "mailto:") {
+					p.nested(tag, 0, tag)
+					i += end + 1
+					continue
+				}
+			}
+			p.text.WriteByte(c)
+			i++
+
+		case c == '*' || c == '_' || (c == '~' && i+1 < len(text) && text[i+1] == '~'):
+			run := min(runLength(text, i, c), 3)
+			if c == '~' {
+				run = 2
+			}
+			if end := closingDelimiter(text, i, run); end >= 0 {
+				var style Style
+				switch {
+				case c == '~':
+					style = Strike
+				case run == 1:
+					style = Italic
+				case run == 2:
+					style = Bold
+				default:
+					style = Bold | Italic
+				}
+				p.nested(text[i+run:end], style, "")
+				i = end + run
+				continue
+			}
+			total := runLength(text, i, c)
+			p.text.WriteString(text[i : i+total])
+			i += total
+
+		case c == '\n':
+			content := p.text.String()
+			trimmed := strings.TrimRight(content, " ")
+			p.text.Reset()
+			p.text.WriteString(trimmed)
+			if len(content)-len(trimmed) >= 2 {
+				p.add(Inline{Kind: LineBreak})
+			} else {
+				p.text.WriteByte(' ')
+			}
+			i++
+			for i < len(text) && text[i] == ' ' {
+				i++
+			}
+
+		default:
+			p.text.WriteByte(c)
+			i++
+		}
+	}
+}
+
+func isPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$`*_~<>|+=^", c) >= 0
+}
+
+// runLength counts the repetitions of c starting at i
+func runLength(text string, i int, c byte) int {
+	n := 0
+	for i+n < len(text) && text[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// closingRun finds the next run of exactly fence
+func closingRun(text string, from int, fence string) int {
+	for i := from; i < len(text); {
+		j := strings.Index(text[i:], fence)
+		if j < 0 {
+			return -1
+		}
+		j += i
+		if runLength(text, j, fence[0]) == len(fence) {
+			return j
+		}
+		i = j + runLength(text, j, fence[0])
+	}
+	return -1
+}
+
+// closingDelimiter finds the emphasis delimiter closing the run of length n
+// at open. Emphasis must not start before or end after whitespace and
+// underscores do not work inside words.
+func closingDelimiter(text string, open, n int) int {
+	c := text[open]
+	if open+n >= len(text) || text[open+n] == ' ' || text[open+n] == '\n' {
+		return -1
+	}
+	if c == '_' && open > 0 && isWordByte(text[open-1]) {
+		return -1
+	}
+	for i := open + n; i < len(text); i++ {
+		switch text[i] {
+		case '`':
+			run := runLength(text, i, '`')
+			if end := closingRun(text, i+run, strings.Repeat("`", run)); end >= 0 {
+				i = end + run - 1
+			}
+			continue
+		case '\\':
+			i++
+			continue
+		case c:
+		default:
+			continue
+		}
+		run := runLength(text, i, c)
+		if run < n || text[i-1] == ' ' || text[i-1] == '\n' || i == open+n {
+			i += run - 1
+			continue
+		}
+		if c == '_' && i+run < len(text) && isWordByte(text[i+run]) {
+			i += run - 1
+			continue
+		}
+		// The last n characters of a longer run close this emphasis, as in *a **b***
+		return i + run - n
+	}
+	return -1
+}
+
+func isWordByte(c byte) bool {
+	return c >= utf8.RuneSelf || c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// mathSpan reads $...$ or $$...$$ at i. Like remark-math, a single dollar
+// does not open math before whitespace and does not close it after
+// whitespace or before a digit, so prices stay text.
+func mathSpan(text string, i int) (string, int, bool) {
+	if strings.HasPrefix(text[i:], "$$") {
+		end := strings.Index(text[i+2:], "$$")
+		if end <= 0 {
+			return "", 0, false
+		}
+		return strings.TrimSpace(text[i+2 : i+2+end]), i + end + 4, true
+	}
+
+	if i+1 >= len(text) || text[i+1] == ' ' || text[i+1] == '\n' {
+		return "", 0, false
+	}
+	for j := i + 1; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '$':
+			if text[j-1] == ' ' || (j+1 < len(text) && text[j+1] >= '0' && text[j+1] <= '9') {
+				continue
+			}
+			return text[i+1 : j], j + 1, true
+		}
+	}
+	return "", 0, false
+}
+
+// linkAt reads [label](url "title") at i
+func linkAt(text string, i int) (string, string, int, bool) {
+	depth := 0
+	labelEnd := -1
+	for j := i; j < len(text) && labelEnd < 0; j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				labelEnd = j
+			}
+		}
+	}
+	if labelEnd < 0 || labelEnd+1 >= len(text) || text[labelEnd+1] != '(' {
+		return "", "", 0, false
+	}
+
+	depth = 0
+	for j := labelEnd + 1; j < len(text); j++ {
+		switch text[j] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				target := strings.TrimSpace(text[labelEnd+2 : j])
+				if space := strings.IndexAny(target, " \n"); space >= 0 {
+					target = target[:space]
+				}
+				target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+				return text[i+1 : labelEnd], target, j + 1, true
+			}
+		case '\n':
+			return "", "", 0, false
+		}
+	}
+	return "", "", 0, false
+}
+
+// merge joins neighbouring text runs with the same style and link
+func merge(inlines []Inline) []Inline {
+	var merged []Inline
+	for _, inline := range inlines {
+		if n := len(merged); n > 0 && inline.Kind == Text && merged[n-1].Kind == Text &&
+			merged[n-1].Style == inline.Style && merged[n-1].URL == inline.URL {
+			merged[n-1].Text += inline.Text
+			continue
+		}
+		merged = append(merged, inline)
+	}
+	return merged
+}
@@ -0,0 +1,405 @@
+// Package markdown parses the markdown written by the model into blocks that
+// the document exporters render. It covers what the prompts ask for: ATX and
+// setext headings, emphasis, bullet and numbered lists, GFM tables, fenced
+// code, block quotes, links, images and $...$ / $$...$$ LaTeX math, as
+// rendered by remark-gfm and remark-math in the client.
+//
+// Indented code blocks are not supported since the model often indents math
+// and list content without meaning code.
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BlockKind is the kind of a block
+type BlockKind int
+
+const (
+	Paragraph BlockKind = iota
+	Heading
+	List
+	Table
+	Code
+	Math
+	Quote
+	Rule
+)
+
+// Align is the alignment of a table column
+type Align int
+
+const (
+	AlignDefault Align = iota
+	AlignLeft
+	AlignCenter
+	AlignRight
+)
+
+// Block is a block of the document. The fields used depend on Kind.
+type Block struct {
+	Kind    BlockKind
+	Level   int      // heading level, 1 to 6
+	Inlines []Inline // paragraph and heading content
+	Text    string   // code and TeX of a math block
+	Lang    string   // code language
+
+	Ordered bool
+	Start   int       // number of the first item of an ordered list
+	Items   [][]Block // list items
+
+	Children []Block // quote content
+
+	Align  []Align      // table column alignment
+	Header [][]Inline   // table header cells
+	Rows   [][][]Inline // table body cells
+}
+
+// InlineKind is the kind of an inline element
+type InlineKind int
+
+const (
+	Text InlineKind = iota
+	InlineMath
+	Image
+	LineBreak
+)
+
+// Style is a set of text styles
+type Style int
+
+const (
+	Bold Style = 1 << iota
+	Italic
+	Strike
+	CodeSpan
+)
+
+// Inline is a run of text with a single style, a math formula, an image or
+// a line break
+type Inline struct {
+	Kind  InlineKind
+	Text  string // text, TeX or image alt text
+	Style Style
+	URL   string // link target or image source
+}
+
+// PlainText returns the text of inlines without formatting
+func PlainText(inlines []Inline) string {
+	var builder strings.Builder
+	for _, inline := range inlines {
+		switch inline.Kind {
+		case LineBreak:
+			builder.WriteString("\n")
+		default:
+			builder.WriteString(inline.Text)
+		}
+	}
+	return builder.String()
+}
+
+var (
+	headingPattern   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	rulePattern      = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	fencePattern     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`]*)$")
+	listPattern      = regexp.MustCompile(`^( *)([-*+]|\d{1,9}[.)])( +|$)(.*)$`)
+	delimiterPattern = regexp.MustCompile(`^ *\|? *:?-+:? *(\| *:?-+:? *)*\|? *$`)
+	setextPattern    = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+)
+
+// Parse parses markdown text
+func Parse(text string) []Block {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\t", "    ")
+	return parseBlocks(strings.Split(text, "\n"))
+}
+
+func parseBlocks(lines []string) []Block {
+	var blocks []Block
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case fencePattern.MatchString(line):
+			block, next := parseFence(lines, i)
+			blocks = append(blocks, block)
+			i = next
+
+		case strings.HasPrefix(trimmed, "$$") || trimmed == `\[`:
+			block, next := parseMathBlock(lines, i)
+			blocks = append(blocks, block)
+			i = next
+
+		case headingPattern.MatchString(line):
+			match := headingPattern.FindStringSubmatch(line)
+			blocks = append(blocks, Block{Kind: Heading, Level: len(match[1]), Inlines: ParseInlines(match[2])})
+			i++
+
+		case rulePattern.MatchString(line):
+			blocks = append(blocks, Block{Kind: Rule})
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				content := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(content, " "))
+			}
+			blocks = append(blocks, Block{Kind: Quote, Children: parseBlocks(quoted)})
+
+		case listPattern.MatchString(line):
+			block, next := parseList(lines, i)
+			blocks = append(blocks, block)
+			i = next
+
+		case i+1 < len(lines) && strings.Contains(line, "|") && delimiterPattern.MatchString(lines[i+1]):
+			block, next := parseTable(lines, i)
+			blocks = append(blocks, block)
+			i = next
+
+		default:
+			block, next := parseParagraph(lines, i)
+			blocks = append(blocks, block)
+			i = next
+		}
+	}
+	return blocks
+}
+
+// startsBlock reports whether a line interrupts a paragraph
+func startsBlock(lines []string, i int) bool {
+	line := lines[i]
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, ">") || strings.HasPrefix(trimmed, "$$") || trimmed == `\[` {
+		return true
+	}
+	if fencePattern.MatchString(line) || headingPattern.MatchString(line) || rulePattern.MatchString(line) {
+		return true
+	}
+	if match := listPattern.FindStringSubmatch(line); match != nil && match[4] != "" {
+		return true
+	}
+	return i+1 < len(lines) && strings.Contains(line, "|") && delimiterPattern.MatchString(lines[i+1])
+}
+
+func parseParagraph(lines []string, i int) (Block, int) {
+	var text []string
+	for ; i < len(lines); i++ {
+		if len(text) > 0 {
+			if match := setextPattern.FindStringSubmatch(lines[i]); match != nil {
+				level := 1
+				if match[1][0] == '-' {
+					level = 2
+				}
+				return Block{Kind: Heading, Level: level, Inlines: ParseInlines(strings.Join(text, "\n"))}, i + 1
+			}
+			if startsBlock(lines, i) {
+				break
+			}
+		}
+		text = append(text, lines[i])
+	}
+	return Block{Kind: Paragraph, Inlines: ParseInlines(strings.Join(text, "\n"))}, i
+}
+
+func parseFence(lines []string, i int) (Block, int) {
+	match := fencePattern.FindStringSubmatch(lines[i])
+	indent, fence := len(match[1]), match[2]
+	block := Block{Kind: Code}
+	if fields := strings.Fields(match[3]); len(fields) > 0 {
+		block.Lang = fields[0]
+	}
+
+	var code []string
+	for i++; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence[:1]) && strings.Trim(trimmed, fence[:1]) == "" && len(trimmed) >= len(fence) {
+			i++
+			break
+		}
+		line := lines[i]
+		for n := 0; n < indent && strings.HasPrefix(line, " "); n++ {
+			line = line[1:]
+		}
+		code = append(code, line)
+	}
+	block.Text = strings.Join(code, "\n")
+	if block.Lang == "math" {
+		return Block{Kind: Math, Text: block.Text}, i
+	}
+	return block, i
+}
+
+// parseMathBlock reads $$ ... $$ or \[ ... \], on one line or several
+func parseMathBlock(lines []string, i int) (Block, int) {
+	open, close := "$$", "$$"
+	trimmed := strings.TrimSpace(lines[i])
+	if trimmed == `\[` {
+		open, close = `\[`, `\]`
+	}
+
+	rest := strings.TrimPrefix(trimmed, open)
+	if end := strings.Index(rest, close); end >= 0 {
+		return Block{Kind: Math, Text: strings.TrimSpace(rest[:end])}, i + 1
+	}
+
+	tex := []string{rest}
+	for i++; i < len(lines); i++ {
+		if end := strings.Index(lines[i], close); end >= 0 {
+			tex = append(tex, lines[i][:end])
+			i++
+			break
+		}
+		tex = append(tex, lines[i])
+	}
+	return Block{Kind: Math, Text: strings.TrimSpace(strings.Join(tex, "\n"))}, i
+}
+
+// parseList reads a list and the items nested in it. An item runs until a
+// line that is neither indented past its marker nor a lazy continuation.
+func parseList(lines []string, i int) (Block, int) {
+	first := listPattern.FindStringSubmatch(lines[i])
+	indent := len(first[1])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	block := Block{Kind: List, Ordered: ordered}
+	if ordered {
+		block.Start, _ = strconv.Atoi(strings.TrimRight(first[2], ".)"))
+	}
+
+	for i < len(lines) {
+		match := listPattern.FindStringSubmatch(lines[i])
+		if match == nil || len(match[1]) != indent || (match[2][0] >= '0' && match[2][0] <= '9') != ordered {
+			break
+		}
+		contentIndent := len(match[1]) + len(match[2]) + max(len(match[3]), 1)
+		if len(match[3]) > 4 {
+			contentIndent = len(match[1]) + len(match[2]) + 1
+		}
+
+		item := []string{match[4]}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// A blank line ends the item unless indented content follows
+				next := i + 1
+				for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+					next++
+				}
+				if next < len(lines) && leadingSpaces(lines[next]) >= contentIndent {
+					item = append(item, "")
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) >= contentIndent {
+				item = append(item, line[contentIndent:])
+				continue
+			}
+			if nested := listPattern.FindStringSubmatch(line); nested != nil && len(nested[1]) > indent {
+				// Nested lists are often indented less than the content
+				item = append(item, strings.TrimLeft(line, " "))
+				continue
+			}
+			if startsBlock(lines, i) {
+				break
+			}
+			item = append(item, line)
+		}
+		block.Items = append(block.Items, parseBlocks(item))
+
+		// Blank lines between items keep the list going
+		next := i
+		for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+			next++
+		}
+		if next < len(lines) && next != i {
+			if match := listPattern.FindStringSubmatch(lines[next]); match != nil && len(match[1]) == indent {
+				i = next
+			}
+		}
+	}
+	return block, i
+}
+
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func parseTable(lines []string, i int) (Block, int) {
+	header := splitRow(lines[i])
+	block := Block{Kind: Table}
+	for _, cell := range splitRow(lines[i+1]) {
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			block.Align = append(block.Align, AlignCenter)
+		case right:
+			block.Align = append(block.Align, AlignRight)
+		case left:
+			block.Align = append(block.Align, AlignLeft)
+		default:
+			block.Align = append(block.Align, AlignDefault)
+		}
+	}
+	columns := len(header)
+	block.Align = fit(block.Align, columns)
+	for _, cell := range header {
+		block.Header = append(block.Header, ParseInlines(cell))
+	}
+
+	for i += 2; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" || !strings.Contains(lines[i], "|") {
+			break
+		}
+		cells := splitRow(lines[i])
+		row := make([][]Inline, columns)
+		for c := 0; c < columns && c < len(cells); c++ {
+			row[c] = ParseInlines(cells[c])
+		}
+		block.Rows = append(block.Rows, row)
+	}
+	return block, i
+}
+
+// fit pads or truncates alignments to the number of columns
+func fit(align []Align, columns int) []Align {
+	for len(align) < columns {
+		align = append(align, AlignDefault)
+	}
+	return align[:columns]
+}
+
+// splitRow splits a table row on pipes that are not escaped or inside code
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	inCode := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '`':
+			inCode = !inCode
+			cell.WriteByte('`')
+		case line[i] == '|' && !inCode:
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
@@ -0,0 +1,40 @@
+// models/blobs.go
+package models
+
+import "time"
+
+// Blob is stored content, addressed by its SHA-256, with its upload to the
+// LLM provider. Documents and attachments with the same content share the
+// blob and its upload.
+type Blob struct {
+	BlobKey string `gorm:"primaryKey;column:blobKey" json:"blobKey"` // Hex SHA-256 of the content
+	Size    int64  `gorm:"not null;default:0" json:"size"`
+
+	// Providers drop uploads after a while, so the blob is uploaded again
+	// before RemoteExpiresAt
+	RemoteName      string     `gorm:"column:remoteName" json:"remoteName,omitempty"`
+	RemoteURI       string     `gorm:"column:remoteUri" json:"remoteUri,omitempty"`
+	RemoteExpiresAt *time.Time `gorm:"index;column:remoteExpiresAt" json:"remoteExpiresAt,omitempty"` // Nil when the upload does not expire
+
+	CreatedAt time.Time `gorm:"autoCreateTime;column:createdAt" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime;column:updatedAt" json:"updatedAt"`
+}
+
+// TableName specifies the table name for the Blob model
+func (Blob) TableName() string {
+	return "blobs"
+}
+
+// BlobReference counts the documents and attachments of a user that use a
+// blob. The blob is deleted once no user references it.
+type BlobReference struct {
+	BlobKey   string    `gorm:"primaryKey;column:blobKey" json:"blobKey"`
+	UserId    string    `gorm:"primaryKey;column:userId" json:"userId"`
+	Count     int       `gorm:"not null;default:0" json:"count"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime;column:updatedAt" json:"updatedAt"`
+}
+
+// TableName specifies the table name for the BlobReference model
+func (BlobReference) TableName() string {
+	return "blob_references"
+}
@@ -0,0 +1,70 @@
+// models/conversations.go
+package models
+
+import (
+	"time"
+
+	"github.com/integems/report-agent/src/llm"
+)
+
+// Conversation is a chat session. A document can have several
+// conversations. Their messages form a tree: regenerating an answer or
+// editing a prompt starts a branch, and ActiveMessageId is the end of the
+// branch that is shown and sent to the model.
+type Conversation struct {
+	ConversationId  string  `gorm:"primaryKey;column:conversationId" json:"conversationId"`
+	UserId          string  `gorm:"index;column:userId" json:"userId"` // Empty for sessions imported without a known owner
+	DocumentId      *string `gorm:"index;column:documentId" json:"documentId,omitempty"`
+	DocumentVersion *int    `gorm:"column:documentVersion" json:"documentVersion,omitempty"` // Pinned version of the document, nil for the latest
+	Title           string  `json:"title"`                                                   // Generated after the first exchange unless set by the user
+	Pinned          bool    `gorm:"not null;default:false" json:"pinned"`
+	Archived        bool    `gorm:"not null;default:false" json:"archived"`
+	ActiveMessageId *uint   `gorm:"column:activeMessageId" json:"activeMessageId,omitempty"`
+
+	// Summary condenses the messages up to SummaryMessageId once the
+	// conversation no longer fits in the context budget
+	Summary          string `gorm:"type:text" json:"summary,omitempty"`
+	SummaryMessageId *uint  `gorm:"column:summaryMessageId" json:"summaryMessageId,omitempty"`
+	SummaryTokens    int    `gorm:"not null;default:0;column:summaryTokens" json:"summaryTokens,omitempty"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime;column:createdAt" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime;column:updatedAt" json:"updatedAt"`
+	Messages  []Message `gorm:"foreignKey:ConversationId;references:ConversationId;constraint:OnDelete:CASCADE" json:"messages,omitempty"`
+}
+
+// TableName specifies the table name for the Conversation model
+func (Conversation) TableName() string {
+	return "conversations"
+}
+
+// Message is one turn of a conversation: a user prompt, an attached file or
+// an answer of the model with its metadata
+type Message struct {
+	MessageId      uint   `gorm:"primaryKey;autoIncrement;column:messageId" json:"messageId"`
+	ConversationId string `gorm:"not null;index;column:conversationId" json:"conversationId"`
+	ParentId       *uint  `gorm:"index;column:parentId" json:"parentId,omitempty"` // Previous message, nil for the first
+	Role           string `gorm:"not null" json:"role"`
+	ContentType    string `gorm:"not null;default:text;column:contentType" json:"contentType"`
+	Content        string `gorm:"type:text;not null" json:"content"`
+	Tokens         int    `gorm:"not null;default:0" json:"tokens"`              // Estimated size of the content
+	BlobKey        string `gorm:"index;column:blobKey" json:"blobKey,omitempty"` // Stored copy of an attached file
+	BlobUserId     string `gorm:"column:blobUserId" json:"blobUserId,omitempty"` // User who attached the file, whose reference to the blob the message holds
+
+	// Set on AI messages
+	FinishReason   string             `gorm:"column:finishReason" json:"finishReason,omitempty"`
+	SafetyRatings  []llm.SafetyRating `gorm:"serializer:json;column:safetyRatings" json:"safetyRatings,omitempty"`
+	PromptTokens   int                `gorm:"not null;default:0;column:promptTokens" json:"promptTokens"`
+	ResponseTokens int                `gorm:"not null;default:0;column:responseTokens" json:"responseTokens"`
+	TotalTokens    int                `gorm:"not null;default:0;column:totalTokens" json:"totalTokens"`
+	Continuations  int                `gorm:"not null;default:0" json:"continuations"`
+	Repairs        int                `gorm:"not null;default:0" json:"repairs"`
+	Alternatives   []string           `gorm:"serializer:json" json:"alternatives,omitempty"`
+	PromptVersion  string             `gorm:"column:promptVersion" json:"promptVersion,omitempty"` // System prompt the answer was generated with, e.g. "chat@v1"
+
+	CreatedAt time.Time `gorm:"autoCreateTime;column:createdAt" json:"createdAt"`
+}
+
+// TableName specifies the table name for the Message model
+func (Message) TableName() string {
+	return "messages"
+}
@@ -3,21 +3,53 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // Video represents the video model with GORM tags and JSON tags
 type Document struct {
-	DocumentId string    `gorm:"primaryKey;autoIncrement;column:documentId" json:"documentId"`
-	Title      string    `gorm:"not null" json:"title"`
-	URL        string    `gorm:"not null" json:"url"`
-	Type       string    `json:"type"`
-	UserId     string    `gorm:"not null;column:userId" json:"userId"` // Foreign key reference to User
-	CreatedAt  time.Time `gorm:"autoCreateTime;column:createdAt" json:"createdAt"`
-	UpdatedAt  time.Time `gorm:"autoUpdateTime;column:updatedAt" json:"updatedAt"`
-	User       *User     `json:"user,omitempty"`
+	DocumentId string `gorm:"primaryKey;autoIncrement;column:documentId" json:"documentId"`
+	Title      string `gorm:"not null" json:"title"`
+	URL        string `gorm:"not null" json:"url"`
+	Type       string `json:"type"`
+	UserId     string `gorm:"not null;column:userId" json:"userId"`          // Foreign key reference to User
+	BlobKey    string `gorm:"index;column:blobKey" json:"blobKey,omitempty"` // Stored original, empty for documents kept in the documents directory
+	Version    int    `gorm:"not null;default:1" json:"version"`             // Latest version, whose original and type the document has
+
+	CreatedAt time.Time      `gorm:"autoCreateTime;column:createdAt" json:"createdAt"`
+	UpdatedAt time.Time      `gorm:"autoUpdateTime;column:updatedAt" json:"updatedAt"`
+	DeletedAt gorm.DeletedAt `gorm:"index;column:deletedAt" json:"deletedAt,omitempty"` // Set while the document is in the trash
+	User      *User          `json:"user,omitempty"`
 }
 
 // TableName specifies the table name for the Video model
 func (Document) TableName() string {
 	return "documents"
 }
+
+// DocumentVersion is a revision of a reference document. The document has
+// the original of its latest version; conversations can be pinned to an
+// older one.
+type DocumentVersion struct {
+	VersionId  uint      `gorm:"primaryKey;autoIncrement;column:versionId" json:"versionId"`
+	DocumentId string    `gorm:"not null;uniqueIndex:idx_document_version;column:documentId" json:"documentId"`
+	Version    int       `gorm:"not null;uniqueIndex:idx_document_version" json:"version"` // From 1
+	BlobKey    string    `gorm:"column:blobKey" json:"blobKey,omitempty"`
+	Type       string    `json:"type"`
+	UserId     string    `gorm:"not null;column:userId" json:"userId"` // Who uploaded the version
+	Note       string    `json:"note,omitempty"`                       // What changed, set by the uploader
+	CreatedAt  time.Time `gorm:"autoCreateTime;column:createdAt" json:"createdAt"`
+}
+
+// TableName specifies the table name for the DocumentVersion model
+func (DocumentVersion) TableName() string {
+	return "document_versions"
+}
+
+// At returns the document as it was at a version, with the original and
+// type of that version
+func (d Document) At(version DocumentVersion) *Document {
+	d.BlobKey, d.Type, d.Version = version.BlobKey, version.Type, version.Version
+	return &d
+}
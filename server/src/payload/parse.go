@@ -0,0 +1,301 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Delimiter separates the text answer from the JSON payload
+const Delimiter = "&&json"
+
+// ErrNoJSON is returned when the text after the delimiter holds no JSON object
+var ErrNoJSON = errors.New("no JSON object found after " + Delimiter)
+
+// Result is a model answer split into its text and the parsed payload
+type Result struct {
+	Text     string         `json:"text"`
+	Slides   []SlideContent `json:"slides,omitempty"`
+	Excel    *ExcelData     `json:"excel,omitempty"`
+	Sheets   []ExcelData    `json:"sheets,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+
+	// problems are the warnings that make the payload unusable
+	problems []string
+	// decoded is false when the payload could not be decoded at all
+	decoded bool
+}
+
+// Problems returns the decoding and validation errors of the payload
+func (r *Result) Problems() []string {
+	return r.problems
+}
+
+// Valid reports whether the payload, if any, decoded and passed validation
+func (r *Result) Valid() bool {
+	return len(r.problems) == 0
+}
+
+// HasPayload reports whether the answer carried slides or Excel data
+func (r *Result) HasPayload() bool {
+	return len(r.Slides) > 0 || r.Excel != nil
+}
+
+// Workbook returns the Excel sheets of the answer
+func (r *Result) Workbook() []ExcelData {
+	return (&Payload{Excel: r.Excel, Sheets: r.Sheets}).Workbook()
+}
+
+// Parse splits an answer on the &&json delimiter, decodes the payload and
+// validates it. Problems are reported as warnings; the text is always kept.
+func Parse(answer string) *Result {
+	text, raw, found := Split(answer)
+	result := &Result{Text: text}
+	if !found {
+		return result
+	}
+
+	payload, err := Decode(raw)
+	if err != nil {
+		result.problems = []string{err.Error()}
+	} else {
+		result.Slides = payload.Slides
+		result.Excel = payload.Excel
+		result.Sheets = payload.Sheets
+		result.problems = payload.Validate()
+		result.decoded = true
+	}
+	result.Warnings = result.problems
+	return result
+}
+
+// Split returns the text of an answer and the raw JSON object that follows
+// the delimiter, without code fences or comments. Text written after the JSON
+// is appended to the text. found is false when there is no delimiter.
+func Split(answer string) (text string, raw string, found bool) {
+	index := strings.Index(answer, Delimiter)
+	if index < 0 {
+		return strings.TrimSpace(answer), "", false
+	}
+
+	before := strings.TrimSpace(answer[:index])
+	rest := answer[index+len(Delimiter):]
+
+	start := strings.Index(rest, "{")
+	if start < 0 {
+		return before, "", true
+	}
+	end, ok := scanObject(rest[start:])
+	if !ok {
+		// Unterminated object, hand everything over so the decoder reports it
+		return before, stripComments(rest[start:]), true
+	}
+
+	after := trimFences(rest[start+end:])
+	if after != "" {
+		before = strings.TrimSpace(before + "\n\n" + after)
+	}
+	return before, stripComments(rest[start : start+end]), true
+}
+
+// Decode parses a raw payload. Excel data may be a single sheet or named
+// sheets.
+func Decode(raw string) (*Payload, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, ErrNoJSON
+	}
+
+	var document struct {
+		Slides []SlideContent   `json:"slides"`
+		Excel  *json.RawMessage `json:"excel"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	payload := &Payload{Slides: document.Slides}
+	if document.Excel != nil && string(*document.Excel) != "null" {
+		sheets, named, err := decodeExcel(*document.Excel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excel data: %w", err)
+		}
+		payload.Excel = &sheets[0]
+		if named {
+			payload.Sheets = sheets
+		}
+	}
+	return payload, nil
+}
+
+// decodeExcel accepts a single sheet or named sheets, which are returned in
+// the order they appear in the JSON
+func decodeExcel(raw json.RawMessage) ([]ExcelData, bool, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, false, err
+	}
+
+	_, hasColumns := fields["columnLabels"]
+	_, hasData := fields["data"]
+	if hasColumns || hasData || len(fields) == 0 {
+		var excel ExcelData
+		if err := json.Unmarshal(raw, &excel); err != nil {
+			return nil, false, err
+		}
+		return []ExcelData{excel}, false, nil
+	}
+
+	names, err := objectKeys(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	sheets := make([]ExcelData, 0, len(names))
+	for _, name := range names {
+		var excel ExcelData
+		if err := json.Unmarshal(fields[name], &excel); err != nil {
+			return nil, false, fmt.Errorf("sheet %q: %w", name, err)
+		}
+		excel.Name = name
+		sheets = append(sheets, excel)
+	}
+	return sheets, true, nil
+}
+
+// objectKeys returns the keys of a JSON object in document order
+func objectKeys(raw json.RawMessage) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, token.(string))
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// trimFences removes the code fence markers that models wrap JSON in
+func trimFences(text string) string {
+	text = strings.TrimSpace(text)
+	for _, fence := range []string{"```", "'''"} {
+		text = strings.TrimPrefix(text, fence+"json")
+		text = strings.TrimPrefix(text, fence)
+		text = strings.TrimSuffix(text, fence)
+		text = strings.TrimSpace(text)
+	}
+	return text
+}
+
+// scanObject returns the length of the JSON object at the start of text,
+// skipping strings and comments
+func scanObject(text string) (int, bool) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '"':
+			i = skipString(text, i)
+		case c == '/' && i+1 < len(text) && text[i+1] == '/':
+			i = skipLineComment(text, i)
+		case c == '/' && i+1 < len(text) && text[i+1] == '*':
+			i = skipBlockComment(text, i)
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// stripComments removes // and /* */ comments and trailing commas, which the
+// prompt examples contain but JSON does not allow
+func stripComments(text string) string {
+	return removeTrailingCommas(removeComments(text))
+}
+
+func removeComments(text string) string {
+	var builder strings.Builder
+	builder.Grow(len(text))
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '"':
+			end := skipString(text, i)
+			builder.WriteString(text[i:min(end+1, len(text))])
+			i = end
+		case c == '/' && i+1 < len(text) && text[i+1] == '/':
+			i = skipLineComment(text, i) - 1
+		case c == '/' && i+1 < len(text) && text[i+1] == '*':
+			i = skipBlockComment(text, i)
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+func removeTrailingCommas(text string) string {
+	var builder strings.Builder
+	builder.Grow(len(text))
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '"' {
+			end := skipString(text, i)
+			builder.WriteString(text[i:min(end+1, len(text))])
+			i = end
+			continue
+		}
+		if c == ',' {
+			next := strings.TrimLeft(text[i+1:], " \t\r\n")
+			if strings.HasPrefix(next, "}") || strings.HasPrefix(next, "]") {
+				continue
+			}
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
+
+// skipString returns the index of the quote closing the string at start
+func skipString(text string, start int) int {
+	for i := start + 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return len(text)
+}
+
+// skipLineComment returns the index of the newline ending the comment
+func skipLineComment(text string, start int) int {
+	end := strings.IndexByte(text[start:], '\n')
+	if end < 0 {
+		return len(text)
+	}
+	return start + end
+}
+
+// skipBlockComment returns the index of the last character of the comment
+func skipBlockComment(text string, start int) int {
+	end := strings.Index(text[start+2:], "*/")
+	if end < 0 {
+		return len(text)
+	}
+	return start + 2 + end + 1
+}
@@ -0,0 +1,204 @@
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		text   string
+		raw    string
+		found  bool
+	}{
+		{
+			name:   "no delimiter",
+			answer: "  Just an answer.\n",
+			text:   "Just an answer.",
+		},
+		{
+			name:   "inline",
+			answer: `Here is the deck. &&json {"slides": []}`,
+			text:   "Here is the deck.",
+			raw:    `{"slides": []}`,
+			found:  true,
+		},
+		{
+			name:   "code fence",
+			answer: "Here is the deck.\n&&json\n```json\n{\"slides\": []}\n```",
+			text:   "Here is the deck.",
+			raw:    `{"slides": []}`,
+			found:  true,
+		},
+		{
+			name:   "text after the JSON",
+			answer: "Intro\n&&json\n```json\n{\"slides\": []}\n```\nLet me know if you need changes.",
+			text:   "Intro\n\nLet me know if you need changes.",
+			raw:    `{"slides": []}`,
+			found:  true,
+		},
+		{
+			name:   "braces in strings",
+			answer: `Intro &&json {"excel": {"columnLabels": ["}"], "data": []}} Outro`,
+			text:   "Intro\n\nOutro",
+			raw:    `{"excel": {"columnLabels": ["}"], "data": []}}`,
+			found:  true,
+		},
+		{
+			name:   "no object after the delimiter",
+			answer: "Intro &&json nothing here",
+			text:   "Intro",
+			found:  true,
+		},
+		{
+			name:   "unterminated object",
+			answer: `Intro &&json {"slides": [`,
+			text:   "Intro",
+			raw:    `{"slides": [`,
+			found:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text, raw, found := Split(test.answer)
+			if text != test.text || raw != test.raw || found != test.found {
+				t.Errorf("Split() = %q, %q, %v, want %q, %q, %v", text, raw, found, test.text, test.raw, test.found)
+			}
+		})
+	}
+}
+
+func TestSplitStripsComments(t *testing.T) {
+	answer := `Intro &&json {
+		// the slides
+		"slides": [
+			{"data": [{"type": "Image", "value": "https://example.com/a.png", /* position */ "options": {"x": 1,},},],},
+		],
+	}`
+	_, raw, _ := Split(answer)
+
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+		t.Fatalf("raw payload is not JSON: %v\n%s", err, raw)
+	}
+	if !strings.Contains(raw, "https://example.com/a.png") {
+		t.Errorf("comment stripping altered a string: %s", raw)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    *Payload
+		wantErr bool
+	}{
+		{
+			name: "slides",
+			raw:  `{"slides": [{"data": [{"type": "Text", "value": 42, "options": {"x": 1}}]}]}`,
+			want: &Payload{Slides: []SlideContent{{Data: []SlideData{
+				{Type: ContentText, Value: DataValue{Text: "42"}, Options: Options{"x": 1.0}},
+			}}}},
+		},
+		{
+			name: "table and chart",
+			raw: `{"slides": [{"data": [
+				{"type": "Table", "value": [["A", {"text": 1, "options": {"bold": true}}]]},
+				{"type": "Chart", "value": "bar", "chatData": [{"name": "Sales", "labels": ["Q1", 2], "values": ["1.5", 3]}]}
+			]}]}`,
+			want: &Payload{Slides: []SlideContent{{Data: []SlideData{
+				{Type: ContentTable, Value: DataValue{Rows: [][]TableCell{{{Text: "A"}, {Text: "1", Options: Options{"bold": true}}}}}},
+				{Type: ContentChart, Value: DataValue{Text: "bar"}, ChatData: []ChartData{{Name: "Sales", Labels: []string{"Q1", "2"}, Values: []float64{1.5, 3}}}},
+			}}}},
+		},
+		{
+			name: "single sheet",
+			raw:  `{"excel": {"columnLabels": ["Name", "Total"], "data": [[{"value": "North"}, 12]]}}`,
+			want: &Payload{Excel: &ExcelData{
+				ColumnLabels: []string{"Name", "Total"},
+				Data:         [][]ExcelCell{{{Value: "North"}, {Value: 12.0}}},
+			}},
+		},
+		{
+			name: "named sheets keep their order",
+			raw:  `{"excel": {"Sales": {"columnLabels": ["A"], "data": []}, "Costs": {"columnLabels": ["B"], "data": []}}}`,
+			want: &Payload{
+				Excel: &ExcelData{Name: "Sales", ColumnLabels: []string{"A"}, Data: [][]ExcelCell{}},
+				Sheets: []ExcelData{
+					{Name: "Sales", ColumnLabels: []string{"A"}, Data: [][]ExcelCell{}},
+					{Name: "Costs", ColumnLabels: []string{"B"}, Data: [][]ExcelCell{}},
+				},
+			},
+		},
+		{
+			name: "null excel",
+			raw:  `{"slides": [], "excel": null}`,
+			want: &Payload{Slides: []SlideContent{}},
+		},
+		{name: "empty", raw: "  ", wantErr: true},
+		{name: "invalid JSON", raw: `{"slides": [`, wantErr: true},
+		{name: "chart value is not a number", raw: `{"slides": [{"data": [{"type": "Chart", "value": "bar", "chatData": [{"values": ["many"]}]}]}]}`, wantErr: true},
+		{name: "invalid sheet", raw: `{"excel": {"Sales": []}}`, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := Decode(test.raw)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("Decode() = %+v, want an error", payload)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(payload, test.want) {
+				got, _ := json.Marshal(payload)
+				want, _ := json.Marshal(test.want)
+				t.Errorf("Decode() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeEmpty(t *testing.T) {
+	if _, err := Decode(""); !errors.Is(err, ErrNoJSON) {
+		t.Errorf("Decode(\"\") error = %v, want ErrNoJSON", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		answer   string
+		valid    bool
+		decoded  bool
+		payload  bool
+		warnings int
+	}{
+		{name: "text only", answer: "No payload here.", valid: true},
+		{name: "valid payload", answer: `Deck &&json {"slides": [{"data": [{"type": "Text", "value": "Hi"}]}]}`, valid: true, decoded: true, payload: true},
+		{name: "invalid payload", answer: `Deck &&json {"slides": [{"data": [{"type": "Video", "value": "Hi"}]}]}`, decoded: true, payload: true, warnings: 1},
+		{name: "undecodable payload", answer: `Deck &&json {"slides": [`, warnings: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Parse(test.answer)
+			if result.Valid() != test.valid || result.decoded != test.decoded || result.HasPayload() != test.payload {
+				t.Errorf("valid = %v, decoded = %v, payload = %v, want %v, %v, %v",
+					result.Valid(), result.decoded, result.HasPayload(), test.valid, test.decoded, test.payload)
+			}
+			if len(result.Warnings) != test.warnings {
+				t.Errorf("warnings = %q, want %d", result.Warnings, test.warnings)
+			}
+			if result.Text == "" {
+				t.Error("text was dropped")
+			}
+		})
+	}
+}
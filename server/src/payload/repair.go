@@ -0,0 +1,111 @@
+package payload
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/integems/report-agent/src/llm"
+)
+
+// Repaired is the outcome of Repair
+type Repaired struct {
+	// Answer is the original text followed by the best payload found
+	Answer string
+	Result *Result
+	// Attempts is the number of repair requests sent to the model
+	Attempts int
+	// Usage counts the tokens of the repair requests
+	Usage llm.Usage
+}
+
+// Repair sends the validation problems of an answer back to the model and
+// asks for corrected JSON, up to maxAttempts times. request is the request
+// that produced answer. The best answer is returned, so the result may still
+// be invalid when every attempt fails.
+func Repair(ctx context.Context, provider llm.Provider, request llm.ChatRequest, answer string, maxAttempts int) *Repaired {
+	best := &Repaired{Answer: answer, Result: Parse(answer)}
+	text, _, found := Split(answer)
+	if !found {
+		return best
+	}
+
+	history := make([]llm.Message, 0, len(request.History)+2)
+	history = append(history, request.History...)
+	history = append(history,
+		llm.Message{Role: llm.RoleUser, Parts: request.Parts},
+		llm.Message{Role: llm.RoleModel, Parts: []llm.Part{llm.Text(answer)}},
+	)
+
+	// Each attempt is asked to fix the problems of the previous reply
+	problems := best.Result.Problems()
+	for !best.Result.Valid() && best.Attempts < maxAttempts {
+		best.Attempts++
+		prompt := llm.Text(repairPrompt(problems))
+		resp, err := provider.Chat(ctx, llm.ChatRequest{Model: request.Model, History: history, Parts: []llm.Part{prompt}})
+		if err != nil {
+			log.Printf("Failed to repair payload: %v", err)
+			break
+		}
+		best.Usage.Add(resp.Usage)
+
+		candidate := text + "\n\n" + Delimiter + "\n" + correctedJSON(resp.Text)
+		result := Parse(candidate)
+		problems = result.Problems()
+		if result.betterThan(best.Result) {
+			best.Answer = candidate
+			best.Result = result
+		}
+
+		history = append(history,
+			llm.Message{Role: llm.RoleUser, Parts: []llm.Part{prompt}},
+			llm.Message{Role: llm.RoleModel, Parts: []llm.Part{llm.Text(resp.Text)}},
+		)
+	}
+	return best
+}
+
+// betterThan reports whether r is a better answer than other: a payload
+// that decoded beats one that did not, then fewer problems win
+func (r *Result) betterThan(other *Result) bool {
+	if r.decoded != other.decoded {
+		return r.decoded
+	}
+	return len(r.problems) < len(other.problems)
+}
+
+// correctedJSON takes the JSON out of a repair reply, which may or may not
+// repeat the delimiter or wrap the JSON in a code fence
+func correctedJSON(reply string) string {
+	if _, raw, found := Split(reply); found {
+		return raw
+	}
+	if start := strings.Index(reply, "{"); start >= 0 {
+		if end, ok := scanObject(reply[start:]); ok {
+			return stripComments(reply[start : start+end])
+		}
+	}
+	return reply
+}
+
+func repairPrompt(problems []string) string {
+	var builder strings.Builder
+	builder.WriteString("The JSON after " + Delimiter + " in your previous answer is invalid:\n")
+	for _, problem := range problems {
+		builder.WriteString("- " + problem + "\n")
+	}
+	fmt.Fprintf(&builder, `
+Fix every problem and keep everything else unchanged. Rules:
+- The JSON is an object with "slides" and/or "excel", without comments or trailing commas.
+- x, y, w and h are in inches; x + w must not exceed %g and y + h must not exceed %g.
+- Element types are Image, Shape, Table, Text or Chart.
+- Chart values must be one of: %s.
+- Shape values must be one of: %s.
+- Excel data is one sheet, {"columnLabels": [...], "rowLabels": [...], "data": [[{"value": ...}, ...], ...]} with one cell per column in each row,
+  or named sheets in that format, {"Sales": {"columnLabels": [...], ...}, "Costs": {...}}.
+
+Reply with the corrected JSON only, with no text before or after it.`,
+		SlideWidth, SlideHeight, strings.Join(ChartNames, ", "), strings.Join(ShapeNames, ", "))
+	return builder.String()
+}
@@ -0,0 +1,138 @@
+package payload
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/llm/fake"
+)
+
+// scripted replies to each request with the next of its replies
+type scripted struct {
+	*fake.Provider
+	replies []string
+}
+
+func (s *scripted) Chat(ctx context.Context, request llm.ChatRequest) (*llm.ChatResponse, error) {
+	s.Reply = s.replies[0]
+	s.replies = s.replies[1:]
+	return s.Provider.Chat(ctx, request)
+}
+
+const (
+	validJSON       = `{"slides": [{"data": [{"type": "Text", "value": "Hi"}]}]}`
+	invalidJSON     = `{"slides": [{"data": [{"type": "Chart", "value": "histogram", "chatData": [{"labels": ["A"], "values": [1]}]}]}]}`
+	undecodableJSON = `{"slides": [{"data": [`
+	// twoProblemsJSON has an unknown chart and a series of mismatched length
+	twoProblemsJSON = `{"slides": [{"data": [{"type": "Chart", "value": "histogram", "chatData": [{"labels": ["A"], "values": [1, 2]}]}]}]}`
+)
+
+func TestRepair(t *testing.T) {
+	tests := []struct {
+		name     string
+		answer   string
+		replies  []string
+		attempts int
+		// want is the JSON expected in the returned answer
+		want  string
+		valid bool
+	}{
+		{
+			name:   "valid answer",
+			answer: "Deck &&json " + validJSON,
+			want:   validJSON,
+			valid:  true,
+		},
+		{
+			name:   "text only",
+			answer: "No payload.",
+			valid:  true,
+		},
+		{
+			name:     "fixed on the first attempt",
+			answer:   "Deck &&json " + invalidJSON,
+			replies:  []string{"```json\n" + validJSON + "\n```"},
+			attempts: 1,
+			want:     validJSON,
+			valid:    true,
+		},
+		{
+			name:     "fixed after repeating the delimiter",
+			answer:   "Deck &&json " + undecodableJSON,
+			replies:  []string{undecodableJSON, "Sorry! &&json " + validJSON},
+			attempts: 2,
+			want:     validJSON,
+			valid:    true,
+		},
+		{
+			name:     "decoded answer kept over undecodable replies",
+			answer:   "Deck &&json " + twoProblemsJSON,
+			replies:  []string{undecodableJSON, "not JSON at all"},
+			attempts: 2,
+			want:     twoProblemsJSON,
+		},
+		{
+			name:     "decoded reply preferred to an undecodable answer",
+			answer:   "Deck &&json " + undecodableJSON,
+			replies:  []string{invalidJSON, undecodableJSON},
+			attempts: 2,
+			want:     invalidJSON,
+		},
+		{
+			name:     "fewer problems win",
+			answer:   "Deck &&json " + twoProblemsJSON,
+			replies:  []string{invalidJSON, undecodableJSON},
+			attempts: 2,
+			want:     invalidJSON,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &scripted{Provider: fake.New(), replies: test.replies}
+			request := llm.ChatRequest{Model: "model", Parts: []llm.Part{llm.Text("Make a deck")}}
+
+			repaired := Repair(context.Background(), provider, request, test.answer, 2)
+
+			if repaired.Attempts != test.attempts || len(provider.Requests) != test.attempts {
+				t.Errorf("attempts = %d with %d requests, want %d", repaired.Attempts, len(provider.Requests), test.attempts)
+			}
+			if repaired.Result.Valid() != test.valid {
+				t.Errorf("valid = %v, problems = %q", repaired.Result.Valid(), repaired.Result.Problems())
+			}
+			if _, raw, _ := Split(repaired.Answer); raw != test.want {
+				t.Errorf("answer JSON = %s, want %s", raw, test.want)
+			}
+			if text, _, _ := Split(repaired.Answer); text != Parse(test.answer).Text {
+				t.Errorf("answer text = %q, want the original text", text)
+			}
+			if test.attempts > 0 && repaired.Usage.TotalTokens == 0 {
+				t.Error("repair usage was not counted")
+			}
+		})
+	}
+}
+
+func TestRepairPrompt(t *testing.T) {
+	provider := &scripted{Provider: fake.New(), replies: []string{validJSON}}
+	request := llm.ChatRequest{
+		Model:   "model",
+		History: []llm.Message{{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("Earlier question")}}},
+		Parts:   []llm.Part{llm.Text("Make a deck")},
+	}
+	answer := "Deck &&json " + invalidJSON
+
+	Repair(context.Background(), provider, request, answer, 2)
+
+	sent := provider.Requests[0]
+	if sent.Model != "model" || len(sent.History) != 3 {
+		t.Fatalf("repair request = %+v", sent)
+	}
+	if sent.History[1].Parts[0].Text != "Make a deck" || sent.History[2].Role != llm.RoleModel || sent.History[2].Parts[0].Text != answer {
+		t.Errorf("history does not end with the original turn: %+v", sent.History)
+	}
+	if prompt := sent.Parts[0].Text; !strings.Contains(prompt, `unknown chart "histogram"`) {
+		t.Errorf("prompt does not list the problems:\n%s", prompt)
+	}
+}
@@ -0,0 +1,268 @@
+package payload
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Slide dimensions of the default pptxgenjs 16:9 layout, in inches
+const (
+	SlideWidth  = 10.0
+	SlideHeight = 5.625
+)
+
+// ContentType is the kind of element placed on a slide
+type ContentType string
+
+const (
+	ContentImage ContentType = "Image"
+	ContentShape ContentType = "Shape"
+	ContentTable ContentType = "Table"
+	ContentText  ContentType = "Text"
+	ContentChart ContentType = "Chart"
+)
+
+// Payload is the JSON the model appends after the &&json delimiter
+type Payload struct {
+	Slides []SlideContent `json:"slides,omitempty"`
+	Excel  *ExcelData     `json:"excel,omitempty"`
+	// Sheets holds every sheet when the Excel data is given as named sheets,
+	// {"excel": {"Sales": {...}, "Costs": {...}}}. Excel is then the first one.
+	Sheets []ExcelData `json:"sheets,omitempty"`
+}
+
+// Workbook returns the Excel sheets of the payload
+func (p *Payload) Workbook() []ExcelData {
+	if len(p.Sheets) > 0 {
+		return p.Sheets
+	}
+	if p.Excel != nil {
+		return []ExcelData{*p.Excel}
+	}
+	return nil
+}
+
+// SlideContent is one slide
+type SlideContent struct {
+	Data []SlideData `json:"data"`
+}
+
+// SlideData is one element of a slide. Value holds the text, the image path,
+// the shape or chart name, or the table rows depending on Type.
+type SlideData struct {
+	Type     ContentType `json:"type"`
+	Value    DataValue   `json:"value"`
+	Options  Options     `json:"options,omitempty"`
+	ChatData []ChartData `json:"chatData,omitempty"` // for chart
+}
+
+// DataValue is either a string or the rows of a table
+type DataValue struct {
+	Text string
+	Rows [][]TableCell
+}
+
+// IsRows reports whether the value holds table rows
+func (v DataValue) IsRows() bool {
+	return v.Rows != nil
+}
+
+func (v *DataValue) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var rows [][]TableCell
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return fmt.Errorf("table value must be an array of rows: %w", err)
+		}
+		if rows == nil {
+			rows = [][]TableCell{}
+		}
+		*v = DataValue{Rows: rows}
+		return nil
+	}
+
+	text, err := scalarString(data)
+	if err != nil {
+		return fmt.Errorf("value must be a string or table rows: %w", err)
+	}
+	*v = DataValue{Text: text}
+	return nil
+}
+
+func (v DataValue) MarshalJSON() ([]byte, error) {
+	if v.IsRows() {
+		return json.Marshal(v.Rows)
+	}
+	return json.Marshal(v.Text)
+}
+
+// TableCell is a table cell given either as plain text or as
+// {"text": ..., "options": {...}}
+type TableCell struct {
+	Text    string  `json:"text"`
+	Options Options `json:"options,omitempty"`
+}
+
+func (c *TableCell) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		var cell struct {
+			Text    json.RawMessage `json:"text"`
+			Options Options         `json:"options"`
+		}
+		if err := json.Unmarshal(data, &cell); err != nil {
+			return err
+		}
+		text, err := scalarString(cell.Text)
+		if err != nil {
+			return err
+		}
+		*c = TableCell{Text: text, Options: cell.Options}
+		return nil
+	}
+
+	text, err := scalarString(data)
+	if err != nil {
+		return err
+	}
+	*c = TableCell{Text: text}
+	return nil
+}
+
+func (c TableCell) MarshalJSON() ([]byte, error) {
+	if c.Options == nil {
+		return json.Marshal(c.Text)
+	}
+	type cell TableCell
+	return json.Marshal(cell(c))
+}
+
+// ChartData is one series of a chart
+type ChartData struct {
+	Name   string    `json:"name"`
+	Labels []string  `json:"labels"`
+	Values []float64 `json:"values"`
+}
+
+func (c *ChartData) UnmarshalJSON(data []byte) error {
+	var series struct {
+		Name   json.RawMessage   `json:"name"`
+		Labels []json.RawMessage `json:"labels"`
+		Values []json.RawMessage `json:"values"`
+	}
+	if err := json.Unmarshal(data, &series); err != nil {
+		return err
+	}
+
+	name, err := scalarString(series.Name)
+	if err != nil {
+		return fmt.Errorf("series name: %w", err)
+	}
+	chart := ChartData{Name: name, Labels: []string{}, Values: []float64{}}
+	for _, label := range series.Labels {
+		text, err := scalarString(label)
+		if err != nil {
+			return fmt.Errorf("series %q label: %w", name, err)
+		}
+		chart.Labels = append(chart.Labels, text)
+	}
+	for _, value := range series.Values {
+		text, err := scalarString(value)
+		if err != nil {
+			return fmt.Errorf("series %q value: %w", name, err)
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return fmt.Errorf("series %q value %q is not a number", name, text)
+		}
+		chart.Values = append(chart.Values, number)
+	}
+	*c = chart
+	return nil
+}
+
+// Options are pptxgenjs element options. They are kept as decoded JSON since
+// each element type accepts a different set.
+type Options map[string]any
+
+// Number returns a numeric option
+func (o Options) Number(key string) (float64, bool) {
+	value, ok := o[key].(float64)
+	return value, ok
+}
+
+// String returns a string option
+func (o Options) String(key string) (string, bool) {
+	value, ok := o[key].(string)
+	return value, ok
+}
+
+// Bool returns a boolean option, false when missing
+func (o Options) Bool(key string) bool {
+	value, _ := o[key].(bool)
+	return value
+}
+
+// Object returns a nested option object such as "fill" or "line"
+func (o Options) Object(key string) Options {
+	value, _ := o[key].(map[string]any)
+	return Options(value)
+}
+
+// ExcelData is a spreadsheet. Each row of Data holds one cell per column.
+type ExcelData struct {
+	Name         string        `json:"name,omitempty"`
+	ColumnLabels []string      `json:"columnLabels"`
+	RowLabels    []string      `json:"rowLabels"` // Kept for the client, exports do not use them
+	Data         [][]ExcelCell `json:"data"`
+}
+
+// ExcelCell is a spreadsheet cell given as {"value": ...} or as a plain value.
+// Value is a string, float64, bool or nil.
+type ExcelCell struct {
+	Value any `json:"value"`
+}
+
+func (c *ExcelCell) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		var cell struct {
+			Value any `json:"value"`
+		}
+		if err := json.Unmarshal(data, &cell); err != nil {
+			return err
+		}
+		*c = ExcelCell{Value: cell.Value}
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*c = ExcelCell{Value: value}
+	return nil
+}
+
+// scalarString converts a JSON string, number, boolean or null to text
+func scalarString(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", nil
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", err
+	}
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	default:
+		return "", fmt.Errorf("expected a string, found %s", string(data))
+	}
+}
@@ -0,0 +1,168 @@
+package payload
+
+import (
+	"fmt"
+	"strings"
+)
+
+// boundsTolerance absorbs rounding in positions written by the model
+const boundsTolerance = 0.01
+
+// ChartNames are the chart types the prompt allows
+var ChartNames = []string{"line", "pie", "area", "bar", "bar3D", "bubble", "doughnut", "radar", "scatter"}
+
+// ShapeNames are the pptxgenjs shape names the prompt allows
+var ShapeNames = []string{
+	"ellipse", "roundRect", "rect", "triangle", "parallelogram", "trapezoid", "diamond", "pentagon", "hexagon",
+	"heptagon", "octagon", "decagon", "dodecagon", "pie", "chord", "teardrop", "frame", "halfFrame", "corner",
+	"diagStripe", "plus", "plaque", "can", "cube", "bevel", "donut", "noSmoking", "blockArc", "foldedCorner",
+	"smileyFace", "heart", "lightningBolt", "sun", "moon", "cloud", "arc", "doubleBracket", "doubleBrace",
+	"leftBracket", "rightBracket", "leftBrace", "rightBrace", "arrow", "arrowCallout", "quadArrow", "leftArrow",
+	"rightArrow", "upArrow", "downArrow", "leftRightArrow", "upDownArrow", "bentArrow", "uTurnArrow",
+	"circularArrow", "leftCircularArrow", "rightCircularArrow", "curvedRightArrow", "curvedLeftArrow",
+	"curvedUpArrow", "curvedDownArrow", "stripedRightArrow", "notchedRightArrow", "pentagonArrow", "chevron",
+	"leftRightChevron", "star4", "star5", "star6", "star7", "star8", "star10", "star12", "star16", "star24",
+	"star32", "ribbon", "ribbon2", "banner", "wavyBanner", "callout", "rectCallout", "roundRectCallout",
+	"ellipseCallout", "cloudCallout", "lineCallout", "quadArrowCallout", "leftArrowCallout", "rightArrowCallout",
+	"upArrowCallout", "downArrowCallout", "leftRightArrowCallout", "upDownArrowCallout", "bentArrowCallout",
+	"uTurnArrowCallout", "circularArrowCallout", "leftCircularArrowCallout", "rightCircularArrowCallout",
+	"curvedRightArrowCallout", "curvedLeftArrowCallout", "curvedUpArrowCallout", "curvedDownArrowCallout",
+}
+
+var (
+	chartNames = toSet(ChartNames)
+	shapeNames = toSet(ShapeNames)
+)
+
+func toSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// IsChartName reports whether name is an allowed chart type
+func IsChartName(name string) bool {
+	return chartNames[name]
+}
+
+// shapeAliases maps other names models use for a shape to the allowed name
+var shapeAliases = map[string]string{
+	"oval": "ellipse",
+}
+
+// ShapeName returns the allowed name of a shape written with the "Shape."
+// prefix of pptxgenjs or under an alias such as "oval"
+func ShapeName(name string) string {
+	name = strings.TrimPrefix(name, "Shape.")
+	if alias, ok := shapeAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// IsShapeName reports whether name is an allowed shape, see ShapeName
+func IsShapeName(name string) bool {
+	return shapeNames[ShapeName(name)]
+}
+
+// Validate checks element types, shape and chart names, slide bounds and the
+// shape of the Excel data. It returns one message per problem.
+func (p *Payload) Validate() []string {
+	var problems []string
+	for i, slide := range p.Slides {
+		if len(slide.Data) == 0 {
+			problems = append(problems, fmt.Sprintf("slide %d has no elements", i+1))
+		}
+		for j, element := range slide.Data {
+			where := fmt.Sprintf("slide %d, element %d (%s)", i+1, j+1, element.Type)
+			for _, problem := range element.validate() {
+				problems = append(problems, where+": "+problem)
+			}
+		}
+	}
+
+	for _, sheet := range p.Workbook() {
+		where := "excel"
+		if sheet.Name != "" {
+			where = fmt.Sprintf("excel sheet %q", sheet.Name)
+		}
+		for _, problem := range sheet.validate() {
+			problems = append(problems, where+": "+problem)
+		}
+	}
+	return problems
+}
+
+func (d SlideData) validate() []string {
+	var problems []string
+	switch d.Type {
+	case ContentText, ContentImage:
+		if d.Value.IsRows() {
+			problems = append(problems, "value must be a string")
+		}
+		if shape, ok := d.Options.String("shape"); ok && !IsShapeName(shape) {
+			problems = append(problems, fmt.Sprintf("unknown shape %q in options.shape", shape))
+		}
+	case ContentShape:
+		if !IsShapeName(d.Value.Text) {
+			problems = append(problems, fmt.Sprintf("unknown shape %q", d.Value.Text))
+		}
+	case ContentChart:
+		if !IsChartName(d.Value.Text) {
+			problems = append(problems, fmt.Sprintf("unknown chart %q, allowed charts are %s", d.Value.Text, strings.Join(ChartNames, ", ")))
+		}
+		if len(d.ChatData) == 0 {
+			problems = append(problems, "chart has no chatData")
+		}
+		for _, series := range d.ChatData {
+			if len(series.Labels) != len(series.Values) {
+				problems = append(problems, fmt.Sprintf("series %q has %d labels but %d values", series.Name, len(series.Labels), len(series.Values)))
+			}
+		}
+	case ContentTable:
+		if !d.Value.IsRows() {
+			problems = append(problems, "value must be an array of table rows")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown type %q, allowed types are Image, Shape, Table, Text, Chart", d.Type))
+	}
+	return append(problems, d.Options.validateBounds()...)
+}
+
+// validateBounds checks that the element lies inside the 10 x 5.625 inch slide
+func (o Options) validateBounds() []string {
+	var problems []string
+	x, hasX := o.Number("x")
+	y, hasY := o.Number("y")
+	w, hasW := o.Number("w")
+	h, hasH := o.Number("h")
+
+	if (hasX && x < 0) || (hasY && y < 0) {
+		problems = append(problems, fmt.Sprintf("position (x: %g, y: %g) is negative", x, y))
+	}
+	if (hasW && w <= 0) || (hasH && h <= 0) {
+		problems = append(problems, fmt.Sprintf("size (w: %g, h: %g) must be positive", w, h))
+	}
+	if hasX && hasW && x+w > SlideWidth+boundsTolerance {
+		problems = append(problems, fmt.Sprintf("x + w = %g exceeds the slide width of %g", x+w, SlideWidth))
+	}
+	if hasY && hasH && y+h > SlideHeight+boundsTolerance {
+		problems = append(problems, fmt.Sprintf("y + h = %g exceeds the slide height of %g", y+h, SlideHeight))
+	}
+	return problems
+}
+
+func (e *ExcelData) validate() []string {
+	var problems []string
+	if len(e.ColumnLabels) == 0 {
+		problems = append(problems, "columnLabels is empty")
+	}
+	for i, row := range e.Data {
+		if len(e.ColumnLabels) > 0 && len(row) != len(e.ColumnLabels) {
+			problems = append(problems, fmt.Sprintf("row %d has %d cells but there are %d columns", i+1, len(row), len(e.ColumnLabels)))
+		}
+	}
+	return problems
+}
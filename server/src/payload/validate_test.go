@@ -0,0 +1,161 @@
+package payload
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		problems []string
+	}{
+		{
+			name: "valid",
+			raw: `{
+				"slides": [{"data": [
+					{"type": "Text", "value": "Title", "options": {"x": 0.5, "y": 0.5, "w": 9, "h": 1, "shape": "roundRect"}},
+					{"type": "Shape", "value": "rect", "options": {"x": 0, "y": 5, "w": 10, "h": 0.625}},
+					{"type": "Table", "value": [["A", "B"]]},
+					{"type": "Chart", "value": "bar", "chatData": [{"name": "Sales", "labels": ["Q1", "Q2"], "values": [1, 2]}]}
+				]}],
+				"excel": {"columnLabels": ["A", "B"], "data": [[1, 2]]}
+			}`,
+		},
+		{
+			name: "shape aliases",
+			raw:  `{"slides": [{"data": [{"type": "Text", "value": "Urgent", "options": {"shape": "oval"}}, {"type": "Shape", "value": "Shape.roundRect"}]}]}`,
+		},
+		{
+			name:     "slide without elements",
+			raw:      `{"slides": [{"data": []}]}`,
+			problems: []string{"slide 1 has no elements"},
+		},
+		{
+			name:     "unknown type",
+			raw:      `{"slides": [{"data": [{"type": "Video", "value": "clip.mp4"}]}]}`,
+			problems: []string{`slide 1, element 1 (Video): unknown type "Video"`},
+		},
+		{
+			name:     "unknown shape",
+			raw:      `{"slides": [{"data": [{"type": "Shape", "value": "blob"}, {"type": "Text", "value": "Hi", "options": {"shape": "star3"}}]}]}`,
+			problems: []string{`slide 1, element 1 (Shape): unknown shape "blob"`, `slide 1, element 2 (Text): unknown shape "star3" in options.shape`},
+		},
+		{
+			name: "chart",
+			raw:  `{"slides": [{"data": [{"type": "Chart", "value": "histogram", "chatData": [{"name": "Sales", "labels": ["Q1"], "values": [1, 2]}]}, {"type": "Chart", "value": "pie"}]}]}`,
+			problems: []string{
+				`slide 1, element 1 (Chart): unknown chart "histogram"`,
+				`slide 1, element 1 (Chart): series "Sales" has 1 labels but 2 values`,
+				`slide 1, element 2 (Chart): chart has no chatData`,
+			},
+		},
+		{
+			name:     "table value",
+			raw:      `{"slides": [{"data": [{"type": "Table", "value": "A, B"}, {"type": "Text", "value": [["A"]]}]}]}`,
+			problems: []string{"slide 1, element 1 (Table): value must be an array of table rows", "slide 1, element 2 (Text): value must be a string"},
+		},
+		{
+			name: "bounds",
+			raw:  `{"slides": [{"data": [{"type": "Text", "value": "Hi", "options": {"x": -1, "y": 5, "w": 0, "h": 1}}, {"type": "Text", "value": "Hi", "options": {"x": 6, "y": 0, "w": 4.005, "h": 6}}, {"type": "Text", "value": "Hi", "options": {"x": 6, "y": 0, "w": 5, "h": 1}}]}]}`,
+			problems: []string{
+				"slide 1, element 1 (Text): position (x: -1, y: 5) is negative",
+				"slide 1, element 1 (Text): size (w: 0, h: 1) must be positive",
+				"slide 1, element 1 (Text): y + h = 6 exceeds the slide height",
+				"slide 1, element 2 (Text): y + h = 6 exceeds the slide height",
+				"slide 1, element 3 (Text): x + w = 11 exceeds the slide width",
+			},
+		},
+		{
+			name:     "excel",
+			raw:      `{"excel": {"columnLabels": ["A", "B"], "rowLabels": ["only one"], "data": [[1, 2], [3]]}}`,
+			problems: []string{"excel: row 2 has 1 cells but there are 2 columns"},
+		},
+		{
+			name:     "named sheets",
+			raw:      `{"excel": {"Sales": {"columnLabels": ["A"], "data": [[1]]}, "Costs": {"data": [[1]]}}}`,
+			problems: []string{`excel sheet "Costs": columnLabels is empty`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := Decode(test.raw)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			problems := payload.Validate()
+			if len(problems) != len(test.problems) {
+				t.Fatalf("Validate() = %q, want %q", problems, test.problems)
+			}
+			for i, problem := range problems {
+				if !strings.HasPrefix(problem, test.problems[i]) {
+					t.Errorf("problem %d = %q, want %q", i+1, problem, test.problems[i])
+				}
+			}
+		})
+	}
+}
+
+func TestShapeName(t *testing.T) {
+	for name, want := range map[string]string{
+		"ellipse":         "ellipse",
+		"oval":            "ellipse",
+		"Shape.oval":      "ellipse",
+		"Shape.roundRect": "roundRect",
+		"blob":            "blob",
+	} {
+		if got := ShapeName(name); got != want {
+			t.Errorf("ShapeName(%q) = %q, want %q", name, got, want)
+		}
+		if IsShapeName(name) != (want != "blob") {
+			t.Errorf("IsShapeName(%q) = %v", name, IsShapeName(name))
+		}
+	}
+}
+
+// elementType matches the type of a slide element in the prompt examples
+var elementType = regexp.MustCompile(`"type":\s*"(Image|Shape|Table|Text|Chart)"`)
+
+// TestPromptExamples validates the slide elements the prompt shows the model,
+// so an answer that copies them is not sent back for repair
+func TestPromptExamples(t *testing.T) {
+	paths, err := filepath.Glob("../../prompts/partials/*.tmpl")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no prompt partials found: %v", err)
+	}
+	examples := 0
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text := string(content)
+		for _, match := range elementType.FindAllStringIndex(text, -1) {
+			start := strings.LastIndex(text[:match[0]], "{")
+			end, ok := scanObject(text[start:])
+			if !ok {
+				t.Errorf("%s: unterminated example at %d", path, start)
+				continue
+			}
+			raw := stripComments(text[start : start+end])
+
+			var element SlideData
+			if err := json.Unmarshal([]byte(raw), &element); err != nil {
+				t.Errorf("%s: invalid example %s: %v", path, raw, err)
+				continue
+			}
+			for _, problem := range element.validate() {
+				t.Errorf("%s: example %s: %s", path, raw, problem)
+			}
+			examples++
+		}
+	}
+	if examples == 0 {
+		t.Error("no slide elements found in the prompt examples")
+	}
+}
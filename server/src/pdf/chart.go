@@ -0,0 +1,367 @@
+package pdf
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/integems/report-agent/src/payload"
+)
+
+// defaultChartColors are used when the element has no chartColors, the
+// same as in the .pptx export
+var defaultChartColors = []string{"4472C4", "ED7D31", "A5A5A5", "FFC000", "5B9BD5", "70AD47"}
+
+var (
+	gridColor  = mustColor("D9D9D9")
+	axisColor  = mustColor("595959")
+	chartLabel = 8.0
+)
+
+// chartPlot is a chart being drawn
+type chartPlot struct {
+	p       *page
+	kind    string
+	series  []payload.ChartData
+	options payload.Options
+	colors  []rgb
+}
+
+func (c *chartPlot) color(index int) rgb {
+	return c.colors[index%len(c.colors)]
+}
+
+// chart draws a chart from the chatData series. Pie and doughnut charts
+// show the first series, every other kind is drawn as bars, lines or
+// filled areas over the categories of the first series.
+func chart(p *page, element payload.SlideData) {
+	if len(element.ChatData) == 0 {
+		return
+	}
+	c := &chartPlot{p: p, kind: element.Value.Text, series: element.ChatData, options: element.Options}
+	if values, ok := element.Options["chartColors"].([]any); ok {
+		for _, value := range values {
+			if text, ok := value.(string); ok {
+				if color, ok := hexColor(text); ok {
+					c.colors = append(c.colors, color)
+				}
+			}
+		}
+	}
+	if len(c.colors) == 0 {
+		for _, value := range defaultChartColors {
+			c.colors = append(c.colors, mustColor(value))
+		}
+	}
+
+	b := elementBox(element.Options, 3)
+	b = c.title(b)
+	var names []string
+	if c.kind == "pie" || c.kind == "doughnut" {
+		names = c.series[0].Labels
+	} else {
+		for _, series := range c.series {
+			names = append(names, series.Name)
+		}
+	}
+	b = c.legend(b, names)
+
+	switch c.kind {
+	case "pie", "doughnut":
+		c.pie(b)
+	default:
+		c.categories(b)
+	}
+}
+
+// title draws the chart title and returns the space left below it
+func (c *chartPlot) title(b box) box {
+	title, _ := c.options.String("title")
+	if title == "" && c.options.Bool("showTitle") {
+		title = c.series[0].Name
+	}
+	if title == "" {
+		return b
+	}
+	size := 14.0
+	if value, ok := c.options.Number("titleFontSize"); ok && value > 0 {
+		size = value
+	}
+	text := fitText(title, bold, size, b.w)
+	c.p.text(b.x+(b.w-textWidth(text, bold, size))/2, b.y+size, bold, size, black, text)
+	b.y += size * 1.6
+	b.h -= size * 1.6
+	return b
+}
+
+// legend draws the legend and returns the space left for the plot
+func (c *chartPlot) legend(b box, names []string) box {
+	if value, ok := c.options["showLegend"].(bool); (ok && !value) || len(names) == 0 {
+		return b
+	}
+	const swatch, gap = 7.0, 4.0
+	position := valueOr(c.options, "legendPos", "r")
+
+	if position == "b" || position == "t" {
+		total := 0.0
+		for _, name := range names {
+			total += swatch + gap + textWidth(name, regular, chartLabel) + 3*gap
+		}
+		x := b.x + math.Max((b.w-total)/2, 0)
+		y := b.y + b.h - chartLabel
+		if position == "t" {
+			y = b.y
+		}
+		for i, name := range names {
+			fill := c.color(i)
+			c.p.rect(x, y, swatch, swatch, &fill, nil, 0)
+			x += swatch + gap
+			x += c.p.text(x, y+swatch-0.5, regular, chartLabel, axisColor, name) + 3*gap
+		}
+		b.h -= chartLabel * 2
+		if position == "t" {
+			b.y += chartLabel * 2
+		}
+		return b
+	}
+
+	widest := 0.0
+	for _, name := range names {
+		widest = math.Max(widest, textWidth(name, regular, chartLabel))
+	}
+	width := math.Min(swatch+gap+widest, b.w/3)
+	x := b.x + b.w - width
+	if position == "l" {
+		x = b.x
+	}
+	y := b.y + math.Max((b.h-float64(len(names))*chartLabel*1.6)/2, 0)
+	if position == "tr" {
+		y = b.y
+	}
+	for i, name := range names {
+		fill := c.color(i)
+		c.p.rect(x, y, swatch, swatch, &fill, nil, 0)
+		c.p.text(x+swatch+gap, y+swatch-0.5, regular, chartLabel, axisColor, fitText(name, regular, chartLabel, width-swatch-gap))
+		y += chartLabel * 1.6
+	}
+	b.w -= width + 2*gap
+	if position == "l" {
+		b.x += width + 2*gap
+	}
+	return b
+}
+
+// pie draws the first series as slices, with a hole for doughnuts
+func (c *chartPlot) pie(b box) {
+	values := c.series[0].Values
+	total := 0.0
+	for _, value := range values {
+		total += math.Max(value, 0)
+	}
+	if total == 0 {
+		return
+	}
+	radius := math.Min(b.w, b.h) / 2
+	cx, cy := b.x+b.w/2, b.y+b.h/2
+
+	angle := -math.Pi / 2
+	for i, value := range values {
+		if value <= 0 {
+			continue
+		}
+		sweep := value / total * 2 * math.Pi
+		points := []float64{cx, cy}
+		steps := max(int(sweep/(math.Pi/36)), 1)
+		for step := 0; step <= steps; step++ {
+			a := angle + sweep*float64(step)/float64(steps)
+			points = append(points, cx+radius*math.Cos(a), cy+radius*math.Sin(a))
+		}
+		fill := c.color(i)
+		c.p.polygon(points, &fill, &white, 1)
+
+		if c.options.Bool("showValue") || c.options.Bool("showPercent") {
+			label := formatValue(value)
+			if c.options.Bool("showPercent") {
+				label = formatValue(math.Round(value/total*1000)/10) + "%"
+			}
+			middle := angle + sweep/2
+			lx, ly := cx+radius*0.65*math.Cos(middle), cy+radius*0.65*math.Sin(middle)
+			c.p.text(lx-textWidth(label, bold, chartLabel)/2, ly+chartLabel/3, bold, chartLabel, white, label)
+		}
+		angle += sweep
+	}
+	if c.kind == "doughnut" {
+		hole := radius * 0.5
+		c.p.ellipse(cx-hole, cy-hole, 2*hole, 2*hole, &white, nil, 0)
+	}
+}
+
+// categories draws bars, lines or areas against a value axis
+func (c *chartPlot) categories(b box) {
+	labels := c.series[0].Labels
+	count := 0
+	low, high := 0.0, 0.0
+	for _, series := range c.series {
+		count = max(count, len(series.Values))
+		for _, value := range series.Values {
+			low, high = math.Min(low, value), math.Max(high, value)
+		}
+	}
+	if count == 0 {
+		return
+	}
+	step := niceStep((high - low) / 5)
+	low, high = math.Floor(low/step)*step, math.Ceil(high/step)*step
+	if high == low {
+		high = low + step
+	}
+
+	horizontal := c.kind == "bar" && valueOr(c.options, "barDir", "col") == "bar"
+
+	// Leave room for the axis labels
+	valueLabelWidth := 0.0
+	for value := low; value <= high+step/2; value += step {
+		valueLabelWidth = math.Max(valueLabelWidth, textWidth(formatValue(value), regular, chartLabel))
+	}
+	categoryLabelWidth := 0.0
+	for _, label := range labels {
+		categoryLabelWidth = math.Max(categoryLabelWidth, textWidth(label, regular, chartLabel))
+	}
+	plot := b
+	if horizontal {
+		plot.x += math.Min(categoryLabelWidth, b.w/4) + 6
+		plot.w -= math.Min(categoryLabelWidth, b.w/4) + 6
+	} else {
+		plot.x += valueLabelWidth + 6
+		plot.w -= valueLabelWidth + 6
+	}
+	plot.h -= chartLabel * 2
+	if plot.w <= 0 || plot.h <= 0 {
+		return
+	}
+
+	// position maps a value to a distance along the value axis
+	position := func(value float64) float64 {
+		length := plot.h
+		if horizontal {
+			length = plot.w
+		}
+		return (value - low) / (high - low) * length
+	}
+
+	// Grid lines and value labels
+	for value := low; value <= high+step/2; value += step {
+		label := formatValue(value)
+		if horizontal {
+			x := plot.x + position(value)
+			c.p.line(x, plot.y, x, plot.y+plot.h, gridColor, 0.5)
+			c.p.text(x-textWidth(label, regular, chartLabel)/2, plot.y+plot.h+chartLabel*1.4, regular, chartLabel, axisColor, label)
+		} else {
+			y := plot.y + plot.h - position(value)
+			c.p.line(plot.x, y, plot.x+plot.w, y, gridColor, 0.5)
+			c.p.text(plot.x-6-textWidth(label, regular, chartLabel), y+chartLabel/3, regular, chartLabel, axisColor, label)
+		}
+	}
+
+	// Category labels
+	band := plot.w / float64(count)
+	if horizontal {
+		band = plot.h / float64(count)
+	}
+	for i := 0; i < count && i < len(labels); i++ {
+		if horizontal {
+			label := fitText(labels[i], regular, chartLabel, plot.x-b.x-6)
+			y := plot.y + band*(float64(i)+0.5)
+			c.p.text(plot.x-6-textWidth(label, regular, chartLabel), y+chartLabel/3, regular, chartLabel, axisColor, label)
+		} else {
+			label := fitText(labels[i], regular, chartLabel, band)
+			x := plot.x + band*(float64(i)+0.5)
+			c.p.text(x-textWidth(label, regular, chartLabel)/2, plot.y+plot.h+chartLabel*1.4, regular, chartLabel, axisColor, label)
+		}
+	}
+
+	zero := position(math.Max(low, 0))
+	switch c.kind {
+	case "line", "area", "radar", "scatter", "bubble":
+		for s := len(c.series) - 1; s >= 0; s-- {
+			series := c.series[s]
+			var points []float64
+			for i, value := range series.Values {
+				points = append(points, plot.x+band*(float64(i)+0.5), plot.y+plot.h-position(value))
+			}
+			color := c.color(s)
+			if c.kind == "area" && len(points) >= 4 {
+				area := append([]float64{points[0], plot.y + plot.h - zero}, points...)
+				area = append(area, points[len(points)-2], plot.y+plot.h-zero)
+				c.p.polygon(area, &color, nil, 0)
+				continue
+			}
+			if c.kind != "scatter" && c.kind != "bubble" {
+				c.p.polyline(points, color, 2)
+			}
+			for i := 0; i+1 < len(points); i += 2 {
+				c.p.ellipse(points[i]-2.5, points[i+1]-2.5, 5, 5, &color, nil, 0)
+			}
+			c.valueLabels(series, points)
+		}
+	default:
+		group := band * 0.75
+		barWidth := group / float64(len(c.series))
+		for s, series := range c.series {
+			color := c.color(s)
+			var labels []float64
+			for i, value := range series.Values {
+				start := band*float64(i) + (band-group)/2 + barWidth*float64(s)
+				from, to := math.Min(zero, position(value)), math.Max(zero, position(value))
+				if horizontal {
+					c.p.rect(plot.x+from, plot.y+start, to-from, barWidth, &color, nil, 0)
+					labels = append(labels, plot.x+to+textWidth(formatValue(value), regular, chartLabel)/2+2, plot.y+start+barWidth/2+chartLabel*0.35)
+				} else {
+					c.p.rect(plot.x+start, plot.y+plot.h-to, barWidth, to-from, &color, nil, 0)
+					labels = append(labels, plot.x+start+barWidth/2, plot.y+plot.h-to)
+				}
+			}
+			c.valueLabels(series, labels)
+		}
+	}
+
+	// Axis lines
+	if horizontal {
+		c.p.line(plot.x+zero, plot.y, plot.x+zero, plot.y+plot.h, axisColor, 0.75)
+	} else {
+		c.p.line(plot.x, plot.y+plot.h-zero, plot.x+plot.w, plot.y+plot.h-zero, axisColor, 0.75)
+	}
+}
+
+// valueLabels writes the values of a series above the given points when
+// showValue is set
+func (c *chartPlot) valueLabels(series payload.ChartData, points []float64) {
+	if !c.options.Bool("showValue") {
+		return
+	}
+	for i, value := range series.Values {
+		if 2*i+1 >= len(points) {
+			break
+		}
+		label := formatValue(value)
+		c.p.text(points[2*i]-textWidth(label, regular, chartLabel)/2, points[2*i+1]-3, regular, chartLabel, axisColor, label)
+	}
+}
+
+// niceStep rounds a raw axis step up to 1, 2 or 5 times a power of ten
+func niceStep(raw float64) float64 {
+	if raw <= 0 || math.IsNaN(raw) || math.IsInf(raw, 0) {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, factor := range []float64{1, 2, 5, 10} {
+		if raw <= factor*magnitude {
+			return factor * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatValue writes a number without float noise
+func formatValue(value float64) string {
+	return strconv.FormatFloat(math.Round(value*1e6)/1e6, 'f', -1, 64)
+}
@@ -0,0 +1,209 @@
+package pdf
+
+import (
+	"unicode"
+)
+
+// font is one of the standard PDF fonts, which every viewer has, so nothing
+// needs to be embedded
+type font int
+
+const (
+	regular font = iota
+	bold
+	italic
+	boldItalic
+	mono
+	symbol
+	fontCount
+)
+
+// baseFonts are the names of the fonts, in resource order
+var baseFonts = [fontCount]string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique", "Helvetica-BoldOblique", "Courier", "Symbol"}
+
+// styled returns the font with bold and italic applied
+func styled(isBold, isItalic bool) font {
+	switch {
+	case isBold && isItalic:
+		return boldItalic
+	case isBold:
+		return bold
+	case isItalic:
+		return italic
+	}
+	return regular
+}
+
+// Glyph widths of ASCII 32 to 126 in thousandths of the font size, from the
+// Adobe font metrics. The oblique variants share the widths.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// winAnsiHigh maps the characters of WinAnsiEncoding above 127 that differ
+// from Latin-1
+var winAnsiHigh = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// highWidths are the widths of common characters above 127
+var highWidths = map[byte]int{
+	0x80: 556, 0x85: 1000, 0x89: 1000, 0x91: 222, 0x92: 222, 0x93: 333, 0x94: 333, 0x95: 350,
+	0x96: 556, 0x97: 1000, 0x99: 1000, 0xA0: 278, 0xA9: 737, 0xAE: 737, 0xB0: 400, 0xB1: 584,
+	0xB7: 278, 0xD7: 584, 0xF7: 584, 0xBC: 834, 0xBD: 834, 0xBE: 834,
+}
+
+// latin1Base gives the unaccented letter of Latin-1 characters 0xC0 to 0xFF
+// so accented letters get the width of their base letter
+const latin1Base = "AAAAAAACEEEEIIIIDNOOOOO OUUUUYPsaaaaaaaceeeeiiiidnooooo ouuuuypy"
+
+// symbolCodes maps characters to the Symbol font encoding, with their widths
+var symbolCodes = map[rune]struct {
+	code  byte
+	width int
+}{
+	'α': {0x61, 631}, 'β': {0x62, 549}, 'χ': {0x63, 549}, 'δ': {0x64, 494}, 'ε': {0x65, 439},
+	'ϵ': {0x65, 439}, 'φ': {0x66, 521}, 'ϕ': {0x6A, 603}, 'γ': {0x67, 411}, 'η': {0x68, 603},
+	'ι': {0x69, 329}, 'κ': {0x6B, 549}, 'λ': {0x6C, 549}, 'μ': {0x6D, 576}, 'ν': {0x6E, 521},
+	'ο': {0x6F, 549}, 'π': {0x70, 549}, 'θ': {0x71, 521}, 'ρ': {0x72, 549}, 'σ': {0x73, 603},
+	'τ': {0x74, 439}, 'υ': {0x75, 576}, 'ϖ': {0x76, 713}, 'ω': {0x77, 686}, 'ξ': {0x78, 493},
+	'ψ': {0x79, 686}, 'ζ': {0x7A, 494}, 'ς': {0x56, 439}, 'ϑ': {0x4A, 631}, 'ϱ': {0x72, 549},
+	'Α': {0x41, 722}, 'Β': {0x42, 667}, 'Χ': {0x43, 722}, 'Δ': {0x44, 612}, 'Ε': {0x45, 611},
+	'Φ': {0x46, 763}, 'Γ': {0x47, 603}, 'Η': {0x48, 722}, 'Ι': {0x49, 333}, 'Κ': {0x4B, 722},
+	'Λ': {0x4C, 686}, 'Μ': {0x4D, 889}, 'Ν': {0x4E, 722}, 'Ο': {0x4F, 722}, 'Π': {0x50, 768},
+	'Θ': {0x51, 741}, 'Ρ': {0x52, 556}, 'Σ': {0x53, 592}, 'Τ': {0x54, 611}, 'Υ': {0x55, 690},
+	'Ω': {0x57, 768}, 'Ξ': {0x58, 645}, 'Ψ': {0x59, 795}, 'Ζ': {0x5A, 611},
+	'∀': {0x22, 713}, '∃': {0x24, 549}, '∋': {0x27, 439}, '∗': {0x2A, 500}, '−': {0x2D, 549},
+	'≅': {0x40, 549}, '∴': {0x5C, 863}, '⊥': {0x5E, 658}, '∼': {0x7E, 549}, '′': {0xA2, 247},
+	'≤': {0xA3, 549}, '∞': {0xA5, 713}, '↔': {0xAB, 1042}, '←': {0xAC, 987}, '↑': {0xAD, 603},
+	'→': {0xAE, 987}, '↓': {0xAF, 603}, '″': {0xB2, 411}, '≥': {0xB3, 549}, '∝': {0xB5, 713},
+	'∂': {0xB6, 494}, '∙': {0xB7, 460}, '≠': {0xB9, 549}, '≡': {0xBA, 549}, '≈': {0xBB, 549},
+	'⋯': {0xBC, 1000}, 'ℵ': {0xC0, 823}, 'ℑ': {0xC1, 686}, 'ℜ': {0xC2, 795}, '⊗': {0xC4, 768},
+	'⊕': {0xC5, 768}, '∅': {0xC6, 823}, '∩': {0xC7, 768}, '∪': {0xC8, 768}, '⊃': {0xC9, 713},
+	'⊇': {0xCA, 713}, '⊂': {0xCC, 713}, '⊆': {0xCD, 713}, '∈': {0xCE, 713}, '∉': {0xCF, 713},
+	'∠': {0xD0, 768}, '∇': {0xD1, 713}, '∏': {0xD5, 823}, '√': {0xD6, 549}, '⋅': {0xD7, 250},
+	'¬': {0xD8, 713}, '∧': {0xD9, 603}, '∨': {0xDA, 603}, '⇔': {0xDB, 1042}, '⇐': {0xDC, 987},
+	'⇒': {0xDE, 987}, '⟨': {0xE1, 329}, '∑': {0xE5, 713}, '⟩': {0xF1, 329}, '∫': {0xF2, 274},
+	'⟹': {0xDE, 987}, '⟺': {0xDB, 1042}, '⟶': {0xAE, 987}, '⟵': {0xAC, 987}, '∣': {0x7C, 200},
+	'∥': {0x7C, 200}, '∓': {0xB1, 549}, '∖': {0x5C, 500}, '⌈': {0xE9, 384}, '⌉': {0xF9, 384},
+	'⌊': {0xEB, 384}, '⌋': {0xFB, 384}, '↦': {0xAE, 987}, '⇌': {0xAB, 1042},
+}
+
+// symbolWidths are the widths of the Symbol font by code
+var symbolWidths [256]int
+
+func init() {
+	for _, glyph := range symbolCodes {
+		symbolWidths[glyph.code] = glyph.width
+	}
+}
+
+// encode converts a rune to a byte of the font's encoding. Characters the
+// standard fonts lack fall back to the Symbol font or a question mark.
+func encode(r rune, f font) (font, byte) {
+	if f == symbol {
+		f = regular
+	}
+	switch {
+	case r >= 32 && r < 127:
+		return f, byte(r)
+	case r >= 0xA0 && r <= 0xFF:
+		return f, byte(r)
+	case r == '\t':
+		return f, ' '
+	}
+	if code, ok := winAnsiHigh[r]; ok {
+		return f, code
+	}
+	if glyph, ok := symbolCodes[r]; ok {
+		return symbol, glyph.code
+	}
+	if letter, ok := plainLetter(r); ok {
+		return f, letter
+	}
+	switch r {
+	case '∘':
+		return f, 0xB0
+	case '⋮', '⋱':
+		return f, 0x85
+	case '\u2002', '\u2003', '\u2009', '\u200a', '\u202f':
+		return f, ' '
+	}
+	return f, '?'
+}
+
+// letterlike maps the letterlike symbols used for number sets and script
+// letters to plain letters
+var letterlike = map[rune]byte{
+	'ℂ': 'C', 'ℍ': 'H', 'ℕ': 'N', 'ℙ': 'P', 'ℚ': 'Q', 'ℝ': 'R', 'ℤ': 'Z', 'ℬ': 'B', 'ℰ': 'E',
+	'ℱ': 'F', 'ℋ': 'H', 'ℐ': 'I', 'ℒ': 'L', 'ℳ': 'M', 'ℛ': 'R', 'ℯ': 'e', 'ℊ': 'g', 'ℴ': 'o',
+}
+
+// plainLetter maps the styled mathematical letters and digits of \mathbb,
+// \mathcal and the like to plain ones
+func plainLetter(r rune) (byte, bool) {
+	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	switch {
+	case r >= 0x1D400 && r <= 0x1D6A3:
+		return letters[(r-0x1D400)%52], true
+	case r >= 0x1D7CE && r <= 0x1D7FF:
+		return byte('0' + (r-0x1D7CE)%10), true
+	}
+	letter, ok := letterlike[r]
+	return letter, ok
+}
+
+// skip reports whether a rune is dropped from the output, such as combining
+// accents and zero width characters
+func skip(r rune) bool {
+	return unicode.Is(unicode.Mn, r) || r == '\u200b' || r == '\ufeff' || r == '\r' || r == '\n'
+}
+
+// width returns the width of an encoded character in thousandths of the
+// font size
+func width(f font, code byte) int {
+	switch f {
+	case mono:
+		return 600
+	case symbol:
+		if w := symbolWidths[code]; w > 0 {
+			return w
+		}
+		return 500
+	}
+
+	table := &helveticaWidths
+	if f == bold || f == boldItalic {
+		table = &helveticaBoldWidths
+	}
+	switch {
+	case code >= 32 && code < 127:
+		return table[code-32]
+	case code >= 0xC0:
+		if base := latin1Base[code-0xC0]; base != ' ' {
+			return table[base-32]
+		}
+		return 584
+	}
+	if w, ok := highWidths[code]; ok {
+		return w
+	}
+	return 556
+}
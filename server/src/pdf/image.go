@@ -0,0 +1,86 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+var errNoImageLoader = errors.New("image loading is not configured")
+
+// pdfImage is an image XObject. Transparent images carry their alpha
+// channel as a soft mask.
+type pdfImage struct {
+	width, height int
+	colorSpace    string
+	filter        string // empty for raw samples compressed on write
+	decode        string
+	data          []byte
+	mask          *pdfImage
+}
+
+func (img *pdfImage) dictionary(extra string) string {
+	return fmt.Sprintf(" /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8%s%s",
+		img.width, img.height, img.colorSpace, img.decode, extra)
+}
+
+// decodeImage converts PNG, JPEG or GIF data. JPEG files are embedded as
+// they are, other formats are decoded to RGB samples.
+func decodeImage(data []byte) (*pdfImage, error) {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		img := &pdfImage{width: config.Width, height: config.Height, colorSpace: "DeviceRGB", filter: "/DCTDecode", data: data}
+		switch config.ColorModel {
+		case color.GrayModel:
+			img.colorSpace = "DeviceGray"
+		case color.CMYKModel:
+			// Adobe writes CMYK JPEG files inverted
+			img.colorSpace = "DeviceCMYK"
+			img.decode = " /Decode [1 0 1 0 1 0 1 0]"
+		}
+		return img, nil
+	case "image/png", "image/gif":
+	default:
+		return nil, fmt.Errorf("unsupported image format")
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := decoded.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+
+	samples := make([]byte, 0, width*height*3)
+	alpha := make([]byte, 0, width*height)
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			samples = append(samples, pixel.R, pixel.G, pixel.B)
+			alpha = append(alpha, pixel.A)
+			if pixel.A != 0xFF {
+				opaque = false
+			}
+		}
+	}
+
+	img := &pdfImage{width: width, height: height, colorSpace: "DeviceRGB", data: samples}
+	if !opaque {
+		img.mask = &pdfImage{width: width, height: height, colorSpace: "DeviceGray", data: alpha}
+	}
+	return img, nil
+}
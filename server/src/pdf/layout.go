@@ -0,0 +1,350 @@
+package pdf
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/integems/report-agent/src/latex"
+	"github.com/integems/report-agent/src/markdown"
+)
+
+// Typography of documents
+const (
+	bodySize      = 11
+	bodyLeading   = 1.4
+	paragraphGap  = 6
+	listIndent    = 20
+	quoteIndent   = 14
+	codeSize      = 9
+	codePadding   = 6
+	cellPadding   = 4
+	pixelsToPoint = 0.75 // images are sized at 96 dpi
+)
+
+var (
+	textColor    = mustColor("1F1F1F")
+	headingColor = mustColor("2F5496")
+	linkColor    = mustColor("0563C1")
+	quoteColor   = mustColor("404040")
+	ruleColor    = mustColor("BFBFBF")
+	codeFill     = mustColor("F2F2F2")
+	headerFill   = mustColor("D9E2F3")
+)
+
+// headingSizes are the font sizes of heading levels 1 to 6
+var headingSizes = [6]float64{20, 16, 13.5, 12, 11, 11}
+
+// marker is the bullet or number of a list item, drawn next to the first
+// line of the item
+type marker struct {
+	text  string
+	x     float64
+	width float64
+}
+
+// layout flows blocks down the pages of a document
+type layout struct {
+	ctx     context.Context
+	d       *document
+	page    *page
+	y       float64
+	base    style
+	marker  *marker
+	images  map[string]*pdfImage
+	options Options
+}
+
+func newLayout(ctx context.Context, d *document) *layout {
+	l := &layout{
+		ctx:     ctx,
+		d:       d,
+		base:    style{font: regular, size: bodySize, color: textColor},
+		images:  map[string]*pdfImage{},
+		options: d.options,
+	}
+	l.newPage()
+	return l
+}
+
+func (l *layout) newPage() {
+	l.page = l.d.addPage(l.options.Width, l.options.Height)
+	l.y = l.options.Margins.Top
+}
+
+func (l *layout) bottom() float64 {
+	return l.options.Height - l.options.Margins.Bottom
+}
+
+// fit starts a new page unless height fits below the current position.
+// Content taller than a page is placed at the top of a new one.
+func (l *layout) fit(height float64) {
+	if l.y+height > l.bottom() && l.y > l.options.Margins.Top+0.5 {
+		l.newPage()
+	}
+}
+
+// drawMarker draws the pending list marker next to content starting at top
+func (l *layout) drawMarker(top, leading float64) {
+	if l.marker == nil {
+		return
+	}
+	m := l.marker
+	l.marker = nil
+	line := wrap([]span{{text: m.text, style: l.base}}, math.Inf(1), l.base.size)[0]
+	line.draw(l.page, m.x, top, m.width, leading, alignRight)
+}
+
+func (l *layout) blocks(blocks []markdown.Block, x, width float64, base style) {
+	for _, block := range blocks {
+		l.block(block, x, width, base)
+	}
+}
+
+func (l *layout) block(block markdown.Block, x, width float64, base style) {
+	switch block.Kind {
+	case markdown.Paragraph:
+		l.paragraph(block.Inlines, x, width, base, alignLeft)
+		l.y += paragraphGap
+
+	case markdown.Heading:
+		level := min(max(block.Level, 1), 6)
+		heading := base
+		heading.size = headingSizes[level-1]
+		heading.color = headingColor
+		heading.font = bold
+		if level == 6 {
+			heading.font = boldItalic
+		}
+		if l.y > l.options.Margins.Top+0.5 {
+			l.y += heading.size * 0.6
+		}
+		// Keep the heading with the first lines of what follows
+		_, height := textBlock(l.spans(block.Inlines, heading), width, heading.size, 1.25)
+		l.fit(height + 3*base.size*bodyLeading)
+		l.lines(l.spans(block.Inlines, heading), x, width, heading.size, 1.25, alignLeft)
+		l.y += paragraphGap / 2
+
+	case markdown.List:
+		l.list(block, x, width, base)
+
+	case markdown.Table:
+		l.table(block, x, width, base)
+
+	case markdown.Code:
+		l.code(block.Text, x, width)
+
+	case markdown.Math:
+		spans := mathSpans(latex.Parse(block.Text), base)
+		l.lines(spans, x, width, base.size, bodyLeading, alignCenter)
+		l.y += paragraphGap
+
+	case markdown.Quote:
+		quote := base
+		quote.color = quoteColor
+		quote.font = styled(base.font == bold || base.font == boldItalic, true)
+		l.drawMarker(l.y, bodyLeading)
+		startPage, startY := len(l.d.pages)-1, l.y
+		l.blocks(block.Children, x+quoteIndent, width-quoteIndent, quote)
+		l.bar(startPage, startY, x+3)
+
+	case markdown.Rule:
+		l.fit(paragraphGap * 2)
+		l.drawMarker(l.y, bodyLeading)
+		l.page.line(x, l.y+paragraphGap, x+width, l.y+paragraphGap, ruleColor, 0.75)
+		l.y += paragraphGap * 2
+	}
+}
+
+// paragraph lays out inline content. Images are placed between the lines
+// of text around them.
+func (l *layout) paragraph(inlines []markdown.Inline, x, width float64, base style, align alignment) {
+	start := 0
+	for i, inline := range inlines {
+		if inline.Kind != markdown.Image {
+			continue
+		}
+		if text := inlines[start:i]; len(strings.TrimSpace(markdown.PlainText(text))) > 0 {
+			l.lines(l.spans(text, base), x, width, base.size, bodyLeading, align)
+		}
+		l.image(inline, x, width, base)
+		start = i + 1
+	}
+	if rest := inlines[start:]; start == 0 || len(strings.TrimSpace(markdown.PlainText(rest))) > 0 {
+		l.lines(l.spans(rest, base), x, width, base.size, bodyLeading, align)
+	}
+}
+
+// lines wraps spans and draws the lines, breaking pages between them
+func (l *layout) lines(spans []span, x, width, size, leading float64, align alignment) {
+	for _, line := range wrap(spans, width, size) {
+		height := line.height(leading)
+		l.fit(height)
+		l.drawMarker(l.y, leading)
+		line.draw(l.page, x, l.y, width, leading, align)
+		l.y += height
+	}
+}
+
+// spans converts inline content to styled text
+func (l *layout) spans(inlines []markdown.Inline, base style) []span {
+	baseBold := base.font == bold || base.font == boldItalic
+	baseItalic := base.font == italic || base.font == boldItalic
+	var spans []span
+	for _, inline := range inlines {
+		switch inline.Kind {
+		case markdown.Text:
+			s := base
+			s.font = styled(baseBold || inline.Style&markdown.Bold != 0, baseItalic || inline.Style&markdown.Italic != 0)
+			if inline.Style&markdown.CodeSpan != 0 {
+				s.font = mono
+				s.size = base.size * 0.92
+			}
+			s.strike = inline.Style&markdown.Strike != 0
+			if target := linkTarget(inline.URL); target != "" {
+				s.link = target
+				s.color = linkColor
+			}
+			spans = append(spans, span{text: inline.Text, style: s})
+		case markdown.InlineMath:
+			spans = append(spans, mathSpans(latex.Parse(inline.Text), base)...)
+		case markdown.LineBreak:
+			spans = append(spans, span{text: "\n", style: base})
+		case markdown.Image:
+			spans = append(spans, span{text: "[" + strings.TrimSpace("Image "+inline.Text) + "]", style: style{font: styled(baseBold, true), size: base.size, color: base.color}})
+		}
+	}
+	return spans
+}
+
+// linkTarget returns the target of links a viewer can open
+func linkTarget(url string) string {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "mailto:") {
+		return url
+	}
+	return ""
+}
+
+// list lays out list items with their bullet or number in the indent
+func (l *layout) list(block markdown.Block, x, width float64, base style) {
+	// An item that starts with a nested list keeps its own marker
+	l.drawMarker(l.y, bodyLeading)
+	number := max(block.Start, 1)
+	for _, item := range block.Items {
+		text := "•"
+		if block.Ordered {
+			text = strconv.Itoa(number) + "."
+			number++
+		}
+		l.marker = &marker{text: text, x: x, width: listIndent - 6}
+		if len(item) == 0 {
+			l.lines([]span{{text: "", style: base}}, x+listIndent, width-listIndent, base.size, bodyLeading, alignLeft)
+		}
+		for _, child := range item {
+			if child.Kind != markdown.Paragraph {
+				l.block(child, x+listIndent, width-listIndent, base)
+				continue
+			}
+			// Paragraphs of an item are closer together than in the body
+			l.paragraph(child.Inlines, x+listIndent, width-listIndent, base, alignLeft)
+			l.y += paragraphGap / 2
+		}
+		l.marker = nil
+	}
+	l.y += paragraphGap / 2
+}
+
+// bar draws the left border of a quote from where it started to the
+// current position, across page breaks
+func (l *layout) bar(startPage int, startY, x float64) {
+	end := l.y - paragraphGap
+	for index := startPage; index < len(l.d.pages); index++ {
+		p := l.d.pages[index]
+		top, bottom := l.options.Margins.Top, l.bottom()
+		if index == startPage {
+			top = startY
+		}
+		if index == len(l.d.pages)-1 {
+			bottom = end
+		}
+		if bottom > top {
+			p.line(x, top, x, bottom, ruleColor, 2)
+		}
+	}
+}
+
+// code lays out a code block in a monospaced font, keeping its spacing and
+// breaking long lines
+func (l *layout) code(text string, x, width float64) {
+	perLine := max(int((width-2*codePadding)/(codeSize*0.6)), 1)
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n") {
+		runes := []rune(line)
+		for len(runes) > perLine {
+			lines = append(lines, string(runes[:perLine]))
+			runes = runes[perLine:]
+		}
+		lines = append(lines, string(runes))
+	}
+
+	height := codeSize * 1.35
+	l.fit(height + 2*codePadding)
+	l.drawMarker(l.y, bodyLeading)
+	l.page.rect(x, l.y, width, codePadding, &codeFill, nil, 0)
+	l.y += codePadding
+	for _, line := range lines {
+		if l.y+height > l.bottom() {
+			l.newPage()
+		}
+		l.page.rect(x, l.y, width, height, &codeFill, nil, 0)
+		l.page.text(x+codePadding, l.y+codeSize, mono, codeSize, textColor, line)
+		l.y += height
+	}
+	l.page.rect(x, l.y, width, codePadding, &codeFill, nil, 0)
+	l.y += codePadding + paragraphGap
+}
+
+// image draws a picture scaled to the width, or its alt text when it
+// cannot be loaded
+func (l *layout) image(inline markdown.Inline, x, width float64, base style) {
+	img, err := l.loadImage(inline.URL)
+	if err != nil {
+		log.Printf("Failed to load image %s: %v", inline.URL, err)
+		l.lines(l.spans([]markdown.Inline{inline}, base), x, width, base.size, bodyLeading, alignLeft)
+		return
+	}
+
+	w, h := float64(img.width)*pixelsToPoint, float64(img.height)*pixelsToPoint
+	if w > width {
+		h, w = h*width/w, width
+	}
+	if maxHeight := l.bottom() - l.options.Margins.Top; h > maxHeight {
+		w, h = w*maxHeight/h, maxHeight
+	}
+	l.fit(h)
+	l.drawMarker(l.y, bodyLeading)
+	l.page.image(img, x, l.y, w, h)
+	l.y += h + paragraphGap/2
+}
+
+// loadImage fetches and decodes an image once per document
+func (l *layout) loadImage(src string) (*pdfImage, error) {
+	if img, ok := l.images[src]; ok {
+		return img, nil
+	}
+	if l.options.LoadImage == nil {
+		return nil, errNoImageLoader
+	}
+	data, err := l.options.LoadImage(l.ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	l.images[src] = img
+	return img, nil
+}
@@ -0,0 +1,200 @@
+package pdf
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/integems/report-agent/src/latex"
+)
+
+// scriptScale is the size of subscripts and superscripts relative to
+// their base
+const scriptScale = 0.7
+
+// relations are padded with spaces so formulas read like typeset ones
+const relations = "=<>≤≥≠≈≡∼≅→←↔⇒⇐⇔∈∉⊂⊃⊆⊇∝"
+
+// accentMarks are drawn after their base as superscripts since the
+// standard fonts have no combining accents
+var accentMarks = map[string]string{
+	"̂": "^", "̃": "~", "̅": "¯", "⃗": "→", "̇": "·", "̈": "¨", "́": "´", "̀": "`", "̌": "ˇ", "̆": "˘",
+}
+
+// mathSpans lays out a formula as text, with scripts raised or lowered.
+// Fractions are written inline as a/b.
+func mathSpans(nodes []latex.Node, base style) []span {
+	var spans []span
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[i]
+		spans = append(spans, mathNode(node, base)...)
+		if node.Kind == latex.Function || node.Kind == latex.Operator ||
+			(node.Kind == latex.Scripts && len(node.Body) == 1 && node.Body[0].Kind == latex.Function) {
+			// A function name is followed by a thin space before its argument
+			if i+1 < len(nodes) {
+				spans = append(spans, span{text: " ", style: base})
+			}
+		}
+	}
+	return spans
+}
+
+func mathNode(node latex.Node, base style) []span {
+	switch node.Kind {
+	case latex.Run:
+		return mathRun(node.Text, node.Bold, base)
+	case latex.Plain:
+		s := base
+		s.font = styled(node.Bold || base.font == bold || base.font == boldItalic, false)
+		return []span{{text: node.Text, style: s}}
+	case latex.Group:
+		return mathSpans(node.Body, base)
+	case latex.Fraction:
+		if node.NoBar {
+			spans := mathSpans(node.Upper, base)
+			spans = append(spans, span{text: ", ", style: upright(base)})
+			return append(spans, mathSpans(node.Lower, base)...)
+		}
+		spans := parenthesized(node.Upper, base)
+		spans = append(spans, span{text: "/", style: upright(base)})
+		return append(spans, parenthesized(node.Lower, base)...)
+	case latex.Root:
+		var spans []span
+		if len(node.Upper) > 0 {
+			spans = append(spans, mathSpans(node.Upper, script(base, true))...)
+		}
+		spans = append(spans, span{text: "√", style: upright(base)})
+		return append(spans, parenthesized(node.Body, base)...)
+	case latex.Scripts:
+		var spans []span
+		if len(node.Body) == 1 && node.Body[0].Kind == latex.Function {
+			spans = append(spans, span{text: node.Body[0].Text, style: upright(base)})
+		} else {
+			spans = append(spans, mathSpans(node.Body, base)...)
+		}
+		if len(node.Lower) > 0 {
+			spans = append(spans, mathSpans(node.Lower, script(base, false))...)
+		}
+		if len(node.Upper) > 0 {
+			spans = append(spans, mathSpans(node.Upper, script(base, true))...)
+		}
+		return spans
+	case latex.Delimited:
+		spans := []span{{text: node.Open, style: upright(base)}}
+		spans = append(spans, mathSpans(node.Body, base)...)
+		return append(spans, span{text: node.Close, style: upright(base)})
+	case latex.Accent:
+		spans := mathSpans(node.Body, base)
+		if mark, ok := accentMarks[node.Text]; ok {
+			spans = append(spans, span{text: mark, style: script(base, true)})
+		}
+		return spans
+	case latex.Function:
+		return []span{{text: node.Text, style: upright(base)}}
+	case latex.Operator:
+		spans := []span{{text: node.Text, style: upright(base)}}
+		if len(node.Lower) > 0 {
+			spans = append(spans, mathSpans(node.Lower, script(base, false))...)
+		}
+		if len(node.Upper) > 0 {
+			spans = append(spans, mathSpans(node.Upper, script(base, true))...)
+		}
+		if len(node.Body) > 0 {
+			spans = append(spans, span{text: " ", style: base})
+			spans = append(spans, mathSpans(node.Body, base)...)
+		}
+		return spans
+	case latex.Matrix:
+		// Rows are separated by semicolons, cells by commas
+		spans := []span{{text: node.Open, style: upright(base)}}
+		for i, row := range node.Rows {
+			if i > 0 {
+				spans = append(spans, span{text: "; ", style: upright(base)})
+			}
+			for j, cell := range row {
+				if j > 0 {
+					spans = append(spans, span{text: ", ", style: upright(base)})
+				}
+				spans = append(spans, mathSpans(cell, base)...)
+			}
+		}
+		return append(spans, span{text: node.Close, style: upright(base)})
+	}
+	return nil
+}
+
+// mathRun sets letters in italics and pads relations with spaces
+func mathRun(text string, isBold bool, base style) []span {
+	isBold = isBold || base.font == bold || base.font == boldItalic
+	var spans []span
+	for _, r := range text {
+		s := base
+		s.font = styled(isBold, unicode.IsLetter(r) && r < 0x370)
+		piece := string(r)
+		if strings.ContainsRune(relations, r) {
+			piece = " " + piece + " "
+		}
+		if n := len(spans); n > 0 && spans[n-1].style == s {
+			spans[n-1].text += piece
+			continue
+		}
+		spans = append(spans, span{text: piece, style: s})
+	}
+	return spans
+}
+
+// parenthesized wraps compound terms in parentheses so an inline fraction
+// or root reads unambiguously
+func parenthesized(nodes []latex.Node, base style) []span {
+	spans := mathSpans(nodes, base)
+	if simple(nodes) {
+		return spans
+	}
+	spans = append([]span{{text: "(", style: upright(base)}}, spans...)
+	return append(spans, span{text: ")", style: upright(base)})
+}
+
+// simple reports whether nodes form a single symbol, number or bracketed
+// term
+func simple(nodes []latex.Node) bool {
+	if len(nodes) != 1 {
+		return len(nodes) == 0
+	}
+	node := nodes[0]
+	switch node.Kind {
+	case latex.Run:
+		text := []rune(node.Text)
+		if len(text) == 1 {
+			return true
+		}
+		for _, r := range text {
+			if !unicode.IsDigit(r) && r != '.' {
+				return false
+			}
+		}
+		return true
+	case latex.Group:
+		return simple(node.Body)
+	case latex.Delimited, latex.Plain, latex.Matrix:
+		return true
+	case latex.Scripts:
+		return simple(node.Body)
+	}
+	return false
+}
+
+func upright(base style) style {
+	base.font = styled(base.font == bold || base.font == boldItalic, false)
+	return base
+}
+
+// script returns the style of a superscript or subscript of base
+func script(base style, superscript bool) style {
+	shifted := base
+	shifted.size = base.size * scriptScale
+	if superscript {
+		shifted.rise = base.rise + base.size*0.35
+	} else {
+		shifted.rise = base.rise - base.size*0.15
+	}
+	return shifted
+}
@@ -0,0 +1,165 @@
+// Package pdf renders the answers of the model as PDF files without
+// external libraries: markdown with headings, lists, tables, code, quotes,
+// images and LaTeX formulas laid out on pages, or a slide deck with one
+// 16:9 page per slide. Text uses the standard PDF fonts so nothing needs to
+// be embedded; characters outside Latin-1 are taken from the Symbol font
+// where it has them.
+package pdf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/integems/report-agent/src/markdown"
+	"github.com/integems/report-agent/src/payload"
+)
+
+// ContentType is the MIME type of a PDF file
+const ContentType = "application/pdf"
+
+// pointsPerInch converts inches to PDF units
+const pointsPerInch = 72
+
+// Margins are the space around the text of a page, in points. The header
+// and footer are drawn inside the top and bottom margins.
+type Margins struct {
+	Top, Right, Bottom, Left float64
+}
+
+// Options configure the rendering of a PDF file
+type Options struct {
+	Title  string
+	Author string
+	// Width and Height are the page size in points, A4 when zero. Slides
+	// always use 16:9 pages.
+	Width, Height float64
+	// Margins around the text, 20 mm on every side when zero. Slides have
+	// no margins.
+	Margins Margins
+	// Header is printed at the top of every page, nothing when empty
+	Header string
+	// PageNumbers prints "Page n of m" in the footer
+	PageNumbers bool
+	// Date is printed in the footer as the generation date unless zero
+	Date time.Time
+	// LoadImage fetches the image of an image element. Images that cannot be
+	// loaded are replaced by their alt text or a placeholder.
+	LoadImage func(ctx context.Context, src string) ([]byte, error)
+}
+
+// pageSizes are the supported paper sizes in points, portrait
+var pageSizes = map[string][2]float64{
+	"a3":     {841.89, 1190.55},
+	"a4":     {595.28, 841.89},
+	"a5":     {419.53, 595.28},
+	"letter": {612, 792},
+	"legal":  {612, 1008},
+}
+
+// PageSize returns the portrait size in points of a paper size such as A4
+// or Letter
+func PageSize(name string) (width, height float64, ok bool) {
+	size, ok := pageSizes[strings.ToLower(strings.TrimSpace(name))]
+	return size[0], size[1], ok
+}
+
+// Millimeters converts millimeters to points
+func Millimeters(mm float64) float64 {
+	return mm * pointsPerInch / 25.4
+}
+
+// withDefaults fills in the page size and margins
+func (o Options) withDefaults() Options {
+	if o.Width <= 0 || o.Height <= 0 {
+		o.Width, o.Height, _ = PageSize("A4")
+	}
+	if o.Margins == (Margins{}) {
+		margin := Millimeters(20)
+		o.Margins = Margins{margin, margin, margin, margin}
+	}
+	// Keep a usable text area whatever the request asked for
+	limit := func(value, total float64) float64 {
+		return min(max(value, 0), total/3)
+	}
+	o.Margins.Top = limit(o.Margins.Top, o.Height)
+	o.Margins.Bottom = limit(o.Margins.Bottom, o.Height)
+	o.Margins.Left = limit(o.Margins.Left, o.Width)
+	o.Margins.Right = limit(o.Margins.Right, o.Width)
+	return o
+}
+
+// Render writes markdown blocks as a PDF file
+func Render(ctx context.Context, w io.Writer, blocks []markdown.Block, options Options) error {
+	options = options.withDefaults()
+	d := &document{options: options}
+	l := newLayout(ctx, d)
+	l.blocks(blocks, options.Margins.Left, options.Width-options.Margins.Left-options.Margins.Right, l.base)
+
+	margins := options.Margins
+	for i, p := range d.pages {
+		d.decorate(p, i+1, margins, 9)
+	}
+	return d.write(w)
+}
+
+// RenderSlides writes slides as a PDF file with one 16:9 page per slide
+func RenderSlides(ctx context.Context, w io.Writer, slides []payload.SlideContent, options Options) error {
+	if len(slides) == 0 {
+		return fmt.Errorf("presentation has no slides")
+	}
+	d := &document{options: options}
+	r := &slideRenderer{ctx: ctx, options: options, images: map[string]*pdfImage{}}
+	for _, slide := range slides {
+		p := d.addPage(payload.SlideWidth*pointsPerInch, payload.SlideHeight*pointsPerInch)
+		r.render(p, slide)
+	}
+
+	// The header and footer go in the corners of the slide
+	margins := Margins{Top: 20, Right: 18, Bottom: 14, Left: 18}
+	for i, p := range d.pages {
+		d.decorate(p, i+1, margins, 7)
+	}
+	return d.write(w)
+}
+
+// decoration is the color of the header and footer
+var decoration = mustColor("7F7F7F")
+
+// decorate draws the header and footer of a page. The header is drawn in
+// the middle of the top margin, the date and page number in the middle of
+// the bottom margin.
+func (d *document) decorate(p *page, number int, margins Margins, size float64) {
+	options := d.options
+	left, right := margins.Left, p.width-margins.Right
+
+	if options.Header != "" {
+		baseline := margins.Top/2 + size*0.35
+		header := fitText(options.Header, regular, size, right-left)
+		p.text(left, baseline, regular, size, decoration, header)
+		p.line(left, baseline+size*0.6, right, baseline+size*0.6, decoration, 0.4)
+	}
+
+	baseline := p.height - margins.Bottom/2 + size*0.35
+	if !options.Date.IsZero() {
+		p.text(left, baseline, regular, size, decoration, "Generated "+options.Date.Format("2 January 2006"))
+	}
+	if options.PageNumbers {
+		label := fmt.Sprintf("Page %d of %d", number, len(d.pages))
+		p.text(right-textWidth(label, regular, size), baseline, regular, size, decoration, label)
+	}
+}
+
+// fitText shortens text with an ellipsis to fit a width
+func fitText(text string, f font, size, width float64) string {
+	if textWidth(text, f, size) <= width+0.01 {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes)+"…", f, size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,443 @@
+package pdf
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/integems/report-agent/src/payload"
+)
+
+// slideRenderer draws slide elements the way the .pptx export places them
+type slideRenderer struct {
+	ctx     context.Context
+	options Options
+	images  map[string]*pdfImage
+}
+
+// box is the position and size of an element, in points
+type box struct {
+	x, y, w, h float64
+}
+
+// dimension reads a position or size in inches, or as a percentage of the
+// slide such as "50%", and returns it in points
+func dimension(options payload.Options, key string, total float64) (float64, bool) {
+	if value, ok := options.Number(key); ok {
+		return value * pointsPerInch, true
+	}
+	if value, ok := options.String(key); ok && strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err == nil {
+			return total * pointsPerInch * percent / 100, true
+		}
+	}
+	return 0, false
+}
+
+// elementBox reads x, y, w and h with the defaults of the .pptx export
+func elementBox(options payload.Options, defaultHeight float64) box {
+	b := box{x: pointsPerInch, y: pointsPerInch, h: defaultHeight * pointsPerInch}
+	if value, ok := dimension(options, "x", payload.SlideWidth); ok {
+		b.x = value
+	}
+	if value, ok := dimension(options, "y", payload.SlideHeight); ok {
+		b.y = value
+	}
+	b.w = math.Max(payload.SlideWidth*pointsPerInch-b.x-pointsPerInch/2, pointsPerInch)
+	if value, ok := dimension(options, "w", payload.SlideWidth); ok && value > 0 {
+		b.w = value
+	}
+	if value, ok := dimension(options, "h", payload.SlideHeight); ok && value > 0 {
+		b.h = value
+	}
+	return b
+}
+
+// optionColor reads a color given either directly or as {"color": ...}
+func optionColor(options payload.Options, key string) (rgb, bool) {
+	if value, ok := options.String(key); ok {
+		return hexColor(value)
+	}
+	if object := options.Object(key); object != nil {
+		if value, ok := object.String("color"); ok {
+			return hexColor(value)
+		}
+	}
+	return rgb{}, false
+}
+
+func valueOr(options payload.Options, key, fallback string) string {
+	if value, ok := options.String(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func (r *slideRenderer) render(p *page, slide payload.SlideContent) {
+	for _, element := range slide.Data {
+		switch element.Type {
+		case payload.ContentText:
+			shape, _ := element.Options.String("shape")
+			b := elementBox(element.Options, 1)
+			r.shape(p, shape, b, element.Options)
+			textBox(p, element.Value.Text, b, element.Options)
+		case payload.ContentShape:
+			b := elementBox(element.Options, 1)
+			r.shape(p, element.Value.Text, b, element.Options)
+			text, _ := element.Options.String("text")
+			textBox(p, text, b, element.Options)
+		case payload.ContentTable:
+			r.table(p, element)
+		case payload.ContentChart:
+			chart(p, element)
+		case payload.ContentImage:
+			r.image(p, element)
+		default:
+			log.Printf("Skipping slide element of unknown type %q", element.Type)
+		}
+	}
+}
+
+// lineStyle reads the outline of an element given as line: {color, width}
+func lineStyle(options payload.Options) (*rgb, float64) {
+	c, ok := optionColor(options, "line")
+	if !ok {
+		return nil, 0
+	}
+	width := 1.0
+	if value, ok := options.Object("line").Number("width"); ok {
+		width = value
+	} else if value, ok := options.Number("lineSize"); ok {
+		width = value
+	}
+	return &c, width
+}
+
+// shape draws the geometry of a shape. Shapes without a drawing of their
+// own are drawn as rectangles.
+func (r *slideRenderer) shape(p *page, name string, b box, options payload.Options) {
+	name = payload.ShapeName(name)
+	var fill *rgb
+	if c, ok := optionColor(options, "fill"); ok {
+		fill = &c
+	}
+	stroke, lineWidth := lineStyle(options)
+	if name == "line" {
+		c := black
+		if stroke != nil {
+			c = *stroke
+		}
+		if lineWidth == 0 {
+			lineWidth = 1
+		}
+		x1, x2 := b.x, b.x+b.w
+		if options.Bool("flipH") {
+			x1, x2 = x2, x1
+		}
+		p.line(x1, b.y, x2, b.y+b.h, c, lineWidth)
+		return
+	}
+	if fill == nil && stroke == nil {
+		return
+	}
+
+	x, y, w, h := b.x, b.y, b.w, b.h
+	switch name {
+	case "ellipse":
+		p.ellipse(x, y, w, h, fill, stroke, lineWidth)
+	case "roundRect":
+		radius := math.Min(w, h) * 0.1667
+		if value, ok := options.Number("rectRadius"); ok {
+			radius = value * pointsPerInch
+		}
+		p.roundRect(x, y, w, h, radius, fill, stroke, lineWidth)
+	case "triangle":
+		p.polygon([]float64{x + w/2, y, x + w, y + h, x, y + h}, fill, stroke, lineWidth)
+	case "rtTriangle":
+		p.polygon([]float64{x, y, x + w, y + h, x, y + h}, fill, stroke, lineWidth)
+	case "diamond":
+		p.polygon([]float64{x + w/2, y, x + w, y + h/2, x + w/2, y + h, x, y + h/2}, fill, stroke, lineWidth)
+	case "hexagon":
+		d := math.Min(w/4, h/2)
+		p.polygon([]float64{x + d, y, x + w - d, y, x + w, y + h/2, x + w - d, y + h, x + d, y + h, x, y + h/2}, fill, stroke, lineWidth)
+	case "parallelogram":
+		d := math.Min(w/4, h)
+		p.polygon([]float64{x + d, y, x + w, y, x + w - d, y + h, x, y + h}, fill, stroke, lineWidth)
+	case "trapezoid":
+		d := math.Min(w/4, h)
+		p.polygon([]float64{x + d, y, x + w - d, y, x + w, y + h, x, y + h}, fill, stroke, lineWidth)
+	case "chevron":
+		d := math.Min(w/2, h/2)
+		p.polygon([]float64{x, y, x + w - d, y, x + w, y + h/2, x + w - d, y + h, x, y + h, x + d, y + h/2}, fill, stroke, lineWidth)
+	case "homePlate", "pentagonArrow":
+		d := math.Min(w/2, h/2)
+		p.polygon([]float64{x, y, x + w - d, y, x + w, y + h/2, x + w - d, y + h, x, y + h}, fill, stroke, lineWidth)
+	case "rightArrow", "arrow":
+		d := math.Min(w/2, h/2)
+		p.polygon([]float64{x, y + h/4, x + w - d, y + h/4, x + w - d, y, x + w, y + h/2, x + w - d, y + h, x + w - d, y + h*3/4, x, y + h*3/4}, fill, stroke, lineWidth)
+	case "leftArrow":
+		d := math.Min(w/2, h/2)
+		p.polygon([]float64{x + w, y + h/4, x + d, y + h/4, x + d, y, x, y + h/2, x + d, y + h, x + d, y + h*3/4, x + w, y + h*3/4}, fill, stroke, lineWidth)
+	case "upArrow":
+		d := math.Min(w/2, h/2)
+		p.polygon([]float64{x + w/4, y + h, x + w/4, y + d, x, y + d, x + w/2, y, x + w, y + d, x + w*3/4, y + d, x + w*3/4, y + h}, fill, stroke, lineWidth)
+	case "downArrow":
+		d := math.Min(w/2, h/2)
+		p.polygon([]float64{x + w/4, y, x + w/4, y + h - d, x, y + h - d, x + w/2, y + h, x + w, y + h - d, x + w*3/4, y + h - d, x + w*3/4, y}, fill, stroke, lineWidth)
+	default:
+		p.rect(x, y, w, h, fill, stroke, lineWidth)
+	}
+}
+
+// textBox draws text with the pptxgenjs text options. Each line of the
+// text is a paragraph. Text that does not fit is shrunk, as PowerPoint
+// does for the .pptx export.
+func textBox(p *page, text string, b box, options payload.Options) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	s := style{font: styled(options.Bool("bold"), options.Bool("italic")), size: 18, color: black}
+	if size, ok := options.Number("fontSize"); ok && size > 0 {
+		s.size = size
+	}
+	if c, ok := optionColor(options, "color"); ok {
+		s.color = c
+	}
+	s.underline = options.Bool("underline") || options.Object("underline") != nil
+
+	insetX, insetY := 7.2, 3.6
+	if margin, ok := options.Number("margin"); ok {
+		// pptxgenjs takes points, the prompt examples use inches
+		insetX = margin
+		if margin <= 1 {
+			insetX = margin * pointsPerInch
+		}
+		insetY = insetX
+	}
+	leading := 1.2
+	if spacing, ok := options.Number("lineSpacingMultiple"); ok && spacing > 0 {
+		leading *= spacing
+	} else if spacing, ok := options.Number("lineSpacing"); ok && spacing > 0 && spacing <= 5 {
+		leading *= spacing
+	}
+	align := map[string]alignment{"center": alignCenter, "right": alignRight}[valueOr(options, "align", "")]
+	bullet := options.Bool("bullet") || options.Object("bullet") != nil
+
+	width := b.w - 2*insetX
+	layoutText := func(s style) ([][]line, float64) {
+		var paragraphs [][]line
+		total := 0.0
+		for _, paragraph := range strings.Split(text, "\n") {
+			w := width
+			if bullet {
+				w -= s.size
+			}
+			lines, height := textBlock([]span{{text: paragraph, style: s}}, w, s.size, leading)
+			paragraphs = append(paragraphs, lines)
+			total += height
+		}
+		return paragraphs, total
+	}
+	paragraphs, total := layoutText(s)
+	for total > b.h-2*insetY && s.size > 6 {
+		s.size = math.Max(s.size*0.9, 6)
+		paragraphs, total = layoutText(s)
+	}
+
+	top := b.y + insetY
+	switch valueOr(options, "valign", "") {
+	case "middle":
+		top = b.y + (b.h-total)/2
+	case "bottom":
+		top = b.y + b.h - insetY - total
+	}
+
+	p.clip(b.x, b.y, b.w, b.h)
+	for _, lines := range paragraphs {
+		x := b.x + insetX
+		w := width
+		if bullet {
+			marker := wrap([]span{{text: "•", style: s}}, math.Inf(1), s.size)[0]
+			marker.draw(p, x, top, s.size, leading, alignLeft)
+			x += s.size
+			w -= s.size
+		}
+		for _, l := range lines {
+			l.draw(p, x, top, w, leading, align)
+			top += l.height(leading)
+		}
+	}
+	p.unclip()
+}
+
+// table draws a table. Columns use colW when given and share the width of
+// the element otherwise; rows grow to fit their text.
+func (r *slideRenderer) table(p *page, element payload.SlideData) {
+	rows := element.Value.Rows
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return
+	}
+	options := element.Options
+	b := elementBox(options, 0.4*float64(len(rows)))
+	widths := sizes(options, "colW", columns, b.w)
+	heights := sizes(options, "rowH", len(rows), b.h)
+
+	y := b.y
+	for i, row := range rows {
+		// Cell options override the table options
+		cells := make([]payload.Options, columns)
+		height := heights[i]
+		for j := range cells {
+			cells[j] = payload.Options{}
+			for key, value := range options {
+				cells[j][key] = value
+			}
+			if j < len(row) {
+				for key, value := range row[j].Options {
+					cells[j][key] = value
+				}
+			}
+			text := ""
+			if j < len(row) {
+				text = row[j].Text
+			}
+			height = math.Max(height, cellHeight(text, widths[j], cells[j]))
+		}
+
+		x := b.x
+		for j := 0; j < columns; j++ {
+			cell := box{x, y, widths[j], height}
+			if c, ok := optionColor(cells[j], "fill"); ok {
+				p.rect(cell.x, cell.y, cell.w, cell.h, &c, nil, 0)
+			}
+			if border := cells[j].Object("border"); border != nil && valueOr(border, "type", "") != "none" {
+				c := black
+				if value, ok := optionColor(border, "color"); ok {
+					c = value
+				}
+				width := 1.0
+				if pt, ok := border.Number("pt"); ok {
+					width = pt
+				}
+				p.rect(cell.x, cell.y, cell.w, cell.h, nil, &c, width)
+			}
+			if j < len(row) {
+				cellOptions := cells[j]
+				if _, ok := cellOptions.Number("fontSize"); !ok {
+					cellOptions["fontSize"] = 12.0
+				}
+				textBox(p, row[j].Text, cell, cellOptions)
+			}
+			x += widths[j]
+		}
+		y += height
+	}
+}
+
+// cellHeight is the height a table cell needs for its text
+func cellHeight(text string, width float64, options payload.Options) float64 {
+	size := 12.0
+	if value, ok := options.Number("fontSize"); ok && value > 0 {
+		size = value
+	}
+	s := style{font: styled(options.Bool("bold"), options.Bool("italic")), size: size}
+	total := 7.2
+	for _, paragraph := range strings.Split(text, "\n") {
+		_, height := textBlock([]span{{text: paragraph, style: s}}, width-14.4, size, 1.2)
+		total += height
+	}
+	return total
+}
+
+// sizes reads an array option in inches such as colW, or splits total
+// evenly
+func sizes(options payload.Options, key string, count int, total float64) []float64 {
+	result := make([]float64, count)
+	values, _ := options[key].([]any)
+	for i := range result {
+		result[i] = total / float64(count)
+		if i < len(values) {
+			if value, ok := values[i].(float64); ok && value > 0 {
+				result[i] = value * pointsPerInch
+			}
+		}
+	}
+	return result
+}
+
+// image draws a picture following options.sizing, or a placeholder when
+// it cannot be loaded
+func (r *slideRenderer) image(p *page, element payload.SlideData) {
+	options := element.Options
+	b := elementBox(options, 3)
+	src := element.Value.Text
+	if path, ok := options.String("path"); ok && src == "" {
+		src = path
+	}
+
+	img, err := r.loadImage(src)
+	if err != nil {
+		log.Printf("Failed to load slide image %q: %v", src, err)
+		fill, border := mustColor("F2F2F2"), mustColor("BFBFBF")
+		p.rect(b.x, b.y, b.w, b.h, &fill, &border, 1)
+		textBox(p, src, b, payload.Options{"align": "center", "valign": "middle", "fontSize": 12.0, "color": "7F7F7F"})
+		return
+	}
+
+	imageRatio := float64(img.width) / float64(img.height)
+	boxRatio := b.w / b.h
+	switch valueOr(options.Object("sizing"), "type", "") {
+	case "contain":
+		if imageRatio > boxRatio {
+			height := b.w / imageRatio
+			b.y += (b.h - height) / 2
+			b.h = height
+		} else {
+			width := b.h * imageRatio
+			b.x += (b.w - width) / 2
+			b.w = width
+		}
+	case "cover", "crop":
+		// Draw the picture larger and clip it to the box
+		drawn := b
+		if imageRatio > boxRatio {
+			drawn.w = b.h * imageRatio
+			drawn.x -= (drawn.w - b.w) / 2
+		} else {
+			drawn.h = b.w / imageRatio
+			drawn.y -= (drawn.h - b.h) / 2
+		}
+		p.clip(b.x, b.y, b.w, b.h)
+		p.image(img, drawn.x, drawn.y, drawn.w, drawn.h)
+		p.unclip()
+		return
+	}
+	p.image(img, b.x, b.y, b.w, b.h)
+}
+
+// loadImage fetches and decodes an image once per file
+func (r *slideRenderer) loadImage(src string) (*pdfImage, error) {
+	if img, ok := r.images[src]; ok {
+		return img, nil
+	}
+	if r.options.LoadImage == nil {
+		return nil, errNoImageLoader
+	}
+	data, err := r.options.LoadImage(r.ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	r.images[src] = img
+	return img, nil
+}
@@ -0,0 +1,146 @@
+package pdf
+
+import (
+	"math"
+
+	"github.com/integems/report-agent/src/markdown"
+)
+
+// tableBorder is the color of the cell borders
+var tableBorder = mustColor("A6A6A6")
+
+// tableCell is a cell wrapped to its column
+type tableCell struct {
+	lines  []line
+	height float64
+	align  alignment
+}
+
+// table lays out a GFM table across the width. Columns get their natural
+// width when the table fits and share the space by content otherwise. The
+// header row is repeated on every page the table spans.
+func (l *layout) table(block markdown.Block, x, width float64, base style) {
+	columns := len(block.Header)
+	if columns == 0 {
+		return
+	}
+	cell := base
+	cell.size = base.size * 0.9
+	header := cell
+	header.font = bold
+
+	headerSpans := make([][]span, columns)
+	for i, inlines := range block.Header {
+		headerSpans[i] = l.spans(inlines, header)
+	}
+	rowSpans := make([][][]span, len(block.Rows))
+	for r, row := range block.Rows {
+		rowSpans[r] = make([][]span, columns)
+		for i := 0; i < columns && i < len(row); i++ {
+			rowSpans[r][i] = l.spans(row[i], cell)
+		}
+	}
+
+	widths := columnWidths(append([][][]span{headerSpans}, rowSpans...), columns, width)
+	aligns := make([]alignment, columns)
+	for i := 0; i < columns && i < len(block.Align); i++ {
+		switch block.Align[i] {
+		case markdown.AlignCenter:
+			aligns[i] = alignCenter
+		case markdown.AlignRight:
+			aligns[i] = alignRight
+		}
+	}
+
+	headerRow, headerHeight := layoutRow(headerSpans, widths, aligns, cell.size)
+	l.fit(headerHeight + cell.size*bodyLeading + 2*cellPadding)
+	l.drawMarker(l.y, bodyLeading)
+	l.drawRow(headerRow, widths, x, headerHeight, &headerFill)
+
+	for _, spans := range rowSpans {
+		row, height := layoutRow(spans, widths, aligns, cell.size)
+		if l.y+height > l.bottom() {
+			l.newPage()
+			l.drawRow(headerRow, widths, x, headerHeight, &headerFill)
+		}
+		l.drawRow(row, widths, x, height, nil)
+	}
+	l.y += paragraphGap
+}
+
+// columnWidths shares the width between the columns. Every column gets at
+// least its longest word when possible, the rest goes to the columns with
+// the most text.
+func columnWidths(rows [][][]span, columns int, width float64) []float64 {
+	natural := make([]float64, columns)
+	minimum := make([]float64, columns)
+	for _, row := range rows {
+		for i := 0; i < columns && i < len(row); i++ {
+			lines := wrap(row[i], math.Inf(1), 0)
+			for _, line := range lines {
+				natural[i] = math.Max(natural[i], line.width+2*cellPadding)
+			}
+			for _, w := range words(row[i]) {
+				minimum[i] = math.Max(minimum[i], w.width+2*cellPadding)
+			}
+		}
+	}
+
+	widths := make([]float64, columns)
+	totalNatural, totalMinimum := 0.0, 0.0
+	for i := range widths {
+		natural[i] = math.Max(natural[i], 3*cellPadding)
+		minimum[i] = math.Min(math.Max(minimum[i], 3*cellPadding), width/float64(columns)*2)
+		totalNatural += natural[i]
+		totalMinimum += minimum[i]
+	}
+
+	switch {
+	case totalNatural <= width:
+		// Spread the spare space evenly
+		for i := range widths {
+			widths[i] = natural[i] + (width-totalNatural)/float64(columns)
+		}
+	case totalMinimum >= width:
+		for i := range widths {
+			widths[i] = minimum[i] * width / totalMinimum
+		}
+	default:
+		// Share the space beyond the minimums by how much each column wants
+		spare, wanted := width-totalMinimum, totalNatural-totalMinimum
+		for i := range widths {
+			widths[i] = minimum[i] + spare*(natural[i]-minimum[i])/wanted
+		}
+	}
+	return widths
+}
+
+// layoutRow wraps the cells of a row and returns them with the row height
+func layoutRow(cells [][]span, widths []float64, aligns []alignment, size float64) ([]tableCell, float64) {
+	row := make([]tableCell, len(widths))
+	height := size*bodyLeading + 2*cellPadding
+	for i := range widths {
+		var spans []span
+		if i < len(cells) {
+			spans = cells[i]
+		}
+		lines, textHeight := textBlock(spans, widths[i]-2*cellPadding, size, bodyLeading)
+		row[i] = tableCell{lines: lines, height: textHeight, align: aligns[i]}
+		height = math.Max(height, textHeight+2*cellPadding)
+	}
+	return row, height
+}
+
+// drawRow draws a row of cells with their borders at the current position
+func (l *layout) drawRow(row []tableCell, widths []float64, x, height float64, fill *rgb) {
+	for i, cell := range row {
+		l.page.rect(x, l.y, widths[i], height, fill, &tableBorder, 0.5)
+		top := l.y + cellPadding
+		for _, line := range cell.lines {
+			line.draw(l.page, x+cellPadding, top, widths[i]-2*cellPadding, bodyLeading, cell.align)
+			top += line.height(bodyLeading)
+		}
+		x += widths[i]
+	}
+	l.y += height
+}
@@ -0,0 +1,242 @@
+package pdf
+
+import (
+	"math"
+	"strings"
+)
+
+// style is how a span of text is drawn
+type style struct {
+	font      font
+	size      float64
+	color     rgb
+	rise      float64 // baseline shift for subscripts and superscripts
+	strike    bool
+	underline bool
+	link      string
+}
+
+// span is text in one style
+type span struct {
+	text  string
+	style style
+}
+
+// word is text between spaces. A word spans several styles when its style
+// changes without a space, as in "**bold**ly".
+type word struct {
+	spans     []span
+	width     float64
+	space     float64 // width of the space before the word
+	lineBreak bool    // a forced line break replaces the word
+}
+
+// words splits spans at spaces and line breaks
+func words(spans []span) []word {
+	var result []word
+	var current *word
+	pendingSpace := 0.0
+	for _, s := range spans {
+		start := 0
+		flush := func(end int) {
+			if end <= start {
+				return
+			}
+			text := s.text[start:end]
+			if current == nil {
+				result = append(result, word{space: pendingSpace})
+				current = &result[len(result)-1]
+				pendingSpace = 0
+			}
+			current.spans = append(current.spans, span{text: text, style: s.style})
+			current.width += textWidth(text, s.style.font, s.style.size)
+		}
+		for i, r := range s.text {
+			switch r {
+			case ' ', '\t':
+				flush(i)
+				current = nil
+				pendingSpace = textWidth(" ", s.style.font, s.style.size)
+				start = i + 1
+			case '\n':
+				flush(i)
+				current = nil
+				pendingSpace = 0
+				result = append(result, word{lineBreak: true})
+				start = i + 1
+			}
+		}
+		flush(len(s.text))
+	}
+	return result
+}
+
+// line is a wrapped line of words
+type line struct {
+	words []word
+	width float64
+	size  float64 // largest font size on the line
+}
+
+// wrap breaks spans into lines no wider than width. Words longer than a
+// line are broken between characters.
+func wrap(spans []span, width float64, size float64) []line {
+	var lines []line
+	current := line{size: size}
+	for _, w := range words(spans) {
+		if w.lineBreak {
+			lines = append(lines, current)
+			current = line{size: size}
+			continue
+		}
+		space := w.space
+		if len(current.words) == 0 {
+			space = 0
+		}
+		if len(current.words) > 0 && current.width+space+w.width > width {
+			lines = append(lines, current)
+			current = line{size: size}
+			space = 0
+		}
+		for w.width > width && len(current.words) == 0 {
+			head, tail := splitWord(w, width)
+			if len(head.spans) == 0 {
+				break
+			}
+			current.add(head, 0)
+			lines = append(lines, current)
+			current = line{size: size}
+			w = tail
+		}
+		w.space = space
+		current.add(w, space)
+	}
+	if len(current.words) > 0 || len(lines) == 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+func (l *line) add(w word, space float64) {
+	w.space = space
+	l.words = append(l.words, w)
+	l.width += space + w.width
+	for _, s := range w.spans {
+		l.size = math.Max(l.size, s.style.size)
+	}
+}
+
+// splitWord takes as many characters of a word as fit in width, at least
+// one
+func splitWord(w word, width float64) (word, word) {
+	var head, tail word
+	used := 0.0
+	full := false
+	for _, s := range w.spans {
+		if full {
+			tail.spans = append(tail.spans, s)
+			tail.width += textWidth(s.text, s.style.font, s.style.size)
+			continue
+		}
+		cut := len(s.text)
+		for i, r := range s.text {
+			advance := textWidth(string(r), s.style.font, s.style.size)
+			if used+advance > width && (used > 0 || i > 0) {
+				cut = i
+				full = true
+				break
+			}
+			used += advance
+		}
+		if cut > 0 {
+			head.spans = append(head.spans, span{text: s.text[:cut], style: s.style})
+		}
+		if cut < len(s.text) {
+			tail.spans = append(tail.spans, span{text: s.text[cut:], style: s.style})
+			tail.width += textWidth(s.text[cut:], s.style.font, s.style.size)
+		}
+	}
+	head.width = used
+	return head, tail
+}
+
+// Horizontal alignment of lines
+type alignment int
+
+const (
+	alignLeft alignment = iota
+	alignCenter
+	alignRight
+)
+
+// height is the height of a line with its leading
+func (l line) height(leading float64) float64 {
+	return l.size * leading
+}
+
+// draw draws a line in a box starting at top
+func (l line) draw(p *page, x, top, width, leading float64, align alignment) {
+	switch align {
+	case alignCenter:
+		x += (width - l.width) / 2
+	case alignRight:
+		x += width - l.width
+	}
+	baseline := top + (l.height(leading)-l.size)/2 + l.size*0.8
+
+	// Consecutive text in the same style is drawn at once
+	var run strings.Builder
+	var runStyle style
+	runX := x
+	flush := func(end float64) {
+		if run.Len() == 0 {
+			return
+		}
+		s := runStyle
+		p.text(runX, baseline-s.rise, s.font, s.size, s.color, run.String())
+		if s.strike {
+			p.line(runX, baseline-s.rise-s.size*0.3, end, baseline-s.rise-s.size*0.3, s.color, s.size/18)
+		}
+		if s.underline || s.link != "" {
+			p.line(runX, baseline-s.rise+s.size*0.12, end, baseline-s.rise+s.size*0.12, s.color, s.size/18)
+		}
+		if s.link != "" {
+			p.links = append(p.links, link{x: runX, y: baseline - s.size, w: end - runX, h: s.size * 1.2, url: s.link})
+		}
+		run.Reset()
+	}
+
+	position := x
+	for _, w := range l.words {
+		for i, s := range w.spans {
+			if run.Len() > 0 && s.style != runStyle {
+				flush(position)
+			}
+			if run.Len() == 0 {
+				runStyle = s.style
+				runX = position
+				if i == 0 && w.space > 0 {
+					runX += w.space
+				}
+			} else if i == 0 && w.space > 0 {
+				run.WriteString(" ")
+			}
+			if i == 0 {
+				position += w.space
+			}
+			run.WriteString(s.text)
+			position += textWidth(s.text, s.style.font, s.style.size)
+		}
+	}
+	flush(position)
+}
+
+// textBlock wraps spans and returns the lines with their total height
+func textBlock(spans []span, width, size, leading float64) ([]line, float64) {
+	lines := wrap(spans, width, size)
+	total := 0.0
+	for _, l := range lines {
+		total += l.height(leading)
+	}
+	return lines, total
+}
@@ -0,0 +1,453 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// rgb is a color with components between 0 and 1
+type rgb struct {
+	r, g, b float64
+}
+
+var (
+	black = rgb{0, 0, 0}
+	white = rgb{1, 1, 1}
+)
+
+// hexColor parses a color such as "FF0000" or "#ff0000"
+func hexColor(value string) (rgb, bool) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(value) != 6 {
+		return rgb{}, false
+	}
+	packed, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return rgb{}, false
+	}
+	return rgb{float64(packed>>16) / 255, float64(packed>>8&0xFF) / 255, float64(packed&0xFF) / 255}, true
+}
+
+// mustColor parses a color constant
+func mustColor(value string) rgb {
+	c, _ := hexColor(value)
+	return c
+}
+
+// link is a clickable area of a page
+type link struct {
+	x, y, w, h float64
+	url        string
+}
+
+// page is a page being drawn. Coordinates passed to its methods are in
+// points from the top left corner and converted to the bottom left origin
+// of PDF.
+type page struct {
+	width, height float64
+	content       bytes.Buffer
+	images        []*pdfImage
+	links         []link
+}
+
+// number formats a coordinate
+func number(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
+
+func (p *page) printf(format string, args ...any) {
+	fmt.Fprintf(&p.content, format, args...)
+}
+
+func (p *page) fillColor(c rgb) {
+	p.printf("%s %s %s rg\n", number(c.r), number(c.g), number(c.b))
+}
+
+func (p *page) strokeColor(c rgb) {
+	p.printf("%s %s %s RG\n", number(c.r), number(c.g), number(c.b))
+}
+
+// paint fills and strokes the current path. A nil color skips that part.
+func (p *page) paint(fill, stroke *rgb, lineWidth float64) {
+	if fill != nil {
+		p.fillColor(*fill)
+	}
+	if stroke != nil {
+		p.strokeColor(*stroke)
+		p.printf("%s w\n", number(lineWidth))
+	}
+	switch {
+	case fill != nil && stroke != nil:
+		p.content.WriteString("B\n")
+	case fill != nil:
+		p.content.WriteString("f\n")
+	case stroke != nil:
+		p.content.WriteString("S\n")
+	default:
+		p.content.WriteString("n\n")
+	}
+}
+
+func (p *page) rect(x, y, w, h float64, fill, stroke *rgb, lineWidth float64) {
+	p.printf("%s %s %s %s re\n", number(x), number(p.height-y-h), number(w), number(h))
+	p.paint(fill, stroke, lineWidth)
+}
+
+func (p *page) line(x1, y1, x2, y2 float64, c rgb, lineWidth float64) {
+	p.printf("%s %s m %s %s l\n", number(x1), number(p.height-y1), number(x2), number(p.height-y2))
+	p.paint(nil, &c, lineWidth)
+}
+
+// polygon draws a closed path through points given as x, y pairs
+func (p *page) polygon(points []float64, fill, stroke *rgb, lineWidth float64) {
+	if len(points) < 4 {
+		return
+	}
+	p.printf("%s %s m", number(points[0]), number(p.height-points[1]))
+	for i := 2; i+1 < len(points); i += 2 {
+		p.printf(" %s %s l", number(points[i]), number(p.height-points[i+1]))
+	}
+	p.content.WriteString(" h\n")
+	p.paint(fill, stroke, lineWidth)
+}
+
+// polyline draws an open path through points given as x, y pairs
+func (p *page) polyline(points []float64, c rgb, lineWidth float64) {
+	if len(points) < 4 {
+		return
+	}
+	p.printf("%s %s m", number(points[0]), number(p.height-points[1]))
+	for i := 2; i+1 < len(points); i += 2 {
+		p.printf(" %s %s l", number(points[i]), number(p.height-points[i+1]))
+	}
+	p.content.WriteString("\n")
+	p.paint(nil, &c, lineWidth)
+}
+
+// kappa places the control points of a Bézier curve approximating a
+// quarter circle
+const kappa = 0.5523
+
+func (p *page) ellipse(x, y, w, h float64, fill, stroke *rgb, lineWidth float64) {
+	rx, ry := w/2, h/2
+	cx, cy := x+rx, p.height-y-ry
+	ox, oy := rx*kappa, ry*kappa
+	p.printf("%s %s m\n", number(cx+rx), number(cy))
+	p.printf("%s %s %s %s %s %s c\n", number(cx+rx), number(cy+oy), number(cx+ox), number(cy+ry), number(cx), number(cy+ry))
+	p.printf("%s %s %s %s %s %s c\n", number(cx-ox), number(cy+ry), number(cx-rx), number(cy+oy), number(cx-rx), number(cy))
+	p.printf("%s %s %s %s %s %s c\n", number(cx-rx), number(cy-oy), number(cx-ox), number(cy-ry), number(cx), number(cy-ry))
+	p.printf("%s %s %s %s %s %s c h\n", number(cx+ox), number(cy-ry), number(cx+rx), number(cy-oy), number(cx+rx), number(cy))
+	p.paint(fill, stroke, lineWidth)
+}
+
+func (p *page) roundRect(x, y, w, h, radius float64, fill, stroke *rgb, lineWidth float64) {
+	radius = math.Min(radius, math.Min(w, h)/2)
+	if radius <= 0 {
+		p.rect(x, y, w, h, fill, stroke, lineWidth)
+		return
+	}
+	left, right := x, x+w
+	top, bottom := p.height-y, p.height-y-h
+	o := radius * (1 - kappa)
+	p.printf("%s %s m\n", number(left+radius), number(top))
+	p.printf("%s %s l %s %s %s %s %s %s c\n", number(right-radius), number(top), number(right-o), number(top), number(right), number(top-o), number(right), number(top-radius))
+	p.printf("%s %s l %s %s %s %s %s %s c\n", number(right), number(bottom+radius), number(right), number(bottom+o), number(right-o), number(bottom), number(right-radius), number(bottom))
+	p.printf("%s %s l %s %s %s %s %s %s c\n", number(left+radius), number(bottom), number(left+o), number(bottom), number(left), number(bottom+o), number(left), number(bottom+radius))
+	p.printf("%s %s l %s %s %s %s %s %s c h\n", number(left), number(top-radius), number(left), number(top-o), number(left+o), number(top), number(left+radius), number(top))
+	p.paint(fill, stroke, lineWidth)
+}
+
+// text draws a string with its baseline at y and returns its width.
+// Characters missing from the font are taken from the Symbol font.
+func (p *page) text(x, y float64, f font, size float64, c rgb, s string) float64 {
+	var runs []encodedRun
+	for _, r := range s {
+		if skip(r) {
+			continue
+		}
+		runFont, code := encode(r, f)
+		if len(runs) == 0 || runs[len(runs)-1].font != runFont {
+			runs = append(runs, encodedRun{font: runFont})
+		}
+		runs[len(runs)-1].codes = append(runs[len(runs)-1].codes, code)
+	}
+	if len(runs) == 0 {
+		return 0
+	}
+
+	p.fillColor(c)
+	p.printf("BT\n%s %s Td\n", number(x), number(p.height-y))
+	total := 0.0
+	for _, run := range runs {
+		p.printf("/F%d %s Tf %s Tj\n", run.font+1, number(size), literal(run.codes))
+		for _, code := range run.codes {
+			total += float64(width(run.font, code)) * size / 1000
+		}
+	}
+	p.content.WriteString("ET\n")
+	return total
+}
+
+// encodedRun is text encoded for one font
+type encodedRun struct {
+	font  font
+	codes []byte
+}
+
+// textWidth measures a string in points
+func textWidth(s string, f font, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		if skip(r) {
+			continue
+		}
+		runFont, code := encode(r, f)
+		total += width(runFont, code)
+	}
+	return float64(total) * size / 1000
+}
+
+// literal writes bytes as a PDF string
+func literal(codes []byte) string {
+	var builder strings.Builder
+	builder.WriteByte('(')
+	for _, code := range codes {
+		switch {
+		case code == '(' || code == ')' || code == '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(code)
+		case code < 32 || code > 126:
+			fmt.Fprintf(&builder, "\\%03o", code)
+		default:
+			builder.WriteByte(code)
+		}
+	}
+	builder.WriteByte(')')
+	return builder.String()
+}
+
+// textString encodes metadata text, as UTF-16 when it is not ASCII
+func textString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r > 126 || r < 32 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return literal([]byte(s))
+	}
+	var builder strings.Builder
+	builder.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&builder, "%04X", unit)
+	}
+	builder.WriteString(">")
+	return builder.String()
+}
+
+// image draws a picture in the box
+func (p *page) image(img *pdfImage, x, y, w, h float64) {
+	index := -1
+	for i, existing := range p.images {
+		if existing == img {
+			index = i
+		}
+	}
+	if index < 0 {
+		p.images = append(p.images, img)
+		index = len(p.images) - 1
+	}
+	p.printf("q %s 0 0 %s %s %s cm /Im%d Do Q\n", number(w), number(h), number(x), number(p.height-y-h), index+1)
+}
+
+// clip restricts drawing to a box until unclip
+func (p *page) clip(x, y, w, h float64) {
+	p.printf("q %s %s %s %s re W n\n", number(x), number(p.height-y-h), number(w), number(h))
+}
+
+func (p *page) unclip() {
+	p.content.WriteString("Q\n")
+}
+
+// writer assembles the objects of the file
+type writer struct {
+	out     *bufio.Writer
+	offset  int
+	offsets []int
+	err     error
+}
+
+func (w *writer) write(s string) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.out.WriteString(s)
+	w.offset += n
+	w.err = err
+}
+
+// object starts object number id, which must be written in order
+func (w *writer) object(id int, dictionary string) {
+	for len(w.offsets) < id {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[id-1] = w.offset
+	w.write(fmt.Sprintf("%d 0 obj\n%s\nendobj\n", id, dictionary))
+}
+
+// stream writes a Flate compressed stream object. Data that is already
+// compressed is passed with its filter.
+func (w *writer) stream(id int, dictionary string, data []byte, filter string) {
+	if filter == "" {
+		var compressed bytes.Buffer
+		z := zlib.NewWriter(&compressed)
+		z.Write(data)
+		z.Close()
+		data = compressed.Bytes()
+		filter = "/FlateDecode"
+	}
+	for len(w.offsets) < id {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[id-1] = w.offset
+	w.write(fmt.Sprintf("%d 0 obj\n<<%s /Filter %s /Length %d>>\nstream\n", id, dictionary, filter, len(data)))
+	w.write(string(data))
+	w.write("\nendstream\nendobj\n")
+}
+
+// document holds the pages of a file being rendered
+type document struct {
+	options Options
+	pages   []*page
+}
+
+func (d *document) addPage(width, height float64) *page {
+	p := &page{width: width, height: height}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// pdfDate formats a time for the document information
+func pdfDate(t time.Time) string {
+	return t.UTC().Format("D:20060102150405Z")
+}
+
+// write serializes the document. Objects are numbered as the catalog, the
+// page tree, the information, the fonts, the images and then a page and
+// its content stream for each page.
+func (d *document) write(out io.Writer) error {
+	w := &writer{out: bufio.NewWriter(out)}
+	w.write("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+
+	const catalogId, pagesId, infoId, firstFontId = 1, 2, 3, 4
+	nextId := firstFontId + int(fontCount)
+
+	// Number the images in the order the pages use them
+	imageIds := map[*pdfImage]int{}
+	var images []*pdfImage
+	for _, p := range d.pages {
+		for _, img := range p.images {
+			if _, ok := imageIds[img]; ok {
+				continue
+			}
+			imageIds[img] = nextId
+			images = append(images, img)
+			nextId++
+			if img.mask != nil {
+				imageIds[img.mask] = nextId
+				nextId++
+			}
+		}
+	}
+	firstPageId := nextId
+
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPageId+2*i)
+	}
+	w.object(catalogId, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesId))
+	w.object(pagesId, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	info := "<< /Producer (report-agent)"
+	if d.options.Title != "" {
+		info += " /Title " + textString(d.options.Title)
+	}
+	if d.options.Author != "" {
+		info += " /Author " + textString(d.options.Author)
+	}
+	info += " /CreationDate (" + pdfDate(time.Now()) + ") >>"
+	w.object(infoId, info)
+
+	for i, name := range baseFonts {
+		encoding := " /Encoding /WinAnsiEncoding"
+		if font(i) == symbol {
+			encoding = ""
+		}
+		w.object(firstFontId+i, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s%s >>", name, encoding))
+	}
+	var fonts strings.Builder
+	for i := range baseFonts {
+		fmt.Fprintf(&fonts, "/F%d %d 0 R ", i+1, firstFontId+i)
+	}
+
+	for _, img := range images {
+		id := imageIds[img]
+		if img.mask != nil {
+			w.stream(id, img.dictionary(fmt.Sprintf(" /SMask %d 0 R", imageIds[img.mask])), img.data, img.filter)
+			w.stream(imageIds[img.mask], img.mask.dictionary(""), img.mask.data, img.mask.filter)
+			continue
+		}
+		w.stream(id, img.dictionary(""), img.data, img.filter)
+	}
+
+	for i, p := range d.pages {
+		id := firstPageId + 2*i
+		var resources strings.Builder
+		resources.WriteString("<< /Font << " + fonts.String() + ">>")
+		if len(p.images) > 0 {
+			resources.WriteString(" /XObject <<")
+			for j, img := range p.images {
+				fmt.Fprintf(&resources, " /Im%d %d 0 R", j+1, imageIds[img])
+			}
+			resources.WriteString(" >>")
+		}
+		resources.WriteString(" >>")
+
+		annotations := ""
+		if len(p.links) > 0 {
+			var builder strings.Builder
+			builder.WriteString(" /Annots [")
+			for _, l := range p.links {
+				fmt.Fprintf(&builder, " << /Type /Annot /Subtype /Link /Rect [%s %s %s %s] /Border [0 0 0] /A << /S /URI /URI %s >> >>",
+					number(l.x), number(p.height-l.y-l.h), number(l.x+l.w), number(p.height-l.y), literal([]byte(l.url)))
+			}
+			builder.WriteString(" ]")
+			annotations = builder.String()
+		}
+
+		w.object(id, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources %s /Contents %d 0 R%s >>",
+			pagesId, number(p.width), number(p.height), resources.String(), id+1, annotations))
+		w.stream(id+1, "", p.content.Bytes(), "")
+	}
+
+	xref := w.offset
+	w.write(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1))
+	for _, offset := range w.offsets {
+		w.write(fmt.Sprintf("%010d 00000 n \n", offset))
+	}
+	w.write(fmt.Sprintf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, catalogId, infoId, xref))
+	if w.err != nil {
+		return w.err
+	}
+	return w.out.Flush()
+}
@@ -0,0 +1,309 @@
+package pptx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/integems/report-agent/src/payload"
+)
+
+// defaultChartColors are used when the element has no chartColors
+var defaultChartColors = []string{"4472C4", "ED7D31", "A5A5A5", "FFC000", "5B9BD5", "70AD47"}
+
+// legendPositions are the legendPos values of pptxgenjs
+var legendPositions = map[string]bool{"b": true, "t": true, "l": true, "r": true, "tr": true}
+
+// Axis ids shared by the category and value axes of a chart
+const (
+	catAxisId = 111111111
+	valAxisId = 222222222
+)
+
+// chart renders a native chart from the chatData series
+func (s *slide) chart(element payload.SlideData) {
+	if len(element.ChatData) == 0 {
+		return
+	}
+	b := elementBox(element.Options, 3)
+
+	s.p.charts++
+	name := fmt.Sprintf("chart%d.xml", s.p.charts)
+	s.p.add("ppt/charts/"+name, chartXML(element.Value.Text, element.ChatData, element.Options))
+	rel := s.relate(relChart, "../charts/"+name)
+
+	id := s.id()
+	fmt.Fprintf(&s.body, `<p:graphicFrame><p:nvGraphicFramePr><p:cNvPr id="%d" name="Chart %d"/><p:cNvGraphicFramePr/><p:nvPr/></p:nvGraphicFramePr>`, id, id)
+	s.body.WriteString(b.xfrm("p", 0))
+	fmt.Fprintf(&s.body, `<a:graphic><a:graphicData uri="%s"><c:chart xmlns:c="%s" r:id="%s"/></a:graphicData></a:graphic></p:graphicFrame>`, nsC, nsC, rel)
+}
+
+// chartBuilder holds the options shared by the parts of a chart
+type chartBuilder struct {
+	kind    string
+	series  []payload.ChartData
+	options payload.Options
+	colors  []string
+	opacity float64
+}
+
+func chartXML(kind string, series []payload.ChartData, options payload.Options) string {
+	c := &chartBuilder{kind: kind, series: series, options: options, colors: defaultChartColors}
+	if values, ok := options["chartColors"].([]any); ok {
+		var colors []string
+		for _, value := range values {
+			if text, ok := value.(string); ok {
+				if color, ok := hexColor(text); ok {
+					colors = append(colors, color)
+				}
+			}
+		}
+		if len(colors) > 0 {
+			c.colors = colors
+		}
+	}
+	if opacity, ok := options.Number("chartColorsOpacity"); ok && opacity >= 0 && opacity < 100 {
+		c.opacity = 100 - opacity
+	}
+
+	var builder strings.Builder
+	builder.WriteString(xmlHeader)
+	fmt.Fprintf(&builder, `<c:chartSpace xmlns:c="%s" xmlns:a="%s" xmlns:r="%s"><c:roundedCorners val="0"/><c:chart>`, nsC, nsA, nsR)
+	builder.WriteString(c.title())
+	if kind == "bar3D" {
+		builder.WriteString(`<c:view3D><c:rotX val="15"/><c:rotY val="20"/><c:rAngAx val="1"/></c:view3D>`)
+	}
+	builder.WriteString(`<c:plotArea><c:layout/>`)
+	builder.WriteString(c.plot())
+	builder.WriteString(`</c:plotArea>`)
+	builder.WriteString(c.legend())
+	builder.WriteString(`<c:plotVisOnly val="1"/><c:dispBlanksAs val="gap"/></c:chart></c:chartSpace>`)
+	return builder.String()
+}
+
+func (c *chartBuilder) color(index int) string {
+	return solidFill(c.colors[index%len(c.colors)], c.opacity)
+}
+
+func (c *chartBuilder) title() string {
+	title, _ := c.options.String("title")
+	if title == "" && c.options.Bool("showTitle") {
+		title = c.series[0].Name
+	}
+	if title == "" {
+		return `<c:autoTitleDeleted val="1"/>`
+	}
+	size := 1400
+	if value, ok := c.options.Number("titleFontSize"); ok {
+		size = int(value * 100)
+	}
+	return fmt.Sprintf(`<c:title><c:tx><c:rich><a:bodyPr/><a:lstStyle/><a:p><a:pPr><a:defRPr sz="%d" b="1"/></a:pPr><a:r><a:rPr lang="en-US" sz="%d" b="1"/><a:t>%s</a:t></a:r></a:p></c:rich></c:tx><c:overlay val="0"/></c:title><c:autoTitleDeleted val="0"/>`,
+		size, size, esc(title))
+}
+
+func (c *chartBuilder) legend() string {
+	if value, ok := c.options["showLegend"].(bool); ok && !value {
+		return ""
+	}
+	position := "r"
+	if value, ok := c.options.String("legendPos"); ok && legendPositions[value] {
+		position = value
+	}
+	return fmt.Sprintf(`<c:legend><c:legendPos val="%s"/><c:overlay val="0"/></c:legend>`, position)
+}
+
+// plot renders the chart group and its axes
+func (c *chartBuilder) plot() string {
+	axes := fmt.Sprintf(`<c:axId val="%d"/><c:axId val="%d"/>`, catAxisId, valAxisId)
+	switch c.kind {
+	case "line":
+		return `<c:lineChart><c:grouping val="standard"/><c:varyColors val="0"/>` + c.categorySeries("line") +
+			`<c:marker val="1"/>` + axes + `</c:lineChart>` + c.categoryAxes()
+	case "area":
+		return `<c:areaChart><c:grouping val="standard"/><c:varyColors val="0"/>` + c.categorySeries("area") +
+			axes + `</c:areaChart>` + c.categoryAxes()
+	case "pie":
+		return `<c:pieChart><c:varyColors val="1"/>` + c.pieSeries() + `<c:firstSliceAng val="0"/></c:pieChart>`
+	case "doughnut":
+		return `<c:doughnutChart><c:varyColors val="1"/>` + c.pieSeries() + `<c:firstSliceAng val="0"/><c:holeSize val="50"/></c:doughnutChart>`
+	case "radar":
+		return `<c:radarChart><c:radarStyle val="marker"/><c:varyColors val="0"/>` + c.categorySeries("radar") +
+			axes + `</c:radarChart>` + c.categoryAxes()
+	case "scatter":
+		return `<c:scatterChart><c:scatterStyle val="lineMarker"/><c:varyColors val="0"/>` + c.xySeries(false) +
+			axes + `</c:scatterChart>` + c.valueAxes()
+	case "bubble":
+		return `<c:bubbleChart><c:varyColors val="0"/>` + c.xySeries(true) +
+			`<c:bubbleScale val="100"/><c:showNegBubbles val="0"/>` + axes + `</c:bubbleChart>` + c.valueAxes()
+	case "bar3D":
+		return `<c:bar3DChart><c:barDir val="col"/><c:grouping val="clustered"/><c:varyColors val="0"/>` + c.categorySeries("bar") +
+			`<c:gapWidth val="150"/><c:shape val="box"/>` + axes + `</c:bar3DChart>` + c.categoryAxes()
+	default:
+		barDir := "col"
+		if value, ok := c.options.String("barDir"); ok && value == "bar" {
+			barDir = "bar"
+		}
+		return `<c:barChart><c:barDir val="` + barDir + `"/><c:grouping val="clustered"/><c:varyColors val="0"/>` + c.categorySeries("bar") +
+			`<c:gapWidth val="150"/>` + axes + `</c:barChart>` + c.categoryAxes()
+	}
+}
+
+// categorySeries renders series plotted against the labels
+func (c *chartBuilder) categorySeries(kind string) string {
+	var builder strings.Builder
+	for i, series := range c.series {
+		fmt.Fprintf(&builder, `<c:ser><c:idx val="%d"/><c:order val="%d"/><c:tx><c:v>%s</c:v></c:tx>`, i, i, esc(series.Name))
+		if kind == "line" || kind == "radar" {
+			fmt.Fprintf(&builder, `<c:spPr><a:ln w="28575" cap="rnd">%s</a:ln></c:spPr>`, c.color(i))
+		} else {
+			fmt.Fprintf(&builder, `<c:spPr>%s</c:spPr>`, c.color(i))
+		}
+		if kind == "bar" {
+			builder.WriteString(`<c:invertIfNegative val="0"/>`)
+		}
+		builder.WriteString(c.dataLabels())
+		builder.WriteString(`<c:cat>` + stringLiteral(series.Labels) + `</c:cat>`)
+		builder.WriteString(`<c:val>` + c.numberLiteral(series.Values) + `</c:val>`)
+		if kind == "line" {
+			builder.WriteString(`<c:smooth val="0"/>`)
+		}
+		builder.WriteString(`</c:ser>`)
+	}
+	return builder.String()
+}
+
+// pieSeries renders the first series with one color per slice
+func (c *chartBuilder) pieSeries() string {
+	series := c.series[0]
+	var builder strings.Builder
+	fmt.Fprintf(&builder, `<c:ser><c:idx val="0"/><c:order val="0"/><c:tx><c:v>%s</c:v></c:tx>`, esc(series.Name))
+	for i := range series.Values {
+		fmt.Fprintf(&builder, `<c:dPt><c:idx val="%d"/><c:bubble3D val="0"/><c:spPr>%s</c:spPr></c:dPt>`, i, c.color(i))
+	}
+	builder.WriteString(c.dataLabels())
+	builder.WriteString(`<c:cat>` + stringLiteral(series.Labels) + `</c:cat>`)
+	builder.WriteString(`<c:val>` + c.numberLiteral(series.Values) + `</c:val></c:ser>`)
+	return builder.String()
+}
+
+// xySeries follows pptxgenjs: the first series holds the X values and each
+// other series the Y values. For bubbles the series alternate between Y
+// values and bubble sizes.
+func (c *chartBuilder) xySeries(bubble bool) string {
+	if len(c.series) < 2 {
+		return ""
+	}
+	x := c.series[0].Values
+	var builder strings.Builder
+	step := 1
+	if bubble {
+		step = 2
+	}
+	for i, index := 1, 0; i < len(c.series); i, index = i+step, index+1 {
+		series := c.series[i]
+		fmt.Fprintf(&builder, `<c:ser><c:idx val="%d"/><c:order val="%d"/><c:tx><c:v>%s</c:v></c:tx>`, index, index, esc(series.Name))
+		if bubble {
+			fmt.Fprintf(&builder, `<c:spPr>%s</c:spPr><c:invertIfNegative val="0"/>`, c.color(index))
+		} else {
+			fmt.Fprintf(&builder, `<c:spPr><a:ln w="19050"><a:noFill/></a:ln></c:spPr><c:marker><c:symbol val="circle"/><c:size val="7"/><c:spPr>%s</c:spPr></c:marker>`, c.color(index))
+		}
+		builder.WriteString(c.dataLabels())
+		builder.WriteString(`<c:xVal>` + c.numberLiteral(x) + `</c:xVal><c:yVal>` + c.numberLiteral(series.Values) + `</c:yVal>`)
+		if bubble {
+			sizes := make([]float64, len(series.Values))
+			for j := range sizes {
+				sizes[j] = 1
+			}
+			if i+1 < len(c.series) {
+				sizes = c.series[i+1].Values
+			}
+			builder.WriteString(`<c:bubbleSize>` + c.numberLiteral(sizes) + `</c:bubbleSize><c:bubble3D val="0"/>`)
+		} else {
+			builder.WriteString(`<c:smooth val="0"/>`)
+		}
+		builder.WriteString(`</c:ser>`)
+	}
+	return builder.String()
+}
+
+func (c *chartBuilder) dataLabels() string {
+	if !c.options.Bool("showValue") && !c.options.Bool("showPercent") {
+		return ""
+	}
+	format := ""
+	if code, ok := c.options.String("dataLabelFormatCode"); ok && code != "" {
+		format = fmt.Sprintf(`<c:numFmt formatCode="%s" sourceLinked="0"/>`, esc(code))
+	}
+	return fmt.Sprintf(`<c:dLbls>%s<c:showLegendKey val="0"/><c:showVal val="%s"/><c:showCatName val="0"/><c:showSerName val="0"/><c:showPercent val="%s"/><c:showBubbleSize val="0"/></c:dLbls>`,
+		format, flag(c.options.Bool("showValue")), flag(c.options.Bool("showPercent")))
+}
+
+// categoryAxes renders a category axis along the bottom and a value axis
+func (c *chartBuilder) categoryAxes() string {
+	return fmt.Sprintf(`<c:catAx><c:axId val="%d"/><c:scaling><c:orientation val="minMax"/></c:scaling><c:delete val="0"/><c:axPos val="b"/>%s<c:numFmt formatCode="General" sourceLinked="0"/><c:majorTickMark val="out"/><c:minorTickMark val="none"/><c:tickLblPos val="nextTo"/>%s<c:crossAx val="%d"/><c:crosses val="autoZero"/><c:auto val="1"/><c:lblAlgn val="ctr"/><c:lblOffset val="100"/><c:noMultiLvlLbl val="0"/></c:catAx>`,
+		catAxisId, c.gridLine("catGridLine"), c.axisLabels("catAxisLabelColor"), valAxisId) +
+		c.valueAxis(valAxisId, catAxisId, "l", "valGridLine", "valAxisLabelColor")
+}
+
+// valueAxes renders the two value axes of scatter and bubble charts
+func (c *chartBuilder) valueAxes() string {
+	return c.valueAxis(catAxisId, valAxisId, "b", "catGridLine", "catAxisLabelColor") +
+		c.valueAxis(valAxisId, catAxisId, "l", "valGridLine", "valAxisLabelColor")
+}
+
+func (c *chartBuilder) valueAxis(id, crossId int, position, gridKey, colorKey string) string {
+	return fmt.Sprintf(`<c:valAx><c:axId val="%d"/><c:scaling><c:orientation val="minMax"/></c:scaling><c:delete val="0"/><c:axPos val="%s"/>%s<c:numFmt formatCode="General" sourceLinked="0"/><c:majorTickMark val="out"/><c:minorTickMark val="none"/><c:tickLblPos val="nextTo"/>%s<c:crossAx val="%d"/><c:crosses val="autoZero"/><c:crossBetween val="between"/></c:valAx>`,
+		id, position, c.gridLine(gridKey), c.axisLabels(colorKey), crossId)
+}
+
+// gridLine renders gridlines given as {color, width}, or none
+func (c *chartBuilder) gridLine(key string) string {
+	grid := c.options.Object(key)
+	if grid == nil || valueOr(grid, "style", "") == "none" {
+		return ""
+	}
+	color := "D9D9D9"
+	if value, ok := optionColor(grid, "color"); ok {
+		color = value
+	}
+	width := 1.0
+	if value, ok := grid.Number("width"); ok {
+		width = value
+	}
+	return fmt.Sprintf(`<c:majorGridlines><c:spPr><a:ln w="%d">%s</a:ln></c:spPr></c:majorGridlines>`, int64(width*emuPerPoint), solidFill(color, 0))
+}
+
+func (c *chartBuilder) axisLabels(key string) string {
+	color, ok := optionColor(c.options, key)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(`<c:txPr><a:bodyPr/><a:lstStyle/><a:p><a:pPr><a:defRPr>%s</a:defRPr></a:pPr><a:endParaRPr lang="en-US"/></a:p></c:txPr>`, solidFill(color, 0))
+}
+
+func stringLiteral(values []string) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, `<c:strLit><c:ptCount val="%d"/>`, len(values))
+	for i, value := range values {
+		fmt.Fprintf(&builder, `<c:pt idx="%d"><c:v>%s</c:v></c:pt>`, i, esc(value))
+	}
+	builder.WriteString(`</c:strLit>`)
+	return builder.String()
+}
+
+func (c *chartBuilder) numberLiteral(values []float64) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, `<c:numLit><c:formatCode>General</c:formatCode><c:ptCount val="%d"/>`, len(values))
+	for i, value := range values {
+		fmt.Fprintf(&builder, `<c:pt idx="%d"><c:v>%s</c:v></c:pt>`, i, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	builder.WriteString(`</c:numLit>`)
+	return builder.String()
+}
+
+func flag(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
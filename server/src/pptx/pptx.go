@@ -0,0 +1,164 @@
+// Package pptx renders the slide model of the &&json payload into an OOXML
+// PowerPoint file. Slides use the default pptxgenjs 16:9 layout of
+// 10 x 5.625 inches, so positions from the model map one to one.
+package pptx
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/integems/report-agent/src/payload"
+)
+
+// ContentType is the MIME type of a .pptx file
+const ContentType = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+
+// Options configure the rendering of a presentation
+type Options struct {
+	Title  string
+	Author string
+	// LoadImage fetches the image referenced by an Image element. Images that
+	// cannot be loaded are replaced by a placeholder.
+	LoadImage func(ctx context.Context, src string) ([]byte, error)
+}
+
+// part is a file of the package
+type part struct {
+	name string
+	data []byte
+}
+
+// presentation collects the parts while the slides are rendered
+type presentation struct {
+	ctx     context.Context
+	options Options
+	parts   []part
+	media   []string // extensions of the media files, for the content types
+	charts  int
+	images  int
+}
+
+func (p *presentation) add(name, data string) {
+	p.parts = append(p.parts, part{name: name, data: []byte(data)})
+}
+
+// Render writes the slides as a .pptx file
+func Render(ctx context.Context, w io.Writer, slides []payload.SlideContent, options Options) error {
+	if len(slides) == 0 {
+		return fmt.Errorf("presentation has no slides")
+	}
+
+	p := &presentation{ctx: ctx, options: options}
+	for i, slide := range slides {
+		p.renderSlide(i+1, slide)
+	}
+	p.addPresentation(len(slides))
+
+	archive := zip.NewWriter(w)
+	for _, part := range append([]part{{name: "[Content_Types].xml", data: []byte(p.contentTypes(len(slides)))}}, p.parts...) {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", part.name, err)
+		}
+		if _, err := file.Write(part.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+	}
+	return archive.Close()
+}
+
+// addPresentation adds the parts shared by every slide
+func (p *presentation) addPresentation(slideCount int) {
+	p.add("_rels/.rels", relationshipsXML([]relationship{
+		{"rId1", nsR + "/officeDocument", "ppt/presentation.xml"},
+		{"rId2", "http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties", "docProps/core.xml"},
+		{"rId3", nsR + "/extended-properties", "docProps/app.xml"},
+	}))
+
+	title := p.options.Title
+	if title == "" {
+		title = "Presentation"
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	p.add("docProps/core.xml", xmlHeader+`<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`+
+		`<dc:title>`+esc(title)+`</dc:title><dc:creator>`+esc(p.options.Author)+`</dc:creator>`+
+		`<dcterms:created xsi:type="dcterms:W3CDTF">`+now+`</dcterms:created><dcterms:modified xsi:type="dcterms:W3CDTF">`+now+`</dcterms:modified></cp:coreProperties>`)
+	p.add("docProps/app.xml", xmlHeader+`<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties">`+
+		fmt.Sprintf(`<Application>INTEGEMS</Application><Slides>%d</Slides></Properties>`, slideCount))
+
+	var slideIds strings.Builder
+	rels := []relationship{{"rId1", relSlideMaster, "slideMasters/slideMaster1.xml"}}
+	for i := 1; i <= slideCount; i++ {
+		id := fmt.Sprintf("rId%d", i+1)
+		fmt.Fprintf(&slideIds, `<p:sldId id="%d" r:id="%s"/>`, 255+i, id)
+		rels = append(rels, relationship{id, relSlide, fmt.Sprintf("slides/slide%d.xml", i)})
+	}
+	rels = append(rels,
+		relationship{fmt.Sprintf("rId%d", slideCount+2), relPresProps, "presProps.xml"},
+		relationship{fmt.Sprintf("rId%d", slideCount+3), relViewProps, "viewProps.xml"},
+		relationship{fmt.Sprintf("rId%d", slideCount+4), relTheme, "theme/theme1.xml"},
+		relationship{fmt.Sprintf("rId%d", slideCount+5), relTableStyles, "tableStyles.xml"},
+	)
+
+	p.add("ppt/presentation.xml", xmlHeader+fmt.Sprintf(`<p:presentation xmlns:a="%s" xmlns:r="%s" xmlns:p="%s" saveSubsetFonts="1">`, nsA, nsR, nsP)+
+		`<p:sldMasterIdLst><p:sldMasterId id="2147483648" r:id="rId1"/></p:sldMasterIdLst>`+
+		`<p:sldIdLst>`+slideIds.String()+`</p:sldIdLst>`+
+		fmt.Sprintf(`<p:sldSz cx="%d" cy="%d"/><p:notesSz cx="6858000" cy="9144000"/>`, emu(payload.SlideWidth), emu(payload.SlideHeight))+
+		`</p:presentation>`)
+	p.add("ppt/_rels/presentation.xml.rels", relationshipsXML(rels))
+
+	p.add("ppt/presProps.xml", xmlHeader+fmt.Sprintf(`<p:presentationPr xmlns:a="%s" xmlns:r="%s" xmlns:p="%s"/>`, nsA, nsR, nsP))
+	p.add("ppt/viewProps.xml", xmlHeader+fmt.Sprintf(`<p:viewPr xmlns:a="%s" xmlns:r="%s" xmlns:p="%s"><p:normalViewPr/><p:gridSpacing cx="76200" cy="76200"/></p:viewPr>`, nsA, nsR, nsP))
+	p.add("ppt/tableStyles.xml", xmlHeader+fmt.Sprintf(`<a:tblStyleLst xmlns:a="%s" def="{5C22544A-7EE6-4342-B048-85BDC9FD1C3A}"/>`, nsA))
+	p.add("ppt/theme/theme1.xml", themeXML)
+	p.add("ppt/slideMasters/slideMaster1.xml", slideMasterXML)
+	p.add("ppt/slideMasters/_rels/slideMaster1.xml.rels", relationshipsXML([]relationship{
+		{"rId1", relSlideLayout, "../slideLayouts/slideLayout1.xml"},
+		{"rId2", relTheme, "../theme/theme1.xml"},
+	}))
+	p.add("ppt/slideLayouts/slideLayout1.xml", slideLayoutXML)
+	p.add("ppt/slideLayouts/_rels/slideLayout1.xml.rels", relationshipsXML([]relationship{
+		{"rId1", relSlideMaster, "../slideMasters/slideMaster1.xml"},
+	}))
+}
+
+func (p *presentation) contentTypes(slideCount int) string {
+	const ml = "application/vnd.openxmlformats-officedocument.presentationml."
+	var builder strings.Builder
+	builder.WriteString(xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	builder.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	builder.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+
+	seen := map[string]bool{}
+	for _, extension := range p.media {
+		if !seen[extension] {
+			seen[extension] = true
+			fmt.Fprintf(&builder, `<Default Extension="%s" ContentType="image/%s"/>`, extension, extension)
+		}
+	}
+
+	override := func(name, contentType string) {
+		fmt.Fprintf(&builder, `<Override PartName="/%s" ContentType="%s"/>`, name, contentType)
+	}
+	override("ppt/presentation.xml", ml+"presentation.main+xml")
+	override("ppt/presProps.xml", ml+"presProps+xml")
+	override("ppt/viewProps.xml", ml+"viewProps+xml")
+	override("ppt/tableStyles.xml", ml+"tableStyles+xml")
+	override("ppt/slideMasters/slideMaster1.xml", ml+"slideMaster+xml")
+	override("ppt/slideLayouts/slideLayout1.xml", ml+"slideLayout+xml")
+	override("ppt/theme/theme1.xml", "application/vnd.openxmlformats-officedocument.theme+xml")
+	override("docProps/core.xml", "application/vnd.openxmlformats-package.core-properties+xml")
+	override("docProps/app.xml", "application/vnd.openxmlformats-officedocument.extended-properties+xml")
+	for i := 1; i <= slideCount; i++ {
+		override(fmt.Sprintf("ppt/slides/slide%d.xml", i), ml+"slide+xml")
+	}
+	for i := 1; i <= p.charts; i++ {
+		override(fmt.Sprintf("ppt/charts/chart%d.xml", i), "application/vnd.openxmlformats-officedocument.drawingml.chart+xml")
+	}
+	builder.WriteString(`</Types>`)
+	return builder.String()
+}
@@ -0,0 +1,45 @@
+package pptx
+
+import "github.com/integems/report-agent/src/payload"
+
+// presetNames maps the pptxgenjs shape names allowed by the prompt that are
+// not OOXML preset geometries to the closest preset
+var presetNames = map[string]string{
+	"doubleBracket":             "bracketPair",
+	"doubleBrace":               "bracePair",
+	"uTurnArrow":                "uturnArrow",
+	"pentagonArrow":             "homePlate",
+	"leftRightChevron":          "chevron",
+	"rightCircularArrow":        "circularArrow",
+	"arrow":                     "rightArrow",
+	"arrowCallout":              "rightArrowCallout",
+	"callout":                   "wedgeRectCallout",
+	"rectCallout":               "wedgeRectCallout",
+	"roundRectCallout":          "wedgeRoundRectCallout",
+	"ellipseCallout":            "wedgeEllipseCallout",
+	"lineCallout":               "borderCallout1",
+	"banner":                    "horizontalScroll",
+	"wavyBanner":                "wave",
+	"bentArrowCallout":          "rightArrowCallout",
+	"uTurnArrowCallout":         "rightArrowCallout",
+	"circularArrowCallout":      "rightArrowCallout",
+	"leftCircularArrowCallout":  "leftArrowCallout",
+	"rightCircularArrowCallout": "rightArrowCallout",
+	"curvedRightArrowCallout":   "rightArrowCallout",
+	"curvedLeftArrowCallout":    "leftArrowCallout",
+	"curvedUpArrowCallout":      "upArrowCallout",
+	"curvedDownArrowCallout":    "downArrowCallout",
+}
+
+// presetGeometry returns the OOXML preset for a shape name, or rect when the
+// name is not allowed
+func presetGeometry(name string) string {
+	name = payload.ShapeName(name)
+	if preset, ok := presetNames[name]; ok {
+		return preset
+	}
+	if !payload.IsShapeName(name) {
+		return "rect"
+	}
+	return name
+}
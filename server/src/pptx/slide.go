@@ -0,0 +1,421 @@
+package pptx
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/integems/report-agent/src/payload"
+)
+
+// slide is the slide being rendered
+type slide struct {
+	p        *presentation
+	body     strings.Builder
+	rels     []relationship
+	nextId   int
+	nextRRel int
+}
+
+func (s *slide) id() int {
+	s.nextId++
+	return s.nextId
+}
+
+func (s *slide) relate(kind, target string) string {
+	s.nextRRel++
+	id := fmt.Sprintf("rId%d", s.nextRRel)
+	s.rels = append(s.rels, relationship{id, kind, target})
+	return id
+}
+
+func (p *presentation) renderSlide(number int, content payload.SlideContent) {
+	s := &slide{p: p, nextId: 1, nextRRel: 1}
+	s.rels = []relationship{{"rId1", relSlideLayout, "../slideLayouts/slideLayout1.xml"}}
+
+	for _, element := range content.Data {
+		switch element.Type {
+		case payload.ContentText:
+			s.text(element)
+		case payload.ContentShape:
+			s.shape(element)
+		case payload.ContentTable:
+			s.table(element)
+		case payload.ContentChart:
+			s.chart(element)
+		case payload.ContentImage:
+			s.image(element)
+		default:
+			log.Printf("Skipping slide element of unknown type %q", element.Type)
+		}
+	}
+
+	p.add(fmt.Sprintf("ppt/slides/slide%d.xml", number), xmlHeader+
+		fmt.Sprintf(`<p:sld xmlns:a="%s" xmlns:r="%s" xmlns:p="%s">`, nsA, nsR, nsP)+
+		`<p:cSld><p:spTree>`+spTreeRoot+s.body.String()+`</p:spTree></p:cSld>`+
+		`<p:clrMapOvr><a:masterClrMapping/></p:clrMapOvr></p:sld>`)
+	p.add(fmt.Sprintf("ppt/slides/_rels/slide%d.xml.rels", number), relationshipsXML(s.rels))
+}
+
+// text renders a text box, drawn inside a shape when options.shape is set
+func (s *slide) text(element payload.SlideData) {
+	options := element.Options
+	shape, _ := options.String("shape")
+	b := elementBox(options, 1)
+	id := s.id()
+
+	fmt.Fprintf(&s.body, `<p:sp><p:nvSpPr><p:cNvPr id="%d" name="Text %d"/><p:cNvSpPr txBox="1"/><p:nvPr/></p:nvSpPr>`, id, id)
+	rotate, _ := options.Number("rotate")
+	fmt.Fprintf(&s.body, `<p:spPr>%s<a:prstGeom prst="%s"><a:avLst/></a:prstGeom>%s%s</p:spPr>`,
+		b.xfrm("a", rotate), presetGeometry(shape), fillXML(options), lineXML(options))
+	s.body.WriteString(textBody(element.Value.Text, options))
+	s.body.WriteString(`</p:sp>`)
+}
+
+// shape renders a preset shape, with text when options.text is set
+func (s *slide) shape(element payload.SlideData) {
+	options := element.Options
+	b := elementBox(options, 1)
+	id := s.id()
+
+	fmt.Fprintf(&s.body, `<p:sp><p:nvSpPr><p:cNvPr id="%d" name="Shape %d"/><p:cNvSpPr/><p:nvPr/></p:nvSpPr>`, id, id)
+	rotate, _ := options.Number("rotate")
+	fmt.Fprintf(&s.body, `<p:spPr>%s<a:prstGeom prst="%s"><a:avLst/></a:prstGeom>%s%s</p:spPr>`,
+		b.xfrm("a", rotate), presetGeometry(element.Value.Text), fillXML(options), lineXML(options))
+	text, _ := options.String("text")
+	s.body.WriteString(textBody(text, options))
+	s.body.WriteString(`</p:sp>`)
+}
+
+// fillXML returns the fill of an element, or no fill
+func fillXML(options payload.Options) string {
+	color, ok := optionColor(options, "fill")
+	if !ok {
+		return `<a:noFill/>`
+	}
+	transparency, _ := options.Object("fill").Number("transparency")
+	return solidFill(color, transparency)
+}
+
+// lineXML returns the outline of an element given as line: {color, width}
+func lineXML(options payload.Options) string {
+	color, ok := optionColor(options, "line")
+	if !ok {
+		return `<a:ln><a:noFill/></a:ln>`
+	}
+	width := 1.0
+	if value, ok := options.Object("line").Number("width"); ok {
+		width = value
+	} else if value, ok := options.Number("lineSize"); ok {
+		width = value
+	}
+	return fmt.Sprintf(`<a:ln w="%d">%s</a:ln>`, int64(width*emuPerPoint), solidFill(color, 0))
+}
+
+// textBody renders text with the pptxgenjs text options. Each line becomes
+// a paragraph.
+func textBody(text string, options payload.Options) string {
+	var builder strings.Builder
+	anchor := map[string]string{"top": "t", "middle": "ctr", "bottom": "b"}[valueOr(options, "valign", "")]
+	inset := ""
+	if margin, ok := options.Number("margin"); ok {
+		// pptxgenjs takes points, the prompt examples use inches
+		value := int64(margin * emuPerPoint)
+		if margin <= 1 {
+			value = emu(margin)
+		}
+		inset = fmt.Sprintf(` lIns="%d" tIns="%d" rIns="%d" bIns="%d"`, value, value, value, value)
+	}
+	builder.WriteString(`<p:txBody><a:bodyPr wrap="square" rtlCol="0"` + inset)
+	if anchor != "" {
+		builder.WriteString(` anchor="` + anchor + `"`)
+	}
+	builder.WriteString(`><a:normAutofit/></a:bodyPr><a:lstStyle/>`)
+
+	for _, line := range strings.Split(text, "\n") {
+		builder.WriteString(`<a:p>` + paragraphProperties(options))
+		if line != "" {
+			builder.WriteString(`<a:r>` + runProperties(options) + `<a:t>` + esc(line) + `</a:t></a:r>`)
+		}
+		builder.WriteString(`</a:p>`)
+	}
+	builder.WriteString(`</p:txBody>`)
+	return builder.String()
+}
+
+func paragraphProperties(options payload.Options) string {
+	var attributes, children strings.Builder
+	if align, ok := map[string]string{"left": "l", "center": "ctr", "right": "r", "justify": "just"}[valueOr(options, "align", "")]; ok {
+		attributes.WriteString(` algn="` + align + `"`)
+	}
+	if spacing, ok := options.Number("lineSpacingMultiple"); ok {
+		fmt.Fprintf(&children, `<a:lnSpc><a:spcPct val="%d"/></a:lnSpc>`, int64(spacing*100000))
+	} else if spacing, ok := options.Number("lineSpacing"); ok && spacing > 0 {
+		// Small values are multiples in the prompt examples, pptxgenjs takes points
+		if spacing <= 5 {
+			fmt.Fprintf(&children, `<a:lnSpc><a:spcPct val="%d"/></a:lnSpc>`, int64(spacing*100000))
+		} else {
+			fmt.Fprintf(&children, `<a:lnSpc><a:spcPts val="%d"/></a:lnSpc>`, int64(spacing*100))
+		}
+	}
+	if before, ok := options.Number("paraSpaceBefore"); ok && before > 0 {
+		fmt.Fprintf(&children, `<a:spcBef><a:spcPts val="%d"/></a:spcBef>`, int64(before*100))
+	}
+	if after, ok := options.Number("paraSpaceAfter"); ok && after > 0 {
+		fmt.Fprintf(&children, `<a:spcAft><a:spcPts val="%d"/></a:spcAft>`, int64(after*100))
+	}
+	if options.Bool("bullet") || options.Object("bullet") != nil {
+		attributes.WriteString(` marL="285750" indent="-285750"`)
+		children.WriteString(`<a:buFont typeface="Arial"/><a:buChar char="•"/>`)
+	}
+	if attributes.Len() == 0 && children.Len() == 0 {
+		return ""
+	}
+	return `<a:pPr` + attributes.String() + `>` + children.String() + `</a:pPr>`
+}
+
+func runProperties(options payload.Options) string {
+	var builder strings.Builder
+	builder.WriteString(`<a:rPr lang="en-US" dirty="0"`)
+	if size, ok := options.Number("fontSize"); ok && size > 0 {
+		fmt.Fprintf(&builder, ` sz="%d"`, int64(math.Round(size*100)))
+	}
+	if options.Bool("bold") {
+		builder.WriteString(` b="1"`)
+	}
+	if options.Bool("italic") {
+		builder.WriteString(` i="1"`)
+	}
+	if options.Bool("underline") || options.Object("underline") != nil {
+		builder.WriteString(` u="sng"`)
+	}
+	if spacing, ok := options.Number("charSpacing"); ok && spacing != 0 {
+		fmt.Fprintf(&builder, ` spc="%d"`, int64(spacing*100))
+	}
+	builder.WriteString(`>`)
+	if color, ok := optionColor(options, "color"); ok {
+		builder.WriteString(solidFill(color, 0))
+	}
+	if face, ok := options.String("fontFace"); ok && face != "" {
+		fmt.Fprintf(&builder, `<a:latin typeface="%s"/><a:cs typeface="%s"/>`, esc(face), esc(face))
+	}
+	builder.WriteString(`</a:rPr>`)
+	return builder.String()
+}
+
+func valueOr(options payload.Options, key, fallback string) string {
+	if value, ok := options.String(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// table renders a table. Columns use colW when given and share the width
+// of the element otherwise.
+func (s *slide) table(element payload.SlideData) {
+	rows := element.Value.Rows
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return
+	}
+
+	options := element.Options
+	b := elementBox(options, 0.4*float64(len(rows)))
+	widths := sizes(options, "colW", columns, b.w)
+	heights := sizes(options, "rowH", len(rows), b.h)
+
+	id := s.id()
+	fmt.Fprintf(&s.body, `<p:graphicFrame><p:nvGraphicFramePr><p:cNvPr id="%d" name="Table %d"/><p:cNvGraphicFramePr><a:graphicFrameLocks noGrp="1"/></p:cNvGraphicFramePr><p:nvPr/></p:nvGraphicFramePr>`, id, id)
+	s.body.WriteString(b.xfrm("p", 0))
+	s.body.WriteString(`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/table"><a:tbl><a:tblPr firstRow="1" bandRow="1"/><a:tblGrid>`)
+	for _, width := range widths {
+		fmt.Fprintf(&s.body, `<a:gridCol w="%d"/>`, emu(width))
+	}
+	s.body.WriteString(`</a:tblGrid>`)
+
+	for i, row := range rows {
+		fmt.Fprintf(&s.body, `<a:tr h="%d">`, emu(heights[i]))
+		for j := 0; j < columns; j++ {
+			cell := payload.TableCell{}
+			if j < len(row) {
+				cell = row[j]
+			}
+			s.body.WriteString(tableCell(cell, options))
+		}
+		s.body.WriteString(`</a:tr>`)
+	}
+	s.body.WriteString(`</a:tbl></a:graphicData></a:graphic></p:graphicFrame>`)
+}
+
+// sizes reads an array option such as colW, or splits total evenly
+func sizes(options payload.Options, key string, count int, total float64) []float64 {
+	result := make([]float64, count)
+	values, _ := options[key].([]any)
+	for i := range result {
+		result[i] = total / float64(count)
+		if i < len(values) {
+			if value, ok := values[i].(float64); ok && value > 0 {
+				result[i] = value
+			}
+		}
+	}
+	return result
+}
+
+// tableCell renders a cell with the table options overridden by its own
+func tableCell(cell payload.TableCell, tableOptions payload.Options) string {
+	options := payload.Options{}
+	for key, value := range tableOptions {
+		options[key] = value
+	}
+	for key, value := range cell.Options {
+		options[key] = value
+	}
+
+	var builder strings.Builder
+	builder.WriteString(`<a:tc><a:txBody><a:bodyPr/><a:lstStyle/>`)
+	for _, line := range strings.Split(cell.Text, "\n") {
+		builder.WriteString(`<a:p>` + paragraphProperties(options))
+		if line != "" {
+			builder.WriteString(`<a:r>` + runProperties(options) + `<a:t>` + esc(line) + `</a:t></a:r>`)
+		}
+		builder.WriteString(`</a:p>`)
+	}
+	builder.WriteString(`</a:txBody><a:tcPr`)
+	if margin, ok := options.Number("margin"); ok {
+		value := int64(margin * emuPerPoint)
+		if margin <= 1 {
+			value = emu(margin)
+		}
+		fmt.Fprintf(&builder, ` marL="%d" marR="%d" marT="%d" marB="%d"`, value, value, value, value)
+	}
+	if anchor, ok := map[string]string{"top": "t", "middle": "ctr", "bottom": "b"}[valueOr(options, "valign", "")]; ok {
+		builder.WriteString(` anchor="` + anchor + `"`)
+	}
+	builder.WriteString(`>`)
+
+	border := `<a:noFill/>`
+	borderWidth := int64(emuPerPoint)
+	if object := options.Object("border"); object != nil && valueOr(object, "type", "") != "none" {
+		color := "000000"
+		if value, ok := optionColor(object, "color"); ok {
+			color = value
+		}
+		if pt, ok := object.Number("pt"); ok {
+			borderWidth = int64(pt * emuPerPoint)
+		}
+		border = solidFill(color, 0)
+	}
+	for _, side := range []string{"lnL", "lnR", "lnT", "lnB"} {
+		fmt.Fprintf(&builder, `<a:%s w="%d">%s</a:%s>`, side, borderWidth, border, side)
+	}
+	if color, ok := optionColor(options, "fill"); ok {
+		builder.WriteString(solidFill(color, 0))
+	}
+	builder.WriteString(`</a:tcPr></a:tc>`)
+	return builder.String()
+}
+
+// image renders a picture, or a placeholder when it cannot be loaded
+func (s *slide) image(element payload.SlideData) {
+	options := element.Options
+	b := elementBox(options, 3)
+	src := element.Value.Text
+	if path, ok := options.String("path"); ok && src == "" {
+		src = path
+	}
+
+	data, extension, err := s.loadImage(src)
+	if err != nil {
+		log.Printf("Failed to load slide image %q: %v", src, err)
+		s.placeholder(b, src)
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width == 0 || config.Height == 0 {
+		log.Printf("Failed to decode slide image %q: %v", src, err)
+		s.placeholder(b, src)
+		return
+	}
+
+	s.p.images++
+	name := fmt.Sprintf("image%d.%s", s.p.images, extension)
+	s.p.parts = append(s.p.parts, part{name: "ppt/media/" + name, data: data})
+	s.p.media = append(s.p.media, extension)
+	rel := s.relate(relImage, "../media/"+name)
+
+	// Fit the picture to the box following options.sizing
+	crop := ""
+	imageRatio := float64(config.Width) / float64(config.Height)
+	boxRatio := b.w / b.h
+	switch valueOr(options.Object("sizing"), "type", "") {
+	case "contain":
+		if imageRatio > boxRatio {
+			height := b.w / imageRatio
+			b.y += (b.h - height) / 2
+			b.h = height
+		} else {
+			width := b.h * imageRatio
+			b.x += (b.w - width) / 2
+			b.w = width
+		}
+	case "cover", "crop":
+		if imageRatio > boxRatio {
+			cut := int64((1 - boxRatio/imageRatio) / 2 * 100000)
+			crop = fmt.Sprintf(`<a:srcRect l="%d" r="%d"/>`, cut, cut)
+		} else {
+			cut := int64((1 - imageRatio/boxRatio) / 2 * 100000)
+			crop = fmt.Sprintf(`<a:srcRect t="%d" b="%d"/>`, cut, cut)
+		}
+	}
+
+	geometry := "rect"
+	if options.Bool("rounding") {
+		geometry = "ellipse"
+	}
+	id := s.id()
+	fmt.Fprintf(&s.body, `<p:pic><p:nvPicPr><p:cNvPr id="%d" name="Picture %d" descr="%s"/><p:cNvPicPr><a:picLocks noChangeAspect="1"/></p:cNvPicPr><p:nvPr/></p:nvPicPr>`, id, id, esc(src))
+	fmt.Fprintf(&s.body, `<p:blipFill><a:blip r:embed="%s"/>%s<a:stretch><a:fillRect/></a:stretch></p:blipFill>`, rel, crop)
+	rotate, _ := options.Number("rotate")
+	fmt.Fprintf(&s.body, `<p:spPr>%s<a:prstGeom prst="%s"><a:avLst/></a:prstGeom></p:spPr></p:pic>`, b.xfrm("a", rotate), geometry)
+}
+
+func (s *slide) loadImage(src string) ([]byte, string, error) {
+	if s.p.options.LoadImage == nil {
+		return nil, "", fmt.Errorf("image loading is not configured")
+	}
+	data, err := s.p.options.LoadImage(s.p.ctx, src)
+	if err != nil {
+		return nil, "", err
+	}
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return data, "png", nil
+	case "image/jpeg":
+		return data, "jpeg", nil
+	case "image/gif":
+		return data, "gif", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported image format")
+	}
+}
+
+// placeholder marks where an image that could not be loaded belongs
+func (s *slide) placeholder(b box, src string) {
+	id := s.id()
+	fmt.Fprintf(&s.body, `<p:sp><p:nvSpPr><p:cNvPr id="%d" name="Image placeholder %d"/><p:cNvSpPr/><p:nvPr/></p:nvSpPr>`, id, id)
+	fmt.Fprintf(&s.body, `<p:spPr>%s<a:prstGeom prst="rect"><a:avLst/></a:prstGeom>%s<a:ln w="%d">%s</a:ln></p:spPr>`,
+		b.xfrm("a", 0), solidFill("F2F2F2", 0), emuPerPoint, solidFill("BFBFBF", 0))
+	s.body.WriteString(textBody(src, payload.Options{"align": "center", "valign": "middle", "fontSize": 12.0, "color": "7F7F7F"}))
+	s.body.WriteString(`</p:sp>`)
+}
@@ -0,0 +1,149 @@
+package pptx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/integems/report-agent/src/payload"
+)
+
+// OOXML namespaces
+const (
+	nsA   = "http://schemas.openxmlformats.org/drawingml/2006/main"
+	nsR   = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsP   = "http://schemas.openxmlformats.org/presentationml/2006/main"
+	nsC   = "http://schemas.openxmlformats.org/drawingml/2006/chart"
+	nsRel = "http://schemas.openxmlformats.org/package/2006/relationships"
+
+	relSlide       = nsR + "/slide"
+	relSlideLayout = nsR + "/slideLayout"
+	relSlideMaster = nsR + "/slideMaster"
+	relTheme       = nsR + "/theme"
+	relChart       = nsR + "/chart"
+	relImage       = nsR + "/image"
+	relPresProps   = nsR + "/presProps"
+	relViewProps   = nsR + "/viewProps"
+	relTableStyles = nsR + "/tableStyles"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+// emuPerInch converts inches to English Metric Units
+const emuPerInch = 914400
+
+// emuPerPoint converts points to English Metric Units
+const emuPerPoint = 12700
+
+func emu(inches float64) int64 {
+	return int64(math.Round(inches * emuPerInch))
+}
+
+// esc escapes text for use in XML content and attributes
+func esc(text string) string {
+	var builder strings.Builder
+	xml.EscapeText(&builder, []byte(text))
+	return builder.String()
+}
+
+// hexColor normalises a pptxgenjs color such as "FF0000" or "#ff0000"
+func hexColor(value string) (string, bool) {
+	value = strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(value), "#"))
+	if len(value) != 6 {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(value, 16, 32); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// solidFill returns a fill with an optional transparency in percent
+func solidFill(color string, transparency float64) string {
+	if transparency <= 0 {
+		return fmt.Sprintf(`<a:solidFill><a:srgbClr val="%s"/></a:solidFill>`, color)
+	}
+	alpha := int(math.Round((100 - math.Min(transparency, 100)) * 1000))
+	return fmt.Sprintf(`<a:solidFill><a:srgbClr val="%s"><a:alpha val="%d"/></a:srgbClr></a:solidFill>`, color, alpha)
+}
+
+// optionColor reads a color given either directly or as {"color": ...}
+func optionColor(options payload.Options, key string) (string, bool) {
+	if value, ok := options.String(key); ok {
+		return hexColor(value)
+	}
+	if object := options.Object(key); object != nil {
+		if value, ok := object.String("color"); ok {
+			return hexColor(value)
+		}
+	}
+	return "", false
+}
+
+// dimension reads a position or size in inches. pptxgenjs also accepts a
+// percentage of the slide such as "50%".
+func dimension(options payload.Options, key string, total float64) (float64, bool) {
+	if value, ok := options.Number(key); ok {
+		return value, true
+	}
+	if value, ok := options.String(key); ok && strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err == nil {
+			return total * percent / 100, true
+		}
+	}
+	return 0, false
+}
+
+// box is the position and size of an element, in inches
+type box struct {
+	x, y, w, h float64
+}
+
+// elementBox reads x, y, w and h, falling back to defaults sized for the
+// element and keeping the element on the slide
+func elementBox(options payload.Options, defaultHeight float64) box {
+	b := box{x: 1, y: 1, h: defaultHeight}
+	if value, ok := dimension(options, "x", payload.SlideWidth); ok {
+		b.x = value
+	}
+	if value, ok := dimension(options, "y", payload.SlideHeight); ok {
+		b.y = value
+	}
+	b.w = math.Max(payload.SlideWidth-b.x-0.5, 1)
+	if value, ok := dimension(options, "w", payload.SlideWidth); ok && value > 0 {
+		b.w = value
+	}
+	if value, ok := dimension(options, "h", payload.SlideHeight); ok && value > 0 {
+		b.h = value
+	}
+	return b
+}
+
+// xfrm writes the transform of an element, rotated in degrees
+func (b box) xfrm(prefix string, rotate float64) string {
+	rot := ""
+	if rotate != 0 {
+		rot = fmt.Sprintf(` rot="%d"`, int64(math.Round(rotate*60000)))
+	}
+	return fmt.Sprintf(`<%s:xfrm%s><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></%s:xfrm>`,
+		prefix, rot, emu(b.x), emu(b.y), emu(b.w), emu(b.h), prefix)
+}
+
+// relationship is an entry of a .rels part
+type relationship struct {
+	id, kind, target string
+}
+
+func relationshipsXML(relationships []relationship) string {
+	var builder strings.Builder
+	builder.WriteString(xmlHeader)
+	fmt.Fprintf(&builder, `<Relationships xmlns="%s">`, nsRel)
+	for _, rel := range relationships {
+		fmt.Fprintf(&builder, `<Relationship Id="%s" Type="%s" Target="%s"/>`, rel.id, rel.kind, esc(rel.target))
+	}
+	builder.WriteString(`</Relationships>`)
+	return builder.String()
+}
@@ -0,0 +1,188 @@
+// Package prompts loads the prompts sent to the model from text/template
+// files, so they can be changed without a rebuild.
+//
+// A prompt is defined by a file named {name}.v{version}.tmpl in the prompts
+// directory, such as chat.v2.tmpl. Every version of a prompt is kept; the
+// highest one is used unless active.json pins another, e.g. {"chat": 1}.
+// Templates in the partials directory are shared by all prompts and define
+// named blocks such as {{define "latex-rules"}}.
+package prompts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ErrNotFound is returned for a prompt that has no version
+var ErrNotFound = errors.New("prompt not found")
+
+// fileName matches the file of a prompt version
+var fileName = regexp.MustCompile(`^([a-z0-9-]+)\.v([0-9]+)\.tmpl$`)
+
+// Prompt is a version of a prompt
+type Prompt struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	File    string `json:"file"`
+	Active  bool   `json:"active"`
+
+	template *template.Template
+}
+
+// ID identifies a prompt version in stored messages, e.g. "chat@v2"
+func (p *Prompt) ID() string {
+	return fmt.Sprintf("%s@v%d", p.Name, p.Version)
+}
+
+// Registry holds the prompts of a directory
+type Registry struct {
+	dir string
+
+	mu       sync.RWMutex
+	prompts  map[string][]*Prompt // By name, oldest version first
+	active   map[string]*Prompt
+	loadedAt time.Time
+}
+
+// Load reads the prompts of a directory
+func Load(dir string) (*Registry, error) {
+	r := &Registry{dir: dir}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the prompts again. The prompts in use are kept when a
+// template is invalid.
+func (r *Registry) Reload() error {
+	partials, err := filepath.Glob(filepath.Join(r.dir, "partials", "*.tmpl"))
+	if err != nil {
+		return fmt.Errorf("failed to list partials: %w", err)
+	}
+	base := template.New("").Option("missingkey=error")
+	if len(partials) > 0 {
+		if base, err = base.ParseFiles(partials...); err != nil {
+			return fmt.Errorf("failed to parse partials: %w", err)
+		}
+	}
+
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read prompts: %w", err)
+	}
+
+	prompts := map[string][]*Prompt{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[2])
+
+		content, err := os.ReadFile(filepath.Join(r.dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+		tmpl, err := base.Clone()
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", entry.Name(), err)
+		}
+		if tmpl, err = tmpl.New(entry.Name()).Parse(string(content)); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", entry.Name(), err)
+		}
+		prompts[match[1]] = append(prompts[match[1]], &Prompt{Name: match[1], Version: version, File: entry.Name(), template: tmpl})
+	}
+
+	pins, err := r.pins()
+	if err != nil {
+		return err
+	}
+
+	active := map[string]*Prompt{}
+	for name, versions := range prompts {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+		active[name] = versions[len(versions)-1]
+		if pin, ok := pins[name]; ok {
+			pinned := false
+			for _, p := range versions {
+				if p.Version == pin {
+					active[name], pinned = p, true
+				}
+			}
+			if !pinned {
+				return fmt.Errorf("active.json pins %s to missing version %d", name, pin)
+			}
+		}
+		active[name].Active = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prompts, r.active, r.loadedAt = prompts, active, time.Now()
+	return nil
+}
+
+// pins reads the versions pinned in active.json
+func (r *Registry) pins() (map[string]int, error) {
+	pins := map[string]int{}
+	data, err := os.ReadFile(filepath.Join(r.dir, "active.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read active.json: %w", err)
+	}
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("failed to parse active.json: %w", err)
+	}
+	return pins, nil
+}
+
+// Render executes the active version of a prompt with data and returns the
+// text with the ID of the version
+func (r *Registry) Render(name string, data any) (string, string, error) {
+	r.mu.RLock()
+	prompt, ok := r.active[name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	var text bytes.Buffer
+	if err := prompt.template.Execute(&text, data); err != nil {
+		return "", "", fmt.Errorf("failed to render prompt %s: %w", prompt.ID(), err)
+	}
+	return text.String(), prompt.ID(), nil
+}
+
+// List returns every prompt version by name and version, and when they
+// were loaded
+func (r *Registry) List() ([]Prompt, time.Time) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var list []Prompt
+	for _, versions := range r.prompts {
+		for _, p := range versions {
+			list = append(list, *p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Version < list[j].Version
+	})
+	return list, r.loadedAt
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/integems/report-agent/config"
+)
+
+// maxImageSize limits images fetched for exports
+const maxImageSize = 20 * 1024 * 1024
+
+var errPrivateAddress = errors.New("refusing to fetch from a private address")
+
+// imageClient only connects to public addresses, since the URLs come from
+// model output
+var imageClient = newImageClient(nil)
+
+// clientAppClient fetches from the client app. Besides public addresses it
+// only reaches the address and port CLIENT_URL points to, and does not
+// follow redirects elsewhere.
+var clientAppClient = func() *http.Client {
+	client := newImageClient(isClientAddress)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if !isClientURL(req.URL) {
+			return errPrivateAddress
+		}
+		return nil
+	}
+	return client
+}()
+
+// newImageClient returns a client that refuses private addresses unless
+// allowed accepts them
+func newImageClient(allowed func(ip, port string) bool) *http.Client {
+	return &http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: func(network, address string, _ syscall.RawConn) error {
+					host, port, err := net.SplitHostPort(address)
+					if err != nil {
+						return err
+					}
+					ip := net.ParseIP(host)
+					if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+						if allowed == nil || !allowed(host, port) {
+							return errPrivateAddress
+						}
+					}
+					return nil
+				},
+			}).DialContext,
+		},
+	}
+}
+
+// clientURL is where relative image paths such as "/powerpoint.jpg" live
+func clientURL() string {
+	return strings.TrimSuffix(config.GetEnv("CLIENT_URL", "http://127.0.0.1:3000"), "/")
+}
+
+// origin returns the scheme, host and port of a URL, with the default port
+// of the scheme when it has none
+func origin(target *url.URL) (string, string, string) {
+	port := target.Port()
+	if port == "" {
+		switch target.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	return strings.ToLower(target.Scheme), strings.ToLower(target.Hostname()), port
+}
+
+// isClientURL reports whether target has the scheme, host and port of
+// CLIENT_URL
+func isClientURL(target *url.URL) bool {
+	client, err := url.Parse(clientURL())
+	if err != nil {
+		return false
+	}
+	scheme, host, port := origin(target)
+	clientScheme, clientHost, clientPort := origin(client)
+	return scheme == clientScheme && host == clientHost && port == clientPort
+}
+
+// isClientAddress reports whether ip and port are those of CLIENT_URL
+func isClientAddress(ip, port string) bool {
+	client, err := url.Parse(clientURL())
+	if err != nil {
+		return false
+	}
+	_, clientHost, clientPort := origin(client)
+	if port != clientPort {
+		return false
+	}
+	addresses, err := net.LookupHost(clientHost)
+	if err != nil {
+		return false
+	}
+	for _, address := range addresses {
+		if net.ParseIP(address).Equal(net.ParseIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchImage loads an image referenced in generated content: a data URI, an
+// http(s) URL, or a path relative to the client app.
+func FetchImage(ctx context.Context, src string) ([]byte, error) {
+	src = strings.TrimSpace(src)
+	if strings.HasPrefix(src, "data:") {
+		comma := strings.Index(src, ",")
+		if comma < 0 || !strings.HasSuffix(src[:comma], ";base64") {
+			return nil, fmt.Errorf("unsupported data URI")
+		}
+		return base64.StdEncoding.DecodeString(src[comma+1:])
+	}
+
+	if strings.HasPrefix(src, "/") {
+		src = clientURL() + src
+	}
+	target, err := url.Parse(src)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return nil, fmt.Errorf("unsupported image location %q", src)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := imageClient
+	if isClientURL(target) {
+		client = clientAppClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image request failed with status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxImageSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/integems/report-agent/config"
+	"github.com/integems/report-agent/src/llm"
+	"github.com/integems/report-agent/src/llm/fake"
+	"github.com/integems/report-agent/src/llm/gemini"
+	"github.com/integems/report-agent/src/llm/openai"
+	"github.com/integems/report-agent/src/storage"
+)
+
+// NewLLMProvider creates the chat provider selected by LLM_PROVIDER:
+// "gemini" (default), "openai" for OpenAI-compatible servers such as Ollama,
+// or "fake" for local development. The openai provider keeps uploaded files
+// in store.
+func NewLLMProvider(ctx context.Context, store storage.Store) (llm.Provider, error) {
+	switch provider := config.GetEnv("LLM_PROVIDER", "gemini"); provider {
+	case "gemini":
+		return gemini.New(ctx, config.GetEnv("GEMINI_API_KEY", ""), config.GetEnv("GEMINI_MODEL", gemini.DefaultModel))
+	case "openai":
+		return openai.New(
+			config.GetEnv("OPENAI_BASE_URL", "http://127.0.0.1:11434"),
+			config.GetEnv("OPENAI_API_KEY", ""),
+			config.GetEnv("OPENAI_MODEL", "llama3.1"),
+			store,
+		)
+	case "fake":
+		return fake.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q", provider)
+	}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local keeps blobs in a directory of the local disk, as {dir}/ab/abcd...
+type Local struct {
+	dir string
+}
+
+// NewLocal creates a store in dir
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &Local{dir: dir}, nil
+}
+
+// path returns the file of a blob
+func (l *Local) path(key string) string {
+	return filepath.Join(l.dir, key[:2], key)
+}
+
+func (l *Local) Put(ctx context.Context, r io.Reader) (Object, error) {
+	file, object, err := spool(r, filepath.Join(l.dir, "tmp"))
+	if err != nil {
+		return Object{}, err
+	}
+	defer os.Remove(file.Name())
+	if err := file.Close(); err != nil {
+		return Object{}, fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	path := l.path(object.Key)
+	if _, err := os.Stat(path); err == nil {
+		return object, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Object{}, fmt.Errorf("failed to store blob: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return Object{}, fmt.Errorf("failed to store blob: %w", err)
+	}
+	return object, nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return file, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (Object, error) {
+	if err := checkKey(key); err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return Object{}, ErrNotFound
+	}
+	if err != nil {
+		return Object{}, fmt.Errorf("failed to read blob: %w", err)
+	}
+	return Object{Key: key, Size: info.Size()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(l.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
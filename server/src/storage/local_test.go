@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newLocal(t *testing.T) *Local {
+	t.Helper()
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func read(t *testing.T, store Store, key string) string {
+	t.Helper()
+	r, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	store := newLocal(t)
+	ctx := context.Background()
+	content := "quarterly figures"
+	hash := sha256.Sum256([]byte(content))
+
+	object, err := store.Put(ctx, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if object.Key != hex.EncodeToString(hash[:]) || object.Size != int64(len(content)) {
+		t.Errorf("Put = %+v", object)
+	}
+	if got := read(t, store, object.Key); got != content {
+		t.Errorf("Get = %q, want %q", got, content)
+	}
+	if stat, err := store.Stat(ctx, object.Key); err != nil || stat != object {
+		t.Errorf("Stat = %+v, %v, want %+v", stat, err, object)
+	}
+
+	if err := store.Delete(ctx, object.Key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, object.Key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after delete = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Stat(ctx, object.Key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after delete = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, object.Key); err != nil {
+		t.Errorf("Delete of a missing blob = %v", err)
+	}
+}
+
+func TestLocalDeduplicates(t *testing.T) {
+	store := newLocal(t)
+	ctx := context.Background()
+
+	first, err := store.Put(ctx, strings.NewReader("same content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Put(ctx, strings.NewReader("same content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.Put(ctx, strings.NewReader("other content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || first.Key == other.Key {
+		t.Errorf("keys = %s, %s, %s", first.Key, second.Key, other.Key)
+	}
+
+	blobs, err := filepath.Glob(filepath.Join(store.dir, "??", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 2 {
+		t.Errorf("%d blobs stored, want 2: %v", len(blobs), blobs)
+	}
+	spooled, err := os.ReadDir(filepath.Join(store.dir, "tmp"))
+	if err != nil || len(spooled) != 0 {
+		t.Errorf("temporary files left behind: %v, %v", spooled, err)
+	}
+}
+
+func TestLocalEmptyBlob(t *testing.T) {
+	store := newLocal(t)
+
+	object, err := store.Put(context.Background(), strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if object.Key != emptyHash || object.Size != 0 {
+		t.Errorf("Put = %+v", object)
+	}
+	if got := read(t, store, object.Key); got != "" {
+		t.Errorf("Get = %q", got)
+	}
+}
+
+func TestLocalRejectsInvalidKeys(t *testing.T) {
+	store := newLocal(t)
+	ctx := context.Background()
+
+	// A file outside the blob directories must not be reachable
+	if err := os.WriteFile(filepath.Join(store.dir, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"", "../secret", "secret", strings.Repeat("A", 64), strings.Repeat("a", 63)} {
+		if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound", key, err)
+		}
+		if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Stat(%q) = %v, want ErrNotFound", key, err)
+		}
+		if err := store.Delete(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Delete(%q) = %v, want ErrNotFound", key, err)
+		}
+	}
+	if _, err := store.Get(ctx, strings.Repeat("a", 64)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing blob = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// emptyHash is the SHA-256 of an empty payload
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config configures an S3-compatible store
+type S3Config struct {
+	Endpoint  string // e.g. http://127.0.0.1:9000 for MinIO or https://s3.eu-west-1.amazonaws.com
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // Prepended to the keys of the objects
+}
+
+// S3 keeps blobs as objects of a bucket, addressed in path style so it works
+// with MinIO. Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 creates a store on a bucket and creates the bucket when it does not
+// exist
+func NewS3(ctx context.Context, config S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("S3 bucket, access key and secret key are required")
+	}
+
+	s := &S3{config: config, endpoint: endpoint, client: &http.Client{Timeout: 10 * time.Minute}}
+	if err := s.ensureBucket(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ensureBucket creates the bucket when it does not exist
+func (s *S3) ensureBucket(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, 0, emptyHash)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to check bucket: %s", resp.Status)
+	}
+
+	resp, err = s.do(ctx, http.MethodPut, "", nil, 0, emptyHash)
+	if err != nil {
+		return fmt.Errorf("failed to create bucket: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to create bucket: %s", responseError(resp))
+	}
+	return nil
+}
+
+// Put hashes the content before uploading it, since the key has to be known
+// before the upload starts. Seekable content, like uploaded form files, is
+// hashed in place and rewound; anything else is spooled to a temporary file.
+func (s *S3) Put(ctx context.Context, r io.Reader) (Object, error) {
+	var file io.ReadSeeker
+	var object Object
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		hash := sha256.New()
+		size, err := io.Copy(hash, seeker)
+		if err == nil {
+			_, err = seeker.Seek(-size, io.SeekCurrent)
+		}
+		if err != nil {
+			return Object{}, fmt.Errorf("failed to hash blob: %w", err)
+		}
+		file, object = seeker, Object{Key: hex.EncodeToString(hash.Sum(nil)), Size: size}
+	} else {
+		spooled, spooledObject, err := spool(r, "")
+		if err != nil {
+			return Object{}, err
+		}
+		defer os.Remove(spooled.Name())
+		defer spooled.Close()
+		file, object = spooled, spooledObject
+	}
+
+	if _, err := s.Stat(ctx, object.Key); err == nil {
+		return object, nil
+	}
+
+	// The key is the SHA-256 of the payload, which signs it as well
+	// The transport closes the body, which may belong to the caller
+	resp, err := s.do(ctx, http.MethodPut, s.config.Prefix+object.Key, io.NopCloser(file), object.Size, object.Key)
+	if err != nil {
+		return Object{}, fmt.Errorf("failed to upload blob: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Object{}, fmt.Errorf("failed to upload blob: %s", responseError(resp))
+	}
+	return object, nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.config.Prefix+key, nil, 0, emptyHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("failed to download blob: %s", responseError(resp))
+	}
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	if err := checkKey(key); err != nil {
+		return Object{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.config.Prefix+key, nil, 0, emptyHash)
+	if err != nil {
+		return Object{}, fmt.Errorf("failed to read blob: %w", err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return Object{Key: key, Size: resp.ContentLength}, nil
+	case http.StatusNotFound:
+		return Object{}, ErrNotFound
+	default:
+		return Object{}, fmt.Errorf("failed to read blob: %s", resp.Status)
+	}
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.config.Prefix+key, nil, 0, emptyHash)
+	if err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to delete blob: %s", responseError(resp))
+	}
+	return nil
+}
+
+// do sends a signed request on an object of the bucket, or on the bucket
+// itself when key is empty
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	target := *s.endpoint
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + s.config.Bucket
+	if key != "" {
+		target.Path += "/" + key
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 headers to a request
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// responseError describes a failed response with the start of its body,
+// which holds the S3 error code
+func responseError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if len(body) == 0 {
+		return resp.Status
+	}
+	return resp.Status + ": " + strings.TrimSpace(string(body))
+}
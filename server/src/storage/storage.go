@@ -0,0 +1,91 @@
+// Package storage keeps uploaded files as blobs, on the local disk or in an
+// S3-compatible bucket such as MinIO. Blobs are content-addressed: the key
+// of a blob is the hex SHA-256 of its content, so storing the same content
+// twice keeps one copy.
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/integems/report-agent/config"
+)
+
+// ErrNotFound is returned for a key that has no blob
+var ErrNotFound = errors.New("blob not found")
+
+// Object describes a stored blob
+type Object struct {
+	Key  string // Hex SHA-256 of the content
+	Size int64
+}
+
+// Store is a blob storage backend
+type Store interface {
+	// Put streams r into the store and returns the key of its content
+	Put(ctx context.Context, r io.Reader) (Object, error)
+	// Get opens the blob of key for reading, or returns ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat returns the blob of key, or ErrNotFound
+	Stat(ctx context.Context, key string) (Object, error)
+	// Delete removes the blob of key. Missing blobs are not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// New creates the store selected by STORAGE_DRIVER: "local" (default) keeps
+// blobs under STORAGE_DIR and "s3" in the bucket configured by the S3_*
+// variables.
+func New(ctx context.Context) (Store, error) {
+	switch driver := config.GetEnv("STORAGE_DRIVER", "local"); driver {
+	case "local":
+		return NewLocal(config.GetEnv("STORAGE_DIR", "storage"))
+	case "s3":
+		return NewS3(ctx, S3Config{
+			Endpoint:  config.GetEnv("S3_ENDPOINT", "http://127.0.0.1:9000"),
+			Region:    config.GetEnv("S3_REGION", "us-east-1"),
+			Bucket:    config.GetEnv("S3_BUCKET", "report-agent"),
+			AccessKey: config.GetEnv("S3_ACCESS_KEY", ""),
+			SecretKey: config.GetEnv("S3_SECRET_KEY", ""),
+			Prefix:    config.GetEnv("S3_PREFIX", "blobs/"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+	}
+}
+
+var validKey = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// checkKey rejects keys that are not a SHA-256, so they cannot escape the
+// store
+func checkKey(key string) error {
+	if !validKey.MatchString(key) {
+		return fmt.Errorf("%w: invalid key %q", ErrNotFound, key)
+	}
+	return nil
+}
+
+// spool copies r into a temporary file in dir while hashing it. The file is
+// rewound; the caller removes it.
+func spool(r io.Reader, dir string) (*os.File, Object, error) {
+	file, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), r)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, Object{}, fmt.Errorf("failed to store blob: %w", err)
+	}
+	return file, Object{Key: hex.EncodeToString(hash.Sum(nil)), Size: size}, nil
+}
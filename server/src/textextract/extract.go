@@ -0,0 +1,282 @@
+package textextract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrUnsupported = errors.New("unsupported document format")
+
+// Extract returns the plain text of a PDF, Word, PowerPoint, Excel or text document.
+// The format is detected from the content.
+func Extract(data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF")):
+		return extractPDF(data)
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return extractOOXML(data)
+	case utf8.Valid(data):
+		return string(data), nil
+	default:
+		return "", ErrUnsupported
+	}
+}
+
+// extractOOXML reads the text nodes of .docx, .pptx and .xlsx packages
+func extractOOXML(data []byte) (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open document: %w", err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range reader.File {
+		files[file.Name] = file
+	}
+
+	switch {
+	case files["word/document.xml"] != nil:
+		return xmlText(files["word/document.xml"], "t", "p")
+	case files["ppt/presentation.xml"] != nil:
+		return pptxText(reader.File)
+	case files["xl/workbook.xml"] != nil:
+		return xlsxText(files, reader.File)
+	default:
+		return "", ErrUnsupported
+	}
+}
+
+// xmlText concatenates the character data of textElement nodes and starts a
+// new line after every paragraphElement.
+func xmlText(file *zip.File, textElement, paragraphElement string) (string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var builder strings.Builder
+	decoder := xml.NewDecoder(rc)
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", file.Name, err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == textElement {
+				inText = true
+			}
+		case xml.EndElement:
+			if t.Name.Local == textElement {
+				inText = false
+			} else if t.Name.Local == paragraphElement {
+				builder.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				builder.Write(t)
+			}
+		}
+	}
+	return builder.String(), nil
+}
+
+var slideNumber = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+
+func pptxText(files []*zip.File) (string, error) {
+	type slide struct {
+		number int
+		file   *zip.File
+	}
+	var slides []slide
+	for _, file := range files {
+		if match := slideNumber.FindStringSubmatch(file.Name); match != nil {
+			number, _ := strconv.Atoi(match[1])
+			slides = append(slides, slide{number: number, file: file})
+		}
+	}
+	sort.Slice(slides, func(i, j int) bool { return slides[i].number < slides[j].number })
+
+	var builder strings.Builder
+	for _, s := range slides {
+		text, err := xmlText(s.file, "t", "p")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&builder, "Slide %d:\n%s\n", s.number, text)
+	}
+	return builder.String(), nil
+}
+
+func xlsxText(files map[string]*zip.File, all []*zip.File) (string, error) {
+	// Shared strings are referenced by index from cells of type "s"
+	var sharedStrings []string
+	if file := files["xl/sharedStrings.xml"]; file != nil {
+		var err error
+		if sharedStrings, err = readSharedStrings(file); err != nil {
+			return "", err
+		}
+	}
+
+	var builder strings.Builder
+	for _, file := range all {
+		if !strings.HasPrefix(file.Name, "xl/worksheets/") || !strings.HasSuffix(file.Name, ".xml") {
+			continue
+		}
+		text, err := sheetText(file, sharedStrings)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&builder, "Sheet %s:\n%s\n", strings.TrimSuffix(strings.TrimPrefix(file.Name, "xl/worksheets/"), ".xml"), text)
+	}
+	return builder.String(), nil
+}
+
+// readSharedStrings returns the string table of a workbook, one entry per <si>
+func readSharedStrings(file *zip.File) ([]string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var table struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := xml.NewDecoder(rc).Decode(&table); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file.Name, err)
+	}
+
+	sharedStrings := make([]string, 0, len(table.Items))
+	for _, item := range table.Items {
+		text := item.Text
+		for _, run := range item.Runs {
+			text += run.Text
+		}
+		sharedStrings = append(sharedStrings, text)
+	}
+	return sharedStrings, nil
+}
+
+func sheetText(file *zip.File, sharedStrings []string) (string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var builder strings.Builder
+	decoder := xml.NewDecoder(rc)
+	var cellType string
+	var inValue bool
+	var row []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", file.Name, err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "c":
+				cellType = ""
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "t" {
+						cellType = attr.Value
+					}
+				}
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "row":
+				builder.WriteString(strings.Join(row, "\t") + "\n")
+				row = row[:0]
+			}
+		case xml.CharData:
+			if !inValue {
+				continue
+			}
+			value := string(t)
+			if cellType == "s" {
+				if index, err := strconv.Atoi(value); err == nil && index < len(sharedStrings) {
+					value = sharedStrings[index]
+				}
+			}
+			row = append(row, value)
+		}
+	}
+	return builder.String(), nil
+}
+
+var (
+	pdfStream     = regexp.MustCompile(`(?s)stream\r?\n(.*?)\r?\nendstream`)
+	pdfTextObject = regexp.MustCompile(`(?s)BT(.*?)ET`)
+	pdfShowText   = regexp.MustCompile(`(?s)\((.*?[^\\])\)\s*(?:Tj|'|")|\[(.*?)\]\s*TJ`)
+	pdfArrayText  = regexp.MustCompile(`(?s)\((.*?[^\\])\)`)
+)
+
+// extractPDF is a best-effort extractor for the text operators of PDF content
+// streams. Text in custom-encoded fonts is not recoverable this way.
+func extractPDF(data []byte) (string, error) {
+	var builder strings.Builder
+	for _, match := range pdfStream.FindAllSubmatch(data, -1) {
+		content := match[1]
+		if rc, err := zlib.NewReader(bytes.NewReader(content)); err == nil {
+			if inflated, err := io.ReadAll(rc); err == nil {
+				content = inflated
+			}
+			rc.Close()
+		}
+
+		for _, object := range pdfTextObject.FindAllSubmatch(content, -1) {
+			for _, show := range pdfShowText.FindAllSubmatch(object[1], -1) {
+				if len(show[1]) > 0 {
+					builder.WriteString(unescapePDFString(show[1]))
+				} else {
+					for _, part := range pdfArrayText.FindAllSubmatch(show[2], -1) {
+						builder.WriteString(unescapePDFString(part[1]))
+					}
+				}
+			}
+			builder.WriteString("\n")
+		}
+	}
+
+	text := strings.TrimSpace(builder.String())
+	if text == "" {
+		return "", fmt.Errorf("%w: no extractable text in PDF", ErrUnsupported)
+	}
+	return text, nil
+}
+
+func unescapePDFString(raw []byte) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\r`, "", `\t`, "\t", `\(`, "(", `\)`, ")", `\\`, `\`)
+	return replacer.Replace(string(raw))
+}
@@ -0,0 +1,207 @@
+package xlsx
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/integems/report-agent/src/payload"
+)
+
+// Cell styles, indexes into cellXfs of stylesXML
+const (
+	styleDefault = 0
+	styleHeader  = 1
+	styleNumber  = 2
+	styleInteger = 3
+)
+
+// Column widths in characters
+const (
+	minColumnWidth = 8
+	maxColumnWidth = 60
+)
+
+// maxSheetName is the longest sheet name Excel accepts
+const maxSheetName = 31
+
+// stylesXML holds a regular font, a bold white header on a blue fill with a
+// thin border, and number formats for decimals and integers
+const stylesXML = xmlHeader + `<styleSheet xmlns="` + nsMain + `">` +
+	`<fonts count="2">` +
+	`<font><sz val="11"/><color theme="1"/><name val="Calibri"/><family val="2"/><scheme val="minor"/></font>` +
+	`<font><b/><sz val="11"/><color rgb="FFFFFFFF"/><name val="Calibri"/><family val="2"/><scheme val="minor"/></font>` +
+	`</fonts>` +
+	`<fills count="3">` +
+	`<fill><patternFill patternType="none"/></fill>` +
+	`<fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FF4472C4"/><bgColor indexed="64"/></patternFill></fill>` +
+	`</fills>` +
+	`<borders count="2">` +
+	`<border><left/><right/><top/><bottom/><diagonal/></border>` +
+	`<border><left style="thin"><color rgb="FFBFBFBF"/></left><right style="thin"><color rgb="FFBFBFBF"/></right>` +
+	`<top style="thin"><color rgb="FFBFBFBF"/></top><bottom style="thin"><color rgb="FFBFBFBF"/></bottom><diagonal/></border>` +
+	`</borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="4">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="1" xfId="0" applyFont="1" applyFill="1" applyBorder="1" applyAlignment="1">` +
+	`<alignment horizontal="center" vertical="center" wrapText="1"/></xf>` +
+	`<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="3" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
+
+// worksheet renders one sheet. The column labels form the header row and
+// every row of data follows, as in the client download.
+func (b *workbook) worksheet(sheet payload.ExcelData) string {
+	columns := len(sheet.ColumnLabels)
+	for _, row := range sheet.Data {
+		columns = max(columns, len(row))
+	}
+	widths := make([]int, columns)
+
+	var rows strings.Builder
+	rowNumber := 0
+	if len(sheet.ColumnLabels) > 0 {
+		rowNumber++
+		fmt.Fprintf(&rows, `<row r="%d">`, rowNumber)
+		for i, label := range sheet.ColumnLabels {
+			rows.WriteString(b.stringCell(cellName(i, rowNumber), label, styleHeader))
+			widths[i] = max(widths[i], textWidth(label)+2)
+		}
+		rows.WriteString(`</row>`)
+	}
+	for _, row := range sheet.Data {
+		rowNumber++
+		fmt.Fprintf(&rows, `<row r="%d">`, rowNumber)
+		for i, cell := range row {
+			data, width := b.cell(cellName(i, rowNumber), cell.Value)
+			rows.WriteString(data)
+			widths[i] = max(widths[i], width)
+		}
+		rows.WriteString(`</row>`)
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, xmlHeader+`<worksheet xmlns="%s" xmlns:r="%s">`, nsMain, nsR)
+	if rowNumber > 0 && columns > 0 {
+		fmt.Fprintf(&builder, `<dimension ref="A1:%s"/>`, cellName(columns-1, rowNumber))
+	}
+	builder.WriteString(`<sheetViews><sheetView workbookViewId="0">`)
+	if len(sheet.ColumnLabels) > 0 {
+		builder.WriteString(`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/><selection pane="bottomLeft" activeCell="A2" sqref="A2"/>`)
+	}
+	builder.WriteString(`</sheetView></sheetViews><sheetFormatPr defaultRowHeight="15"/>`)
+	if columns > 0 {
+		builder.WriteString(`<cols>`)
+		for i, width := range widths {
+			width = min(max(width, minColumnWidth), maxColumnWidth)
+			fmt.Fprintf(&builder, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, width)
+		}
+		builder.WriteString(`</cols>`)
+	}
+	builder.WriteString(`<sheetData>` + rows.String() + `</sheetData>`)
+	builder.WriteString(`</worksheet>`)
+	return builder.String()
+}
+
+// cell renders a data cell by the JSON type of its value, along with the
+// width it needs. Numbers stay numeric so they can be summed and charted;
+// numeric looking strings such as codes with leading zeros stay text.
+func (b *workbook) cell(name string, value any) (string, int) {
+	switch value := value.(type) {
+	case nil:
+		return "", 0
+	case float64:
+		style := styleNumber
+		if value == math.Trunc(value) {
+			style = styleInteger
+		}
+		formatted := strconv.FormatFloat(value, 'f', -1, 64)
+		return fmt.Sprintf(`<c r="%s" s="%d"><v>%s</v></c>`, name, style, formatted), numberWidth(value, style) + 2
+	case bool:
+		flag := "0"
+		if value {
+			flag = "1"
+		}
+		return fmt.Sprintf(`<c r="%s" t="b"><v>%s</v></c>`, name, flag), 7
+	case string:
+		return b.stringCell(name, value, styleDefault), textWidth(value) + 2
+	default:
+		text := fmt.Sprint(value)
+		return b.stringCell(name, text, styleDefault), textWidth(text) + 2
+	}
+}
+
+// stringCell renders a shared string cell. Text is never read as a formula.
+func (b *workbook) stringCell(name, text string, style int) string {
+	return fmt.Sprintf(`<c r="%s" s="%d" t="s"><v>%d</v></c>`, name, style, b.sharedString(text))
+}
+
+// textWidth is the width of the longest line of text in characters
+func textWidth(text string) int {
+	width := 0
+	for _, line := range strings.Split(text, "\n") {
+		width = max(width, utf8.RuneCountInString(line))
+	}
+	return width
+}
+
+// numberWidth is the width of a number once formatted with thousands
+// separators
+func numberWidth(value float64, style int) int {
+	digits := len(strconv.FormatFloat(math.Abs(math.Trunc(value)), 'f', 0, 64))
+	width := digits + (digits-1)/3
+	if value < 0 {
+		width++
+	}
+	if style == styleNumber {
+		width += 3
+	}
+	return width
+}
+
+// cellName converts a zero based column and a row number to a reference such
+// as "B3"
+func cellName(column, row int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name + strconv.Itoa(row)
+}
+
+// sheetNames returns a valid, unique name for every sheet
+func sheetNames(sheets []payload.ExcelData) []string {
+	replacer := strings.NewReplacer("[", "(", "]", ")", ":", "-", "*", "-", "?", "", "/", "-", "\\", "-")
+	names := make([]string, len(sheets))
+	used := map[string]bool{}
+	for i, sheet := range sheets {
+		name := strings.Trim(strings.TrimSpace(replacer.Replace(sheet.Name)), "'")
+		if name == "" {
+			name = fmt.Sprintf("Sheet%d", i+1)
+		}
+		name = truncate(name, maxSheetName)
+
+		unique := name
+		for n := 2; used[strings.ToLower(unique)]; n++ {
+			suffix := fmt.Sprintf(" (%d)", n)
+			unique = truncate(name, maxSheetName-len(suffix)) + suffix
+		}
+		used[strings.ToLower(unique)] = true
+		names[i] = unique
+	}
+	return names
+}
+
+// truncate shortens text to at most limit characters
+func truncate(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	return string([]rune(text)[:limit])
+}
@@ -0,0 +1,173 @@
+// Package xlsx renders the Excel data of the &&json payload into an OOXML
+// spreadsheet. Every sheet gets a styled, frozen header row built from the
+// column labels, typed cells and column widths fitted to the content.
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/integems/report-agent/src/payload"
+)
+
+// ContentType is the MIME type of a .xlsx file
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// OOXML namespaces
+const (
+	nsMain = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	nsR    = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsRel  = "http://schemas.openxmlformats.org/package/2006/relationships"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+// Options configure the rendering of a workbook
+type Options struct {
+	Title  string
+	Author string
+}
+
+// part is a file of the package
+type part struct {
+	name string
+	data string
+}
+
+// workbook collects the parts and the shared strings while the sheets are
+// rendered
+type workbook struct {
+	parts   []part
+	strings []string
+	indexes map[string]int
+}
+
+func (b *workbook) add(name, data string) {
+	b.parts = append(b.parts, part{name: name, data: data})
+}
+
+// sharedString returns the index of text in the shared string table
+func (b *workbook) sharedString(text string) int {
+	if index, ok := b.indexes[text]; ok {
+		return index
+	}
+	index := len(b.strings)
+	b.strings = append(b.strings, text)
+	b.indexes[text] = index
+	return index
+}
+
+// Render writes the sheets as a .xlsx file
+func Render(w io.Writer, sheets []payload.ExcelData, options Options) error {
+	if len(sheets) == 0 {
+		return fmt.Errorf("workbook has no sheets")
+	}
+
+	b := &workbook{indexes: map[string]int{}}
+	names := sheetNames(sheets)
+	for i, sheet := range sheets {
+		b.add(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), b.worksheet(sheet))
+	}
+	b.addWorkbook(names, options)
+
+	archive := zip.NewWriter(w)
+	for _, part := range append([]part{{name: "[Content_Types].xml", data: contentTypes(len(sheets))}}, b.parts...) {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", part.name, err)
+		}
+		if _, err := io.WriteString(file, part.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+	}
+	return archive.Close()
+}
+
+// addWorkbook adds the parts shared by every sheet
+func (b *workbook) addWorkbook(names []string, options Options) {
+	b.add("_rels/.rels", relationshipsXML([]string{
+		nsR + "/officeDocument", "xl/workbook.xml",
+		"http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties", "docProps/core.xml",
+		nsR + "/extended-properties", "docProps/app.xml",
+	}))
+
+	title := options.Title
+	if title == "" {
+		title = "Spreadsheet"
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	b.add("docProps/core.xml", xmlHeader+`<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`+
+		`<dc:title>`+esc(title)+`</dc:title><dc:creator>`+esc(options.Author)+`</dc:creator>`+
+		`<dcterms:created xsi:type="dcterms:W3CDTF">`+now+`</dcterms:created><dcterms:modified xsi:type="dcterms:W3CDTF">`+now+`</dcterms:modified></cp:coreProperties>`)
+	b.add("docProps/app.xml", xmlHeader+`<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties"><Application>INTEGEMS</Application></Properties>`)
+
+	var sheets strings.Builder
+	var rels []string
+	for i, name := range names {
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, esc(name), i+1, i+1)
+		rels = append(rels, nsR+"/worksheet", fmt.Sprintf("worksheets/sheet%d.xml", i+1))
+	}
+	rels = append(rels, nsR+"/styles", "styles.xml", nsR+"/sharedStrings", "sharedStrings.xml")
+
+	b.add("xl/workbook.xml", xmlHeader+fmt.Sprintf(`<workbook xmlns="%s" xmlns:r="%s">`, nsMain, nsR)+
+		`<bookViews><workbookView activeTab="0"/></bookViews>`+
+		`<sheets>`+sheets.String()+`</sheets></workbook>`)
+	b.add("xl/_rels/workbook.xml.rels", relationshipsXML(rels))
+	b.add("xl/styles.xml", stylesXML)
+
+	var shared strings.Builder
+	fmt.Fprintf(&shared, xmlHeader+`<sst xmlns="%s" count="%d" uniqueCount="%d">`, nsMain, len(b.strings), len(b.strings))
+	for _, text := range b.strings {
+		space := ""
+		if strings.TrimSpace(text) != text {
+			space = ` xml:space="preserve"`
+		}
+		fmt.Fprintf(&shared, `<si><t%s>%s</t></si>`, space, esc(text))
+	}
+	shared.WriteString(`</sst>`)
+	b.add("xl/sharedStrings.xml", shared.String())
+}
+
+// relationshipsXML builds a relationships part from type and target pairs
+func relationshipsXML(pairs []string) string {
+	var builder strings.Builder
+	builder.WriteString(xmlHeader + `<Relationships xmlns="` + nsRel + `">`)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fmt.Fprintf(&builder, `<Relationship Id="rId%d" Type="%s" Target="%s"/>`, i/2+1, pairs[i], pairs[i+1])
+	}
+	builder.WriteString(`</Relationships>`)
+	return builder.String()
+}
+
+func contentTypes(sheetCount int) string {
+	const ml = "application/vnd.openxmlformats-officedocument.spreadsheetml."
+	var builder strings.Builder
+	builder.WriteString(xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	builder.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	builder.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+
+	override := func(name, contentType string) {
+		fmt.Fprintf(&builder, `<Override PartName="/%s" ContentType="%s"/>`, name, contentType)
+	}
+	override("xl/workbook.xml", ml+"sheet.main+xml")
+	override("xl/styles.xml", ml+"styles+xml")
+	override("xl/sharedStrings.xml", ml+"sharedStrings+xml")
+	override("docProps/core.xml", "application/vnd.openxmlformats-package.core-properties+xml")
+	override("docProps/app.xml", "application/vnd.openxmlformats-officedocument.extended-properties+xml")
+	for i := 1; i <= sheetCount; i++ {
+		override(fmt.Sprintf("xl/worksheets/sheet%d.xml", i), ml+"worksheet+xml")
+	}
+	builder.WriteString(`</Types>`)
+	return builder.String()
+}
+
+// esc escapes text for use in XML content and attributes
+func esc(text string) string {
+	var builder strings.Builder
+	xml.EscapeText(&builder, []byte(text))
+	return builder.String()
+}